@@ -1,23 +1,56 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"cluely/server/internal/answer"
+	"cluely/server/internal/config"
 	"cluely/server/internal/obs"
+	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
 	wsHandler "cluely/server/internal/ws"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:]))
+	}
+
+	// Defaults, then -config file, then env, then flags
+	loader, err := config.NewLoader("cluelyd", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	// Structured logs; customer text is redacted unless a debug build says
+	// otherwise
+	obs.SetupLogging(cfg.Log)
+
 	// Leave a CPU for other processes to keep latency sensible
 	runtime.GOMAXPROCS(max(1, runtime.NumCPU()-1))
 
-	ln, err := net.Listen("tcp", ":8080")
+	ln, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		log.Fatalf("listen: %v", err)
 	}
@@ -26,17 +59,107 @@ func main() {
 		_ = tcpln.SetDeadline(time.Time{})
 	}
 
+	rt.DefaultBreakers.Config = cfg.Breaker
+	rt.DefaultBreakers.OnChange = func(name string, from, to rt.BreakerState) {
+		log.Printf("[breaker] %s %s -> %s", name, from, to)
+		obs.SetBreakerState(name, to.String())
+	}
+
+	// Export traces if the config asks for it
+	exp, err := trace.NewExporter(cfg.Tracing)
+	if err != nil {
+		log.Printf("tracing disabled: %v", err)
+	}
+	if exp != nil {
+		trace.SetExporter(exp)
+		log.Printf("tracing enabled (%s)", cfg.Tracing.Exporter)
+	}
+
+	answer.DefaultPrompts.SetDir(cfg.LLM.PromptDir)
+	ws := wsHandler.NewHandler(cfg.SessionOptions())
+	go reloadOnHangup(loader, cfg, ws)
+
 	r := chi.NewRouter()
-	r.Get("/healthz", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("ok")) })
-	r.Get("/ws", wsHandler.Handle)
+	r.Get("/healthz", healthz)
+	r.Method(http.MethodGet, "/ws", ws)
+	r.Handle("/metrics", obs.Default.Handler())
 
-	// Start metrics logger (every 30s)
-	obs.StartMetricsLogger(30 * time.Second)
+	// Start metrics logger (0 turns it off)
+	if cfg.MetricsInterval > 0 {
+		obs.StartMetricsLogger(cfg.MetricsInterval)
+	}
 
-	log.Println("cluelyd listening :8080")
+	log.Printf("cluelyd listening %s", cfg.Addr)
 	if err := http.Serve(ln, r); err != nil {
 		log.Fatalf("serve: %v", err)
 	}
 }
 
+// healthz reports liveness plus the upstream circuit breakers. It answers
+// 200 even when an upstream is down: the process itself is fine.
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	breakers := rt.DefaultBreakers.Status()
+	status := "ok"
+	for _, b := range breakers {
+		if b.State != rt.BreakerClosed.String() {
+			status = "degraded"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Status   string             `json:"status"`
+		Breakers []rt.BreakerStatus `json:"breakers"`
+	}{status, breakers})
+}
+
+// checkConfig loads the config as the server would and prints it with
+// secrets masked. The exit status says whether it is valid.
+func checkConfig(args []string) int {
+	loader, err := config.NewLoader("cluelyd check-config", args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		return 1
+	}
+	if err := cfg.Redacted().WriteYAML(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// reloadOnHangup re-reads the config on SIGHUP. An invalid config is
+// logged and ignored. Settings that only take effect at startup keep
+// their values until a restart; the rest apply to new sessions.
+func reloadOnHangup(loader *config.Loader, cfg config.Config, ws *wsHandler.Handler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		next, err := loader.Load()
+		if err != nil {
+			slog.Error("config reload failed; keeping the running config", "err", err)
+			continue
+		}
+		next, kept := config.Reload(cfg, next)
+		if len(kept) > 0 {
+			slog.Warn("config changes need a restart", "fields", kept)
+		}
+		obs.SetLogLevel(next.Log.Level)
+		// A new random key would break hash matching, so only re-key on a change
+		if next.Log.Redact != cfg.Log.Redact || next.Log.RedactKey != cfg.Log.RedactKey {
+			obs.SetRedaction(next.Log.Redact, next.Log.RedactKey)
+		}
+		answer.DefaultPrompts.SetDir(next.LLM.PromptDir)
+		ws.SetOptions(next.SessionOptions())
+		cfg = next
+		slog.Info("config reloaded", "file", loader.Path)
+	}
+}
+
 func max(a, b int) int { if a > b { return a }; return b }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"cluely/server/internal/protocol"
+)
+
+// Writes the WebSocket protocol JSON Schema. Run via `go generate ./...`.
+func main() {
+	out := flag.String("o", "", "output file (default stdout)")
+	flag.Parse()
+
+	b, err := protocol.Schema()
+	if err != nil {
+		log.Fatalf("render schema: %v", err)
+	}
+	if *out == "" {
+		_, _ = os.Stdout.Write(b)
+		return
+	}
+	if err := os.WriteFile(*out, b, 0o644); err != nil {
+		log.Fatalf("write %s: %v", *out, err)
+	}
+}
@@ -0,0 +1,131 @@
+package answer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cluely/server/internal/trace"
+)
+
+const (
+	defaultAnthropicBaseURL = "https://api.anthropic.com/v1"
+	defaultAnthropicModel   = "claude-3-5-haiku-latest"
+	anthropicVersion        = "2023-06-01"
+)
+
+// anthropicProvider calls a Messages-style /v1/messages endpoint. It does not
+// stream yet, so MicroStream delivers its hint as one complete Partial.
+type anthropicProvider struct {
+	apiKey  string
+	model   string
+	client  *http.Client
+	baseURL string
+}
+
+func newAnthropicProvider(ep Endpoint) *anthropicProvider {
+	return &anthropicProvider{
+		apiKey:  strings.TrimSpace(ep.APIKey),
+		model:   orDefault(ep.Model, defaultAnthropicModel),
+		client:  trace.NewClient(requestTimeout),
+		baseURL: orDefault(ep.BaseURL, defaultAnthropicBaseURL),
+	}
+}
+
+func (p *anthropicProvider) Name() string { return "anthropic" }
+
+func (p *anthropicProvider) configured() error {
+	if p.apiKey == "" {
+		return fmt.Errorf("%w: anthropic needs an API key (ANTHROPIC_API_KEY or llm.anthropic.apiKey)", ErrNotConfigured)
+	}
+	return nil
+}
+
+func (p *anthropicProvider) Generate(ctx context.Context, req GenerateRequest) (string, error) {
+	if err := p.configured(); err != nil {
+		return "", err
+	}
+	maxTokens := req.MaxOutputTokens
+	if maxTokens == 0 {
+		maxTokens = 256
+	}
+	payload := anthropicRequest{
+		Model:       p.model,
+		MaxTokens:   maxTokens,
+		Messages:    []anthropicMessage{{Role: "user", Content: req.Prompt}},
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
+		return "", fmt.Errorf("encode request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.baseURL, "/")+"/messages", &buf)
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		var apiErr anthropicError
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+			return "", fmt.Errorf("anthropic http %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return "", fmt.Errorf("anthropic http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	for _, block := range out.Content {
+		if block.Type == "text" && strings.TrimSpace(block.Text) != "" {
+			return strings.TrimSpace(block.Text), nil
+		}
+	}
+	return "", errors.New("anthropic returned no text content")
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature float64            `json:"temperature,omitempty"`
+	TopP        float64            `json:"top_p,omitempty"`
+	TopK        int                `json:"top_k,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+type anthropicError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
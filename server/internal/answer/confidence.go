@@ -0,0 +1,84 @@
+package answer
+
+import (
+	"math"
+	"strings"
+)
+
+// Signals are the evidence behind a hint's confidence, each scaled 0..1.
+// Model and Logprob count only when their Has flag is set; ASR and Context
+// count when non-zero.
+type Signals struct {
+	// Model is the confidence the model reported for its own hint.
+	Model    float64
+	HasModel bool
+	// Logprob is the mean token log probability of the generation.
+	Logprob    float64
+	HasLogprob bool
+	// ASR is the recognizer's confidence in the transcript.
+	ASR float64
+	// Context is how much the hint had to go on; see contextRichness.
+	Context float64
+}
+
+// Signal weights. Self-reported confidence is cheap for a model to inflate,
+// so it does not outweigh the others combined.
+const (
+	weightModel   = 0.35
+	weightLogprob = 0.25
+	weightASR     = 0.2
+	weightContext = 0.2
+)
+
+// Score is the weighted mean of the known signals, or 0.5 when nothing is
+// known.
+func (s Signals) Score() float64 {
+	var sum, weight float64
+	add := func(v, w float64) {
+		sum += clamp01(v) * w
+		weight += w
+	}
+	if s.HasModel {
+		add(s.Model, weightModel)
+	}
+	if s.HasLogprob {
+		// exp of the mean log probability is the geometric mean token
+		// probability.
+		add(math.Exp(s.Logprob), weightLogprob)
+	}
+	if s.ASR > 0 {
+		add(s.ASR, weightASR)
+	}
+	if s.Context > 0 {
+		add(s.Context, weightContext)
+	}
+	if weight == 0 {
+		return 0.5
+	}
+	return math.Round(sum/weight*100) / 100
+}
+
+// contextRichness rates how much a hint had to go on: a few words with no
+// screen or conversation context make for a guess.
+func contextRichness(transcript string, tokens []string, mem Memory) float64 {
+	var r float64
+	switch n := len(strings.Fields(transcript)); {
+	case n < 3:
+		r = 0.3
+	case n < 6:
+		r = 0.5
+	default:
+		r = 0.7
+	}
+	if len(tokens) > 0 {
+		r += 0.15
+	}
+	if len(mem.Turns) > 0 || mem.Summary != "" {
+		r += 0.15
+	}
+	return clamp01(r)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,81 @@
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignalsScore(t *testing.T) {
+	cases := []struct {
+		name string
+		in   Signals
+		want float64
+	}{
+		{"nothing known", Signals{}, 0.5},
+		{"model only", Signals{Model: 0.9, HasModel: true}, 0.9},
+		{"model said zero", Signals{HasModel: true}, 0},
+		{"logprob", Signals{Logprob: math.Log(0.8), HasLogprob: true}, 0.8},
+		// (0.9*0.35 + 0.4*0.2 + 0.3*0.2) / 0.75
+		{"weighted", Signals{Model: 0.9, HasModel: true, ASR: 0.4, Context: 0.3}, 0.61},
+	}
+	for _, tc := range cases {
+		if got := tc.in.Score(); got != tc.want {
+			t.Errorf("%s: Score() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestContextRichness(t *testing.T) {
+	if got := contextRichness("huh", nil, Memory{}); got != 0.3 {
+		t.Fatalf("bare fragment = %v, want 0.3", got)
+	}
+	mem := Memory{Turns: []Turn{{Text: "we spoke about budget"}}}
+	if got := contextRichness("what does the pilot cost per seat", []string{"pricing"}, mem); got != 1 {
+		t.Fatalf("full context = %v, want 1", got)
+	}
+}
+
+func TestParseAnswerKeepsModelConfidence(t *testing.T) {
+	ans, err := parseAnswer(`{"confidence":0.2,"answer":"Ask which team","followUp":"Who uses it?"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ans.Signals.HasModel || ans.Signals.Model != 0.2 || ans.Confidence != 0.2 {
+		t.Fatalf("unexpected confidence %v from %+v", ans.Confidence, ans.Signals)
+	}
+	ans, err = parseAnswer(`{"answer":"Ask which team","followUp":"Who uses it?"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans.Signals.HasModel || ans.Confidence != 0.5 {
+		t.Fatalf("missing confidence should be unknown, got %v from %+v", ans.Confidence, ans.Signals)
+	}
+}
+
+func TestOpenAIProviderReportsLogprobs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Logprobs {
+			t.Fatalf("expected logprobs in request, got %+v (%v)", req, err)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"{\"confidence\":0.9,\"answer\":\"Name the owner\",\"followUp\":\"Who decides?\"}"},"logprobs":{"content":[{"logprob":-0.1},{"logprob":-0.3}]}}]}`))
+	}))
+	defer srv.Close()
+
+	svc := NewService(&openAIProvider{model: "m", baseURL: srv.URL, client: srv.Client()})
+	svc.logprobs = true
+	ans := svc.Micro(context.Background(), "who signs", nil, nil, nil, Memory{})
+	if ans == nil {
+		t.Fatal("no answer")
+	}
+	if !ans.Signals.HasLogprob || math.Abs(ans.Signals.Logprob+0.2) > 1e-9 {
+		t.Fatalf("expected mean logprob -0.2, got %+v", ans.Signals)
+	}
+	if ans.Signals.Context != 0.3 || ans.Confidence != ans.Signals.Score() {
+		t.Fatalf("confidence %v does not match signals %+v", ans.Confidence, ans.Signals)
+	}
+}
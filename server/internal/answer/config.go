@@ -0,0 +1,69 @@
+package answer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Config selects and tunes the hint engine. The zero value is Gemini with
+// its defaults.
+type Config struct {
+	// Provider is gemini (default), openai, ollama, llamacpp, anthropic or
+	// rules; see NewProvider.
+	Provider string `yaml:"provider"`
+	// Mode is the coaching persona sessions start with.
+	Mode string `yaml:"mode"`
+	// Logprobs asks providers for token log probabilities to score hints.
+	Logprobs bool `yaml:"logprobs"`
+	// PromptDir holds *.tmpl files overriding or adding personas; see
+	// Prompts.
+	PromptDir string `yaml:"promptDir"`
+
+	Gemini    Endpoint `yaml:"gemini"`
+	OpenAI    Endpoint `yaml:"openai"`
+	Anthropic Endpoint `yaml:"anthropic"`
+
+	// Logger receives provider errors and retries; nil means slog.Default().
+	// Sessions set their own so these lines carry the session id.
+	Logger *slog.Logger `yaml:"-"`
+}
+
+// Endpoint says where a provider is and how to authenticate. Empty fields
+// take the provider's defaults.
+type Endpoint struct {
+	APIKey  string `yaml:"apiKey"`
+	Model   string `yaml:"model"`
+	BaseURL string `yaml:"baseURL"`
+}
+
+func (c Config) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
+
+// DefaultConfig returns the production hint engine settings.
+func DefaultConfig() Config {
+	return Config{Provider: "gemini", Mode: DefaultMode}
+}
+
+// Validate reports a provider that does not exist or a mode that no prompt
+// template, built in or in PromptDir, defines. A provider without its API
+// key is only logged: the server runs without one and hints fail fast until
+// it is set.
+func (c Config) Validate() error {
+	var errs []error
+	if p, err := NewProvider(c); err != nil {
+		errs = append(errs, err)
+	} else if err := checkConfigured(p); err != nil {
+		slog.Warn("hints are disabled", "err", err)
+	}
+	mode := strings.ToLower(strings.TrimSpace(c.Mode))
+	if p := NewPrompts(c.PromptDir); mode != "" && !p.Has(mode) {
+		errs = append(errs, fmt.Errorf("unknown mode %q (have %s)", mode, strings.Join(p.Modes(), ", ")))
+	}
+	return errors.Join(errs...)
+}
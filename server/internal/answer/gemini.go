@@ -0,0 +1,308 @@
+package answer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
+)
+
+const (
+	geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	defaultGemini = "gemini-1.5-flash"
+)
+
+// geminiProvider calls Google's generateContent endpoint.
+type geminiProvider struct {
+	apiKey  string
+	model   string
+	client  *http.Client
+	baseURL string
+	log     *slog.Logger // nil means slog.Default()
+}
+
+func newGeminiProvider(ep Endpoint) *geminiProvider {
+	return &geminiProvider{
+		apiKey:  strings.TrimSpace(ep.APIKey),
+		model:   orDefault(ep.Model, defaultGemini),
+		client:  trace.NewClient(requestTimeout),
+		baseURL: orDefault(ep.BaseURL, geminiBaseURL),
+	}
+}
+
+func (p *geminiProvider) Name() string { return "gemini" }
+
+func (p *geminiProvider) configured() error {
+	if p.apiKey == "" {
+		return fmt.Errorf("%w: gemini needs an API key (GEMINI_API_KEY or llm.gemini.apiKey)", ErrNotConfigured)
+	}
+	return nil
+}
+
+func (p *geminiProvider) Generate(ctx context.Context, req GenerateRequest) (string, error) {
+	resp, err := p.do(ctx, req, "generateContent")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var genResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+
+	addGeminiLogprobs(req.Logprobs, genResp.Candidates)
+	candidateText := extractCandidateText(genResp.Candidates)
+	if candidateText == "" {
+		return "", errors.New("gemini returned empty candidate text")
+	}
+	return candidateText, nil
+}
+
+// Stream uses streamGenerateContent over SSE. Each event carries the next
+// slice of text, which is appended to what came before.
+func (p *geminiProvider) Stream(ctx context.Context, req GenerateRequest, onText func(string)) (string, error) {
+	resp, err := p.do(ctx, req, "streamGenerateContent")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var (
+		acc      strings.Builder
+		parseErr error
+	)
+	err = readSSE(resp.Body, func(data string) bool {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			parseErr = fmt.Errorf("decode stream chunk: %w", err)
+			return false
+		}
+		for _, c := range chunk.Candidates {
+			for _, part := range c.Content.Parts {
+				acc.WriteString(part.Text)
+			}
+		}
+		addGeminiLogprobs(req.Logprobs, chunk.Candidates)
+		onText(acc.String())
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("read stream: %w", err)
+	}
+	if parseErr != nil {
+		return "", parseErr
+	}
+	text := strings.TrimSpace(acc.String())
+	if text == "" {
+		return "", errors.New("gemini returned empty candidate text")
+	}
+	return text, nil
+}
+
+// do posts req to the given model method and returns a successful response.
+func (p *geminiProvider) do(ctx context.Context, req GenerateRequest, method string) (*http.Response, error) {
+	if err := p.configured(); err != nil {
+		return nil, err
+	}
+	requestPayload := geminiRequest{
+		Contents: []geminiContent{
+			{
+				Role:  "user",
+				Parts: []geminiPart{{Text: req.Prompt}},
+			},
+		},
+		GenerationConfig: &geminiGenerationConfig{
+			Temperature:     req.Temperature,
+			TopP:            req.TopP,
+			TopK:            req.TopK,
+			MaxOutputTokens: req.MaxOutputTokens,
+		},
+	}
+	if req.JSON {
+		requestPayload.GenerationConfig.ResponseMimeType = "application/json"
+		requestPayload.GenerationConfig.ResponseSchema = toGeminiSchema(req.Schema)
+	}
+	if req.Logprobs != nil {
+		requestPayload.GenerationConfig.ResponseLogprobs = true
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(requestPayload); err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	// The key goes in a header: request errors quote the URL, and they end
+	// up in logs and spans.
+	url := fmt.Sprintf("%s/models/%s:%s", strings.TrimRight(p.baseURL, "/"), p.model, method)
+	if method == "streamGenerateContent" {
+		url += "?alt=sse"
+	}
+	payload := buf.Bytes()
+	retry := geminiRetry
+	retry.OnRetry = func(attempt int, err error) {
+		obs.IncRetryAnswer()
+		p.logger().Warn("llm request failed, retrying", "provider", "gemini", "attempt", attempt, "err", err)
+	}
+	resp, err := retry.Do(ctx, p.client, func(ctx context.Context) (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("x-goog-api-key", p.apiKey)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, parseGeminiError(resp)
+	}
+	return resp, nil
+}
+
+// geminiRetry retries rate limits and outages within the request's
+// deadline, which for hints is how long the hint is still worth showing.
+var geminiRetry = rt.RetryPolicy{Retryable: geminiRetryable}
+
+func (p *geminiProvider) logger() *slog.Logger {
+	if p.log == nil {
+		return slog.Default()
+	}
+	return p.log
+}
+
+// geminiRetryable goes by error.status when Gemini sends one, so that an
+// INVALID_ARGUMENT or PERMISSION_DENIED fails at once whatever the HTTP
+// status, and falls back to the status code otherwise.
+func geminiRetryable(status int, body []byte) bool {
+	var apiErr geminiError
+	if json.Unmarshal(body, &apiErr) == nil {
+		if retry, known := rt.RetryableRPCStatus(apiErr.Error.Status); known {
+			return retry
+		}
+	}
+	return rt.RetryableStatus(status)
+}
+
+func parseGeminiError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("gemini http %d: failed to read error body: %w", resp.StatusCode, err)
+	}
+	var apiErr geminiError
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.Message != "" {
+		return fmt.Errorf("gemini http %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+	return fmt.Errorf("gemini http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// addGeminiLogprobs records the first candidate's average log probability.
+// A stream reports one per chunk; each counts once.
+func addGeminiLogprobs(lp *Logprobs, candidates []geminiCandidate) {
+	if lp == nil || len(candidates) == 0 || candidates[0].AvgLogprobs == 0 {
+		return
+	}
+	lp.Add(candidates[0].AvgLogprobs, 1)
+}
+
+func extractCandidateText(candidates []geminiCandidate) string {
+	for _, c := range candidates {
+		for _, part := range c.Content.Parts {
+			if t := strings.TrimSpace(part.Text); t != "" {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+type geminiRequest struct {
+	Contents         []geminiContent         `json:"contents"`
+	GenerationConfig *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     float64 `json:"temperature,omitempty"`
+	TopP            float64 `json:"topP,omitempty"`
+	TopK            int     `json:"topK,omitempty"`
+	MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+
+	ResponseMimeType string        `json:"responseMimeType,omitempty"`
+	ResponseSchema   *geminiSchema `json:"responseSchema,omitempty"`
+	ResponseLogprobs bool          `json:"responseLogprobs,omitempty"`
+}
+
+// geminiSchema is Gemini's OpenAPI-style schema: upper-case types and an
+// explicit property order.
+type geminiSchema struct {
+	Type             string                   `json:"type"`
+	Description      string                   `json:"description,omitempty"`
+	Properties       map[string]*geminiSchema `json:"properties,omitempty"`
+	Required         []string                 `json:"required,omitempty"`
+	PropertyOrdering []string                 `json:"propertyOrdering,omitempty"`
+}
+
+func toGeminiSchema(s *Schema) *geminiSchema {
+	if s == nil {
+		return nil
+	}
+	out := &geminiSchema{
+		Type:             strings.ToUpper(s.Type),
+		Description:      s.Description,
+		Required:         s.Required,
+		PropertyOrdering: s.Order,
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*geminiSchema, len(s.Properties))
+		for name, p := range s.Properties {
+			out.Properties[name] = toGeminiSchema(p)
+		}
+	}
+	return out
+}
+
+type geminiResponse struct {
+	Candidates []geminiCandidate `json:"candidates"`
+}
+
+type geminiCandidate struct {
+	Content struct {
+		Parts []geminiPart `json:"parts"`
+	} `json:"content"`
+	AvgLogprobs float64 `json:"avgLogprobs,omitempty"`
+}
+
+type geminiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
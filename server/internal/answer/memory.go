@@ -0,0 +1,160 @@
+package answer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Turn is one finalized utterance in the conversation.
+type Turn struct {
+	Speaker string
+	Text    string
+	At      time.Time
+}
+
+// Memory is what the prompt sees of the conversation so far: the recent turns
+// verbatim plus a running summary of everything older.
+type Memory struct {
+	Turns   []Turn
+	Summary string
+	Now     time.Time
+}
+
+// Summarizer folds turns that fell out of the window into the running summary.
+type Summarizer func(prev string, evicted []Turn) (string, error)
+
+// Conversation is a per-session rolling buffer of finals, bounded by an
+// estimated token budget and by age. Turns pushed out are handed to the
+// optional Summarizer in the background so the gist survives.
+type Conversation struct {
+	MaxTokens  int
+	MaxAge     time.Duration
+	Summarizer Summarizer
+	Clock      func() time.Time
+
+	mu          sync.Mutex
+	turns       []Turn
+	tokens      int
+	summary     string
+	pending     []Turn
+	summarizing bool
+}
+
+// NewConversation returns a buffer holding at most maxTokens of turns no
+// older than maxAge. Zero disables the respective bound.
+func NewConversation(maxTokens int, maxAge time.Duration) *Conversation {
+	return &Conversation{MaxTokens: maxTokens, MaxAge: maxAge}
+}
+
+func (c *Conversation) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+// Add appends a final. Empty text is ignored.
+func (c *Conversation) Add(speaker, text string) {
+	text = strings.TrimSpace(text)
+	if c == nil || text == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.turns = append(c.turns, Turn{Speaker: speaker, Text: text, At: now})
+	c.tokens += estimateTokens(text)
+	c.trimLocked(now)
+}
+
+// Snapshot returns a copy of the current window and summary.
+func (c *Conversation) Snapshot() Memory {
+	if c == nil {
+		return Memory{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.trimLocked(now)
+	turns := make([]Turn, len(c.turns))
+	copy(turns, c.turns)
+	return Memory{Turns: turns, Summary: c.summary, Now: now}
+}
+
+// trimLocked evicts turns over budget or past MaxAge. Caller holds c.mu.
+func (c *Conversation) trimLocked(now time.Time) {
+	n := 0
+	for n < len(c.turns) {
+		t := c.turns[n]
+		overAge := c.MaxAge > 0 && now.Sub(t.At) > c.MaxAge
+		// Always keep the newest turn, however long it is.
+		overBudget := c.MaxTokens > 0 && c.tokens > c.MaxTokens && n < len(c.turns)-1
+		if !overAge && !overBudget {
+			break
+		}
+		c.tokens -= estimateTokens(t.Text)
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	evicted := append([]Turn(nil), c.turns[:n]...)
+	c.turns = append(c.turns[:0:0], c.turns[n:]...)
+	if c.Summarizer == nil {
+		return
+	}
+	c.pending = append(c.pending, evicted...)
+	if !c.summarizing {
+		c.summarizing = true
+		go c.summarize()
+	}
+}
+
+// summarize drains pending turns into the summary, one call at a time.
+func (c *Conversation) summarize() {
+	for {
+		c.mu.Lock()
+		if len(c.pending) == 0 {
+			c.summarizing = false
+			c.mu.Unlock()
+			return
+		}
+		batch := c.pending
+		c.pending = nil
+		prev := c.summary
+		c.mu.Unlock()
+
+		next, err := c.Summarizer(prev, batch)
+		if err != nil || strings.TrimSpace(next) == "" {
+			// Keep going with the old summary; losing detail beats blocking.
+			continue
+		}
+		c.mu.Lock()
+		c.summary = strings.TrimSpace(next)
+		c.mu.Unlock()
+	}
+}
+
+// estimateTokens approximates LLM tokens as one per four bytes of text.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// formatTurns renders turns oldest first with their age relative to now.
+func formatTurns(turns []Turn, now time.Time) string {
+	var sb strings.Builder
+	for _, t := range turns {
+		speaker := t.Speaker
+		if speaker == "" {
+			speaker = "speaker"
+		}
+		ago := now.Sub(t.At).Round(time.Second)
+		if ago < 0 {
+			ago = 0
+		}
+		fmt.Fprintf(&sb, "[-%s] %s: %s\n", ago, speaker, t.Text)
+	}
+	return sb.String()
+}
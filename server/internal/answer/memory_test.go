@@ -0,0 +1,87 @@
+package answer
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConversationEvictsByBudgetAndSummarizes(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewConversation(10, 0) // ~40 bytes of text
+	c.Clock = func() time.Time { return now }
+
+	var (
+		mu      sync.Mutex
+		evicted []string
+		done    = make(chan struct{}, 4)
+	)
+	c.Summarizer = func(prev string, turns []Turn) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, t := range turns {
+			evicted = append(evicted, t.Text)
+		}
+		done <- struct{}{}
+		return strings.TrimSpace(prev + " " + strings.Join(evicted, "; ")), nil
+	}
+
+	c.Add("customer", "Our renewal is due in March.")
+	c.Add("rep", "What budget did finance approve?")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("summarizer was not called on overflow")
+	}
+	mem := c.Snapshot()
+	if len(mem.Turns) != 1 || mem.Turns[0].Speaker != "rep" {
+		t.Fatalf("expected only the newest turn to remain, got %+v", mem.Turns)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(evicted) != 1 || evicted[0] != "Our renewal is due in March." {
+		t.Fatalf("unexpected evicted turns: %v", evicted)
+	}
+	if !strings.Contains(mem.Summary, "renewal") {
+		t.Fatalf("summary not updated: %q", mem.Summary)
+	}
+}
+
+func TestConversationEvictsByAge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewConversation(0, time.Minute)
+	c.Clock = func() time.Time { return now }
+
+	c.Add("", "first")
+	now = now.Add(45 * time.Second)
+	c.Add("", "second")
+	now = now.Add(30 * time.Second)
+
+	mem := c.Snapshot()
+	if len(mem.Turns) != 1 || mem.Turns[0].Text != "second" {
+		t.Fatalf("expected stale turn to age out, got %+v", mem.Turns)
+	}
+}
+
+func TestBuildPromptIncludesMemory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mem := Memory{
+		Summary: "Customer is renewing in March; finance owns budget.",
+		Turns: []Turn{
+			{Speaker: "customer", Text: "We also need SSO.", At: now.Add(-30 * time.Second)},
+		},
+		Now: now,
+	}
+	got := buildPrompt(DefaultMode, "Can you send pricing?", nil, nil, nil, mem)
+	for _, substr := range []string{
+		"Conversation so far (summary):\nCustomer is renewing in March; finance owns budget.",
+		"[-30s] customer: We also need SSO.",
+		"Transcript:\nCan you send pricing?",
+	} {
+		if !strings.Contains(got, substr) {
+			t.Fatalf("prompt missing %q\nfull prompt:\n%s", substr, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package answer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cluely/server/internal/trace"
+)
+
+const (
+	defaultOpenAIBaseURL   = "https://api.openai.com/v1"
+	defaultOllamaBaseURL   = "http://localhost:11434/v1"
+	defaultLlamaCppBaseURL = "http://localhost:8081/v1"
+	defaultOpenAIModel     = "gpt-4o-mini"
+)
+
+// openAIProvider speaks the /v1/chat/completions dialect shared by OpenAI,
+// Ollama, llama.cpp's server, vLLM and most other self-hosted runtimes.
+type openAIProvider struct {
+	apiKey  string
+	model   string
+	client  *http.Client
+	baseURL string
+}
+
+func newOpenAIProvider(ep Endpoint, defaultBase string) *openAIProvider {
+	return &openAIProvider{
+		apiKey:  strings.TrimSpace(ep.APIKey),
+		model:   orDefault(ep.Model, defaultOpenAIModel),
+		client:  trace.NewClient(requestTimeout),
+		baseURL: orDefault(ep.BaseURL, defaultBase),
+	}
+}
+
+func (p *openAIProvider) Name() string { return "openai" }
+
+func (p *openAIProvider) Generate(ctx context.Context, req GenerateRequest) (string, error) {
+	resp, err := p.do(ctx, req, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	for _, c := range out.Choices {
+		if t := strings.TrimSpace(c.Message.Content); t != "" {
+			c.Logprobs.add(req.Logprobs)
+			return t, nil
+		}
+	}
+	return "", errors.New("openai returned empty choice")
+}
+
+// Stream sets stream:true and accumulates choices[0].delta.content.
+func (p *openAIProvider) Stream(ctx context.Context, req GenerateRequest, onText func(string)) (string, error) {
+	resp, err := p.do(ctx, req, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var (
+		acc      strings.Builder
+		parseErr error
+	)
+	err = readSSE(resp.Body, func(data string) bool {
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			parseErr = fmt.Errorf("decode stream chunk: %w", err)
+			return false
+		}
+		if len(chunk.Choices) > 0 {
+			chunk.Choices[0].Logprobs.add(req.Logprobs)
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			acc.WriteString(chunk.Choices[0].Delta.Content)
+			onText(acc.String())
+		}
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("read stream: %w", err)
+	}
+	if parseErr != nil {
+		return "", parseErr
+	}
+	text := strings.TrimSpace(acc.String())
+	if text == "" {
+		return "", errors.New("openai returned empty choice")
+	}
+	return text, nil
+}
+
+func (p *openAIProvider) do(ctx context.Context, req GenerateRequest, stream bool) (*http.Response, error) {
+	payload := openAIRequest{
+		Model:       p.model,
+		Messages:    []openAIMessage{{Role: "user", Content: req.Prompt}},
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxOutputTokens,
+		Stream:      stream,
+		Logprobs:    req.Logprobs != nil,
+	}
+	if req.JSON {
+		payload.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.baseURL, "/")+"/chat/completions", &buf)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	// Local servers usually run without auth.
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var apiErr openAIError
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("openai http %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("openai http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    float64               `json:"temperature,omitempty"`
+	TopP           float64               `json:"top_p,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	Logprobs       bool                  `json:"logprobs,omitempty"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message  openAIMessage   `json:"message"`
+		Logprobs *openAILogprobs `json:"logprobs"`
+	} `json:"choices"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		Logprobs *openAILogprobs `json:"logprobs"`
+	} `json:"choices"`
+}
+
+type openAILogprobs struct {
+	Content []struct {
+		Logprob float64 `json:"logprob"`
+	} `json:"content"`
+}
+
+// add records the tokens' log probabilities in lp, if both are set.
+func (o *openAILogprobs) add(lp *Logprobs) {
+	if o == nil || lp == nil {
+		return
+	}
+	for _, t := range o.Content {
+		lp.Add(t.Logprob, 1)
+	}
+}
+
+type openAIError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
@@ -0,0 +1,145 @@
+package answer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Word limits from the output contract in prompts/hint.tmpl.
+const (
+	maxAnswerWords   = 22
+	maxFollowUpWords = 16
+)
+
+// hintSchema is the hint object as structured-output APIs enforce it.
+var hintSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"answer":     {Type: "string", Description: fmt.Sprintf("At most %d words: one directive, empathetic, concrete next move.", maxAnswerWords)},
+		"followUp":   {Type: "string", Description: fmt.Sprintf("At most %d words: one open-ended question.", maxFollowUpWords)},
+		"confidence": {Type: "number", Description: "0 to 1: how well a hint can fit what was said; low when guessing."},
+	},
+	Required: []string{"confidence", "answer", "followUp"},
+	// Confidence comes first so a streamed hint can be gated before its
+	// answer is shown.
+	Order: []string{"confidence", "answer", "followUp"},
+}
+
+// parseAnswer decodes the model's hint object. Strict JSON is tried first;
+// failing that the object is repaired: prose or code fences around it, the
+// whole object sent as a JSON string, or output cut off mid-value. Fields
+// over the word limit are truncated. The model's own confidence, if it gave
+// one, is kept as a signal and scored.
+func parseAnswer(candidateText string) (*Answer, error) {
+	text := trimCodeFence(candidateText)
+	ans, err := decodeAnswer(text)
+	if err != nil {
+		// Recover what was generated before the cut; a value still open
+		// loses its last, possibly partial, word.
+		p := parsePartial(text)
+		ans = &Answer{Answer: p.Answer, FollowUp: p.FollowUp, Signals: p.Signals}
+		if !p.AnswerDone {
+			ans.Answer = dropPartialWord(ans.Answer)
+		}
+		if !p.FollowUpDone {
+			ans.FollowUp = dropPartialWord(ans.FollowUp)
+		}
+	}
+	ans.Answer = clampWords(strings.TrimSpace(ans.Answer), maxAnswerWords)
+	ans.FollowUp = clampWords(strings.TrimSpace(ans.FollowUp), maxFollowUpWords)
+	if ans.Answer == "" && ans.FollowUp == "" {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("provider returned empty payload")
+	}
+	ans.Confidence = ans.Signals.Score()
+	return ans, nil
+}
+
+// decodeAnswer parses the outermost {...} in text strictly, unwrapping it
+// first if the model returned the object as a JSON string.
+func decodeAnswer(text string) (*Answer, error) {
+	if strings.HasPrefix(text, `"`) {
+		var inner string
+		if json.Unmarshal([]byte(text), &inner) == nil {
+			text = trimCodeFence(inner)
+		}
+	}
+	start, end := strings.IndexByte(text, '{'), strings.LastIndexByte(text, '}')
+	if start < 0 || end < start {
+		return nil, errors.New("no JSON object in reply")
+	}
+	var raw struct {
+		Answer     string   `json:"answer"`
+		FollowUp   string   `json:"followUp"`
+		Confidence *float64 `json:"confidence"`
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &raw); err != nil {
+		return nil, err
+	}
+	ans := &Answer{Answer: raw.Answer, FollowUp: raw.FollowUp}
+	if raw.Confidence != nil {
+		ans.Signals.Model, ans.Signals.HasModel = clamp01(*raw.Confidence), true
+	}
+	return ans, nil
+}
+
+func trimCodeFence(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") {
+		trimmed = strings.TrimPrefix(trimmed, "```")
+		trimmed = strings.TrimSpace(trimmed)
+		if strings.HasPrefix(strings.ToLower(trimmed), "json") {
+			trimmed = strings.TrimSpace(trimmed[4:])
+		}
+		if idx := strings.LastIndex(trimmed, "```"); idx != -1 {
+			trimmed = trimmed[:idx]
+		}
+		trimmed = strings.TrimSpace(trimmed)
+	}
+	return trimmed
+}
+
+// clampPartial applies the word limits while a hint streams. A field that
+// reaches its limit is complete: the client can show it as final.
+func clampPartial(p Partial) Partial {
+	if len(strings.Fields(p.Answer)) > maxAnswerWords {
+		p.Answer, p.AnswerDone = clampWords(p.Answer, maxAnswerWords), true
+	}
+	if len(strings.Fields(p.FollowUp)) > maxFollowUpWords {
+		p.FollowUp, p.FollowUpDone = clampWords(p.FollowUp, maxFollowUpWords), true
+	}
+	return p
+}
+
+// clampWords keeps the first max words of s. A cut sentence loses trailing
+// commas and the like; a cut question keeps its question mark.
+func clampWords(s string, max int) string {
+	words := strings.Fields(s)
+	if len(words) <= max {
+		return s
+	}
+	out := strings.TrimRightFunc(strings.Join(words[:max], " "), func(r rune) bool {
+		return unicode.IsPunct(r) && r != '?' && r != '!' && r != '.'
+	})
+	if strings.HasSuffix(strings.TrimSpace(s), "?") && !strings.HasSuffix(out, "?") {
+		out += "?"
+	}
+	return out
+}
+
+// dropPartialWord removes the last word of s unless s ends at a word
+// boundary.
+func dropPartialWord(s string) string {
+	if s == "" || strings.IndexFunc(s[len(s)-1:], unicode.IsSpace) == 0 {
+		return strings.TrimSpace(s)
+	}
+	if i := strings.LastIndexFunc(s, unicode.IsSpace); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAnswerRepairsCommonBreakage(t *testing.T) {
+	cases := map[string]struct {
+		in               string
+		answer, followUp string
+	}{
+		"strict":     {`{"answer":"Propose a pilot","followUp":"Who decides?"}`, "Propose a pilot", "Who decides?"},
+		"fenced":     {"```json\n{\"answer\":\"Propose a pilot\",\"followUp\":\"Who decides?\"}\n```", "Propose a pilot", "Who decides?"},
+		"prose":      {`Sure! {"answer":"Propose a pilot","followUp":"Who decides?"} Hope that helps.`, "Propose a pilot", "Who decides?"},
+		"quoted":     {`"{\"answer\":\"Propose a pilot\",\"followUp\":\"Who decides?\"}"`, "Propose a pilot", "Who decides?"},
+		"truncated":  {`{"answer":"Propose a pilot","followUp":"Who signs off on the bud`, "Propose a pilot", "Who signs off on the"},
+		"mid-answer": {`{"answer":"Propose a two week pil`, "Propose a two week", ""},
+		"trailing":   {`{"answer":"Propose a pilot","followUp":"Who decides?",}`, "Propose a pilot", "Who decides?"},
+	}
+	for name, tc := range cases {
+		ans, err := parseAnswer(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if ans.Answer != tc.answer || ans.FollowUp != tc.followUp {
+			t.Errorf("%s: got %q / %q, want %q / %q", name, ans.Answer, ans.FollowUp, tc.answer, tc.followUp)
+		}
+	}
+	if _, err := parseAnswer("I cannot help with that."); err == nil {
+		t.Error("expected an error for a reply with no object")
+	}
+}
+
+func TestParseAnswerEnforcesWordLimits(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+	ans, err := parseAnswer(`{"answer":"` + long + `","followUp":"` + strings.TrimSpace(long) + `, right?"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Fields(ans.Answer)); n != maxAnswerWords {
+		t.Fatalf("answer has %d words, want %d", n, maxAnswerWords)
+	}
+	if n := len(strings.Fields(ans.FollowUp)); n != maxFollowUpWords || !strings.HasSuffix(ans.FollowUp, "?") {
+		t.Fatalf("followUp %q: want %d words ending in ?", ans.FollowUp, maxFollowUpWords)
+	}
+}
+
+func TestClampPartialFinishesFieldAtLimit(t *testing.T) {
+	p := clampPartial(Partial{Answer: strings.Repeat("go ", maxAnswerWords) + "fur"})
+	if !p.AnswerDone || len(strings.Fields(p.Answer)) != maxAnswerWords {
+		t.Fatalf("got %+v", p)
+	}
+}
+
+func TestGeminiRequestsStructuredOutput(t *testing.T) {
+	var got geminiRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"candidates":[{"content":{"parts":[{"text":"{\"answer\":\"a\",\"followUp\":\"b?\"}"}]}}]}`))
+	}))
+	defer srv.Close()
+
+	svc := NewService(&geminiProvider{apiKey: "k", model: "m", baseURL: srv.URL, client: srv.Client()})
+	if ans := svc.Micro(context.Background(), "what's the budget", nil, nil, nil, Memory{}); ans == nil {
+		t.Fatal("expected an answer")
+	}
+	cfg := got.GenerationConfig
+	if cfg == nil || cfg.ResponseMimeType != "application/json" || cfg.ResponseSchema == nil {
+		t.Fatalf("structured output not requested: %+v", cfg)
+	}
+	s := cfg.ResponseSchema
+	if s.Type != "OBJECT" || s.Properties["answer"].Type != "STRING" || strings.Join(s.PropertyOrdering, ",") != "confidence,answer,followUp" {
+		t.Fatalf("unexpected schema: %+v", s)
+	}
+}
@@ -0,0 +1,248 @@
+package answer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultMode is the persona used when a session does not pick one.
+const DefaultMode = "sales"
+
+//go:embed prompts/*.tmpl
+var builtinPrompts embed.FS
+
+// Prompts renders hint prompts from text/template files: hint.tmpl is the
+// frame and every other <mode>.tmpl a persona that fills it in. Files in Dir
+// replace or add to the built-in ones and are reloaded when they change; a
+// set that fails to parse keeps the previous one in use.
+type Prompts struct {
+	// Dir, if set, is checked for changes at most every ReloadEvery.
+	Dir         string
+	ReloadEvery time.Duration
+
+	mu      sync.Mutex
+	sets    map[string]*template.Template
+	stamp   string
+	checked time.Time
+}
+
+// DefaultPrompts is the process-wide set; Config.PromptDir overrides it via
+// SetDir.
+var DefaultPrompts = NewPrompts("")
+
+// builtinPromptSet is the fallback when an override fails to render.
+var builtinPromptSet = NewPrompts("")
+
+// NewPrompts returns the built-in prompts overlaid with the *.tmpl files in
+// dir, if any.
+func NewPrompts(dir string) *Prompts {
+	p := &Prompts{Dir: strings.TrimSpace(dir), ReloadEvery: 2 * time.Second}
+	p.reload()
+	return p
+}
+
+// Modes lists the personas available, sorted.
+func (p *Prompts) Modes() []string {
+	p.maybeReload()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortedKeys(p.sets)
+}
+
+// Has reports whether mode names a persona.
+func (p *Prompts) Has(mode string) bool {
+	p.maybeReload()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.sets[mode]
+	return ok
+}
+
+// promptData is what the templates see.
+type promptData struct {
+	Transcript string
+	OCR        []string
+	First      []string
+	Last       []string
+	Unique     []string
+	// Summary and Turns are the conversation memory; Turns is already
+	// formatted, one per line.
+	Summary string
+	Turns   string
+
+	MaxAnswerWords   int
+	MaxFollowUpWords int
+}
+
+// render executes the persona for mode, falling back to DefaultMode when
+// mode is unknown.
+func (p *Prompts) render(mode string, data promptData) (string, error) {
+	p.maybeReload()
+	p.mu.Lock()
+	t, ok := p.sets[mode]
+	if !ok {
+		t = p.sets[DefaultMode]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		return "", fmt.Errorf("no prompt template for mode %q", mode)
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "hint.tmpl", data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SetDir switches the override directory, "" for none, and loads it at
+// once.
+func (p *Prompts) SetDir(dir string) {
+	p.mu.Lock()
+	p.Dir = strings.TrimSpace(dir)
+	p.checked = time.Now()
+	p.mu.Unlock()
+	p.reload()
+}
+
+func (p *Prompts) maybeReload() {
+	p.mu.Lock()
+	due := p.Dir != "" && time.Since(p.checked) >= p.ReloadEvery
+	if due {
+		p.checked = time.Now()
+	}
+	p.mu.Unlock()
+	if due {
+		p.reload()
+	}
+}
+
+// reload parses the templates again if Dir changed since the last load.
+func (p *Prompts) reload() {
+	p.mu.Lock()
+	dir := p.Dir
+	p.mu.Unlock()
+	files, stamp, err := promptFiles(dir)
+	if err != nil {
+		slog.Warn("prompt dir not read", "dir", dir, "err", err)
+	}
+	p.mu.Lock()
+	unchanged := p.sets != nil && stamp == p.stamp
+	p.mu.Unlock()
+	if unchanged {
+		return
+	}
+	sets, err := parsePrompts(files)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		slog.Warn("prompt templates not reloaded", "dir", dir, "err", err)
+		if p.sets == nil {
+			// The built-in templates always parse; TestBuiltinPrompts
+			// sees to it.
+			builtin, _, _ := promptFiles("")
+			p.sets, _ = parsePrompts(builtin)
+		}
+		p.stamp = stamp
+		return
+	}
+	p.sets, p.stamp = sets, stamp
+	if dir != "" {
+		slog.Info("prompt templates loaded", "dir", dir, "modes", strings.Join(sortedKeys(sets), ", "))
+	}
+}
+
+// promptFiles reads the built-in templates and those in dir, which win by
+// name. stamp changes whenever dir does or a file in it is added, removed or
+// modified.
+func promptFiles(dir string) (map[string]string, string, error) {
+	files := map[string]string{}
+	entries, _ := fs.Glob(builtinPrompts, "prompts/*.tmpl")
+	for _, name := range entries {
+		b, _ := builtinPrompts.ReadFile(name)
+		files[path.Base(name)] = string(b)
+	}
+	if dir == "" {
+		return files, "", nil
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return files, dir, err
+	}
+	var stamp strings.Builder
+	stamp.WriteString(dir + ";")
+	for _, e := range dirEntries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tmpl") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return files, "", err
+		}
+		files[e.Name()] = string(b)
+		fmt.Fprintf(&stamp, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return files, stamp.String(), nil
+}
+
+// parsePrompts builds one template set per persona: hint.tmpl plus the
+// persona's file. Every persona is test-rendered so a missing definition
+// fails the load rather than a live hint.
+func parsePrompts(files map[string]string) (map[string]*template.Template, error) {
+	frame, ok := files["hint.tmpl"]
+	if !ok {
+		return nil, fmt.Errorf("hint.tmpl missing")
+	}
+	base, err := template.New("hint.tmpl").Funcs(template.FuncMap{"list": listOrNone}).Parse(frame)
+	if err != nil {
+		return nil, err
+	}
+	sets := map[string]*template.Template{}
+	for name, src := range files {
+		if name == "hint.tmpl" {
+			continue
+		}
+		t, err := template.Must(base.Clone()).New(name).Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.ExecuteTemplate(&bytes.Buffer{}, "hint.tmpl", promptData{}); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sets[strings.TrimSuffix(name, ".tmpl")] = t
+	}
+	if _, ok := sets[DefaultMode]; !ok {
+		return nil, fmt.Errorf("%s.tmpl missing", DefaultMode)
+	}
+	return sets, nil
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func sortedKeys(m map[string]*template.Template) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
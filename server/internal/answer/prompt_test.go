@@ -0,0 +1,97 @@
+package answer
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/prompts/*.golden")
+
+// TestPromptGolden renders every built-in persona for a fixed utterance and
+// compares it with testdata/prompts/<mode>.golden. Run with -update after an
+// intended template change and review the diff.
+func TestPromptGolden(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mem := Memory{
+		Summary: "Customer is renewing in March; finance owns budget.",
+		Turns:   []Turn{{Speaker: "customer", Text: "We also need SSO.", At: now.Add(-30 * time.Second)}},
+		Now:     now,
+	}
+	modes := builtinPromptSet.Modes()
+	if strings.Join(modes, ",") != "interview,lecture,sales,support" {
+		t.Fatalf("unexpected built-in modes %v", modes)
+	}
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			got := buildPrompt(mode, "We need approval from finance soon", []string{"budget", "renewal"}, []string{"kickoff"}, []string{"procurement"}, mem)
+			golden := filepath.Join("testdata", "prompts", mode+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create)", err)
+			}
+			if got != string(want) {
+				t.Fatalf("%s prompt differs from %s\ngot:\n%s", mode, golden, got)
+			}
+		})
+	}
+}
+
+func TestPromptsReloadFromDir(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPrompts(dir)
+	p.ReloadEvery = 0
+	if p.Has("demo") {
+		t.Fatal("demo mode before it was written")
+	}
+
+	persona := `{{define "identity"}}DEMO COACH{{end}}{{define "context_model"}}ctx{{end}}{{define "quality"}}q{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "demo.tmpl"), []byte(persona), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.render("demo", promptData{Transcript: "hello"})
+	if err != nil || !strings.Contains(got, "<core_identity> DEMO COACH </core_identity>") {
+		t.Fatalf("new persona not picked up: %v\n%s", err, got)
+	}
+
+	// A broken edit keeps the last good set.
+	if err := os.WriteFile(filepath.Join(dir, "demo.tmpl"), []byte(`{{define "identity"}}oops`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.render("demo", promptData{}); err != nil || !strings.Contains(got, "DEMO COACH") {
+		t.Fatalf("broken template replaced the working one: %v\n%s", err, got)
+	}
+
+	// A persona missing a section is rejected at load, not at render.
+	if err := os.WriteFile(filepath.Join(dir, "demo.tmpl"), []byte(`{{define "identity"}}v2{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.render("demo", promptData{}); strings.Contains(got, "v2") {
+		t.Fatal("incomplete persona was loaded")
+	}
+}
+
+func TestServiceSetMode(t *testing.T) {
+	svc := NewService(RulesProvider{})
+	if svc.Mode() != DefaultMode {
+		t.Fatalf("default mode = %q", svc.Mode())
+	}
+	if err := svc.SetMode("Interview"); err != nil || svc.Mode() != "interview" {
+		t.Fatalf("SetMode(Interview) = %v, mode %q", err, svc.Mode())
+	}
+	if err := svc.SetMode("poetry"); err == nil || svc.Mode() != "interview" {
+		t.Fatalf("unknown mode accepted: %v, mode %q", err, svc.Mode())
+	}
+	req := svc.hintRequest("tell me about a failure", nil, nil, nil, Memory{})
+	if !strings.Contains(req.Prompt, "interview coach") {
+		t.Fatalf("interview persona not used:\n%s", req.Prompt)
+	}
+}
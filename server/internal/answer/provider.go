@@ -0,0 +1,134 @@
+package answer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Task says what a GenerateRequest is for, so providers that do not run a
+// model (the offline rules) know which structured fields to look at.
+type Task string
+
+const (
+	TaskHint    Task = "hint"
+	TaskSummary Task = "summary"
+)
+
+// GenerateRequest is one text generation call. Prompt is what LLM providers
+// send; the remaining fields carry the same input in structured form.
+type GenerateRequest struct {
+	Task   Task
+	Prompt string
+
+	Transcript string   // TaskHint: the utterance being coached
+	Context    []string // TaskHint: de-duplicated OCR tokens
+	Richness   float64  // TaskHint: how much context the hint has, 0..1
+	Summary    string   // TaskSummary: running summary so far
+	Turns      []Turn   // TaskSummary: turns to fold in
+
+	Temperature     float64
+	TopP            float64
+	TopK            int
+	MaxOutputTokens int
+	// JSON asks the provider for a single JSON object if it supports a
+	// dedicated mode for it. Schema, if set, describes that object for
+	// providers that can enforce one.
+	JSON   bool
+	Schema *Schema
+	// Logprobs, if set, asks providers that can report token log
+	// probabilities to add them to it. Others leave it empty.
+	Logprobs *Logprobs
+}
+
+// Logprobs accumulates the log probabilities of generated tokens.
+type Logprobs struct {
+	Sum    float64
+	Tokens int
+}
+
+// Add records n tokens whose log probabilities sum to sum.
+func (l *Logprobs) Add(sum float64, n int) {
+	l.Sum += sum
+	l.Tokens += n
+}
+
+// Mean is the average log probability per token, 0 if none were recorded.
+func (l *Logprobs) Mean() float64 {
+	if l.Tokens == 0 {
+		return 0
+	}
+	return l.Sum / float64(l.Tokens)
+}
+
+// Schema is the subset of JSON Schema that structured-output APIs accept.
+// Types are lower case ("object", "string", "number").
+type Schema struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// Order lists properties in the order they should be generated, so a
+	// streamed answer arrives before its follow-up.
+	Order []string `json:"-"`
+}
+
+// Provider is an LLM backend for answer.Service.
+type Provider interface {
+	Name() string
+	Generate(ctx context.Context, req GenerateRequest) (string, error)
+}
+
+// ErrNotConfigured is returned, without calling the upstream, by a provider
+// that lacks a setting it needs such as an API key. It is not an upstream
+// failure and does not count against the provider's breaker.
+var ErrNotConfigured = errors.New("llm provider is not configured")
+
+// configured is implemented by providers that cannot be called without
+// some setting. It returns an error wrapping ErrNotConfigured.
+type configured interface {
+	configured() error
+}
+
+// checkConfigured reports whether p has what it needs to be called.
+func checkConfigured(p Provider) error {
+	if c, ok := p.(configured); ok {
+		return c.configured()
+	}
+	return nil
+}
+
+// NewProvider builds the provider named by cfg.Provider:
+//
+//	gemini (default)          Google generateContent; cfg.Gemini
+//	openai, ollama, llamacpp  any /v1/chat/completions server; cfg.OpenAI
+//	anthropic                 Messages API; cfg.Anthropic
+//	rules, offline            deterministic keyword rules, no network
+func NewProvider(cfg Config) (Provider, error) {
+	switch name := strings.ToLower(strings.TrimSpace(cfg.Provider)); name {
+	case "", "gemini":
+		p := newGeminiProvider(cfg.Gemini)
+		p.log = cfg.logger()
+		return p, nil
+	case "openai":
+		return newOpenAIProvider(cfg.OpenAI, defaultOpenAIBaseURL), nil
+	case "ollama":
+		return newOpenAIProvider(cfg.OpenAI, defaultOllamaBaseURL), nil
+	case "llamacpp", "llama.cpp":
+		return newOpenAIProvider(cfg.OpenAI, defaultLlamaCppBaseURL), nil
+	case "anthropic":
+		return newAnthropicProvider(cfg.Anthropic), nil
+	case "rules", "offline":
+		return RulesProvider{}, nil
+	default:
+		return nil, fmt.Errorf("llm provider %q not supported", name)
+	}
+}
+
+func orDefault(v, def string) string {
+	if v = strings.TrimSpace(v); v != "" {
+		return v
+	}
+	return def
+}
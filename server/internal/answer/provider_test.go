@@ -0,0 +1,138 @@
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAIProviderChatCompletions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Fatalf("expected no auth header for keyless local server, got %q", got)
+		}
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if req.Model != "llama3" || req.ResponseFormat == nil || req.ResponseFormat.Type != "json_object" {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"{\"answer\":\"Name the owner\",\"followUp\":\"Who decides?\"}"}}]}`))
+	}))
+	defer srv.Close()
+
+	svc := NewServiceFromConfig(Config{Provider: "ollama", OpenAI: Endpoint{BaseURL: srv.URL + "/v1", Model: "llama3"}})
+	if svc.Provider().Name() != "openai" {
+		t.Fatalf("expected openai provider, got %s", svc.Provider().Name())
+	}
+
+	ans := svc.Micro(context.Background(), "who signs the contract", nil, nil, nil, Memory{})
+	if ans == nil || ans.Answer != "Name the owner" || ans.FollowUp != "Who decides?" {
+		t.Fatalf("unexpected answer: %+v", ans)
+	}
+}
+
+func TestRulesProviderIsDeterministic(t *testing.T) {
+	svc := NewServiceFromConfig(Config{Provider: "offline"})
+
+	first := svc.Micro(context.Background(), "I'm worried the price is too high", nil, nil, nil, Memory{})
+	second := svc.Micro(context.Background(), "I'm worried the price is too high", nil, nil, nil, Memory{})
+	if first == nil || second == nil || first.TraceID == second.TraceID {
+		t.Fatalf("expected a trace per hint, got %+v and %+v", first, second)
+	}
+	// Each hint has its own trace and timing; the content must not differ.
+	first.TraceID, second.TraceID = "", ""
+	first.FirstToken, second.FirstToken = time.Time{}, time.Time{}
+	if *first != *second {
+		t.Fatalf("expected identical answers, got %+v and %+v", first, second)
+	}
+	if first.FollowUp != "Who else weighs in on budget for this?" {
+		t.Fatalf("expected budget rule, got %+v", first)
+	}
+
+	ocr := svc.Micro(context.Background(), "tell me more", []string{"SSO"}, nil, nil, Memory{})
+	if ocr == nil || ocr.FollowUp != "What does your security review usually require?" {
+		t.Fatalf("expected on-screen tokens to select the security rule, got %+v", ocr)
+	}
+}
+
+func TestRulesMatchWholeWords(t *testing.T) {
+	for _, tc := range []struct {
+		text, followUp string
+	}{
+		{"our social media team", fallbackRule.followUp},
+		{"they sell apiece", fallbackRule.followUp},
+		{"the costume party", fallbackRule.followUp},
+		{"is the public API (api) stable?", "Which system would the pilot need to connect to first?"},
+		{"we need SOC-2 first", "What does your security review usually require?"},
+		{"I don't understand", "Which part would be most useful to walk through again?"},
+	} {
+		if r := matchRule(tc.text, nil); r.followUp != tc.followUp {
+			t.Errorf("%q: got follow-up %q, want %q", tc.text, r.followUp, tc.followUp)
+		}
+	}
+}
+
+func TestConfigValidateRejectsUnknown(t *testing.T) {
+	if _, err := NewProvider(Config{Provider: "carrier-pigeon"}); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+	if err := (Config{Provider: "carrier-pigeon"}).Validate(); err == nil {
+		t.Fatal("expected Validate to reject the provider")
+	}
+	if err := (Config{Provider: "rules", Mode: "poetry"}).Validate(); err == nil || !strings.Contains(err.Error(), "sales") {
+		t.Fatalf("expected Validate to reject the mode and list the known ones, got %v", err)
+	}
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+	svc := NewServiceFromConfig(Config{Provider: "rules", Mode: "Interview", Logprobs: true})
+	if svc.Mode() != "interview" || !svc.logprobs {
+		t.Fatalf("service did not take the config: mode %q, logprobs %v", svc.Mode(), svc.logprobs)
+	}
+}
+
+func TestGeminiProviderRetriesUnavailable(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":{"code":503,"message":"overloaded","status":"UNAVAILABLE"}}`))
+		default:
+			_, _ = w.Write([]byte(`{"candidates":[{"content":{"parts":[{"text":"ok"}]}}]}`))
+		}
+	}))
+	defer srv.Close()
+	p := &geminiProvider{apiKey: "k", model: "m", client: srv.Client(), baseURL: srv.URL}
+	text, err := p.Generate(context.Background(), GenerateRequest{Prompt: "hi"})
+	if err != nil || text != "ok" || calls != 2 {
+		t.Fatalf("got %q, %v after %d calls", text, err, calls)
+	}
+}
+
+func TestGeminiProviderDoesNotRetryInvalidArgument(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// error.status outranks the HTTP status.
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"code":400,"message":"bad prompt","status":"INVALID_ARGUMENT"}}`))
+	}))
+	defer srv.Close()
+	p := &geminiProvider{apiKey: "k", model: "m", client: srv.Client(), baseURL: srv.URL}
+	_, err := p.Generate(context.Background(), GenerateRequest{Prompt: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "bad prompt") || calls != 1 {
+		t.Fatalf("got %v after %d calls", err, calls)
+	}
+}
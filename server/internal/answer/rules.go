@@ -0,0 +1,117 @@
+package answer
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// RulesProvider is a deterministic, offline provider. It matches keywords in
+// the transcript and on-screen tokens against a fixed table, so the whole
+// stack runs in CI or on-prem without any model.
+type RulesProvider struct{}
+
+type rule struct {
+	keywords []string
+	answer   string
+	followUp string
+}
+
+// rules are checked in order; the first rule with a matching keyword wins.
+var rules = []rule{
+	{
+		keywords: []string{"not sure", "don't understand", "confused", "unclear"},
+		answer:   "Pause and restate their concern in one sentence before going further",
+		followUp: "Which part would be most useful to walk through again?",
+	},
+	{
+		keywords: []string{"budget", "price", "prices", "pricing", "cost", "costs", "expensive", "discount", "discounts"},
+		answer:   "Anchor on the cost of the problem before discussing price",
+		followUp: "Who else weighs in on budget for this?",
+	},
+	{
+		keywords: []string{"security", "sso", "compliance", "soc 2", "soc2", "gdpr", "legal"},
+		answer:   "Offer the security packet and a call with their security reviewer",
+		followUp: "What does your security review usually require?",
+	},
+	{
+		keywords: []string{"competitor", "competitors", "alternative", "alternatives", "already use", "switch", "switching"},
+		answer:   "Ask what works well today before contrasting, then name one clear gap",
+		followUp: "What would make switching worth it for your team?",
+	},
+	{
+		keywords: []string{"friday", "monday", "next week", "timeline", "deadline", "schedule", "meet", "meeting"},
+		answer:   "Lock the next step with a date and an owner on both sides",
+		followUp: "Who should be in that meeting from your side?",
+	},
+	{
+		keywords: []string{"revenue", "growth", "metric", "metrics", "kpi", "kpis", "roi", "churn"},
+		answer:   "Tie the proposal to the metric they just mentioned and quantify it",
+		followUp: "What target are you measured against this quarter?",
+	},
+	{
+		keywords: []string{"architecture", "integration", "integrations", "api", "apis", "database", "microservices"},
+		answer:   "Sketch where it plugs into their stack and propose a scoped pilot",
+		followUp: "Which system would the pilot need to connect to first?",
+	},
+}
+
+var fallbackRule = rule{
+	answer:   "Summarize what you heard and confirm it matters to them",
+	followUp: "What would a good outcome look like for you?",
+}
+
+func (RulesProvider) Name() string { return "rules" }
+
+func (RulesProvider) Generate(_ context.Context, req GenerateRequest) (string, error) {
+	if req.Task == TaskSummary {
+		return rulesSummary(req.Summary, req.Turns), nil
+	}
+	r := matchRule(req.Transcript, req.Context)
+	// A keyword match is a fair guess at the topic; the fallback is not.
+	confidence := 0.7
+	if r.answer == fallbackRule.answer {
+		confidence = 0.3
+	}
+	b, err := json.Marshal(Answer{Answer: r.answer, FollowUp: r.followUp, Confidence: confidence})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// matchRule compares whole words: "soc" does not match "social", and a
+// keyword next to punctuation, as in "(api)", still does. Multi-word
+// keywords match the same words in a row.
+func matchRule(transcript string, tokens []string) rule {
+	haystack := " " + strings.Join(words(transcript+" "+strings.Join(tokens, " ")), " ") + " "
+	for _, r := range rules {
+		for _, kw := range r.keywords {
+			if strings.Contains(haystack, " "+strings.Join(words(kw), " ")+" ") {
+				return r
+			}
+		}
+	}
+	return fallbackRule
+}
+
+// words lowercases s and splits it on anything but letters and digits.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// rulesSummary keeps the tail of the previous summary plus the new turns,
+// clipped to roughly 80 words.
+func rulesSummary(prev string, turns []Turn) string {
+	words := strings.Fields(prev)
+	for _, t := range turns {
+		words = append(words, strings.Fields(t.Text)...)
+	}
+	if len(words) > 80 {
+		words = words[len(words)-80:]
+	}
+	return strings.Join(words, " ")
+}
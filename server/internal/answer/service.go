@@ -1,223 +1,302 @@
 package answer
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
 	"strings"
+	"sync"
 	"time"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
 )
 
 type Answer struct {
 	Answer     string  `json:"answer"`
 	FollowUp   string  `json:"followUp"`
 	Confidence float64 `json:"confidence,omitempty"`
+	// Signals are what Confidence was scored from. Callers that know more,
+	// such as the transcript's ASR confidence, add it and rescore.
+	Signals Signals `json:"-"`
+	// TraceID names the trace the hint was generated in.
+	TraceID string `json:"-"`
+	// FirstToken is when the provider's first output arrived; for a
+	// provider that does not stream, when its reply did.
+	FirstToken time.Time `json:"-"`
 }
 
 type Service struct {
-	apiKey  string
-	model   string
-	client  *http.Client
-	baseURL string
+	provider Provider
+	breaker  *rt.Breaker
+	// summaries has a breaker of its own so a failing summary cannot hold
+	// back live hints.
+	summaries *rt.Breaker
+	// logprobs asks providers for token log probabilities (LLM_LOGPROBS).
+	logprobs bool
+	log      *slog.Logger
+
+	mu   sync.Mutex
+	mode string
 }
 
-const (
-	geminiBaseURL  = "https://generativelanguage.googleapis.com/v1beta"
-	defaultGemini  = "gemini-1.5-flash"
-	requestTimeout = 8 * time.Second
-)
+const requestTimeout = 8 * time.Second
 
-func NewServiceFromEnv() *Service {
-	apiKey := strings.TrimSpace(os.Getenv("GEMINI_API_KEY"))
-	model := strings.TrimSpace(os.Getenv("GEMINI_MODEL"))
-	base := strings.TrimSpace(os.Getenv("GEMINI_BASE_URL"))
-	if model == "" {
-		model = defaultGemini
-	}
-	if base == "" {
-		base = geminiBaseURL
-	}
+// NewService returns a Service generating hints with p in DefaultMode.
+// Services for the same provider share a circuit breaker, so an outage seen
+// by one session fails the others fast.
+func NewService(p Provider) *Service {
 	return &Service{
-		apiKey:  apiKey,
-		model:   model,
-		client:  &http.Client{Timeout: requestTimeout},
-		baseURL: base,
+		provider:  p,
+		breaker:   rt.DefaultBreakers.Get("llm:" + p.Name()),
+		summaries: rt.DefaultBreakers.Get("llm:" + p.Name() + ":summary"),
+		log:       slog.Default(),
+		mode:      DefaultMode,
 	}
 }
 
-func (s *Service) Micro(text string, ocr []string, firstOCR []string, lastOCR []string) *Answer {
-	transcript := strings.TrimSpace(text)
-	if transcript == "" {
-		log.Println("[answer] empty text, skipping")
-		return nil
-	}
-	if s.apiKey == "" {
-		log.Println("[answer] GEMINI_API_KEY is not set; cannot generate hint")
-		return nil
-	}
-
-	prompt := buildPrompt(transcript, ocr, firstOCR, lastOCR)
-	ans, err := s.callGemini(prompt)
+// NewServiceFromConfig builds the provider cfg selects; see NewProvider. A
+// bad provider, which Config.Validate would have caught, falls back to
+// Gemini, and a bad mode to DefaultMode.
+func NewServiceFromConfig(cfg Config) *Service {
+	log := cfg.logger()
+	p, err := NewProvider(cfg)
 	if err != nil {
-		log.Printf("[answer] gemini request failed: %v", err)
-		return nil
+		log.Warn("falling back to gemini", "err", err)
+		g := newGeminiProvider(cfg.Gemini)
+		g.log = log
+		p = g
 	}
-	return ans
+	s := NewService(p)
+	s.logprobs = cfg.Logprobs
+	s.log = log
+	if cfg.Mode != "" {
+		if err := s.SetMode(cfg.Mode); err != nil {
+			log.Warn("using the default mode", "mode", DefaultMode, "err", err)
+		}
+	}
+	return s
 }
 
-func buildPrompt(transcript string, ocr []string, first []string, last []string) string {
-	uniqTokens := contextualTokens(ocr, first, last)
-	var sb strings.Builder
-
-	// Core identity: who you are and what to do
-	sb.WriteString("<core_identity> You are Cluely, a real-time on-glass sales coach created by Cluely. Your sole purpose is to analyze the conversation and what's on the screen, then deliver exactly one tactical coaching hint and one crisp follow-up question that advances the deal. Be specific, accurate, and immediately actionable. </core_identity> ")
-
-	// Hard rules and safety constraints
-	sb.WriteString("<rules> NEVER use meta-phrases or pleasantries. NEVER reveal or mention models/providers. NEVER mention 'screenshot' or 'image'—say 'the screen' if needed. NEVER summarize the transcript unless explicitly asked. DO NOT add explanations, markdown, code fences, or keys beyond answer and followUp. Do not invent names, figures, or commitments. Avoid double quotes inside values to keep JSON valid; paraphrase instead. If uncertain, state that briefly and ask the minimum clarifier. </rules> ")
+// Provider returns the LLM backend in use.
+func (s *Service) Provider() Provider { return s.provider }
 
-	// How to interpret context and adapt coaching
-	sb.WriteString("<context_model> Focus on meaning over keywords. Weight OCR by recency: 'Last frame tokens' = what's visible now (highest signal); 'First frame tokens' = persistent session context; 'Unique context tokens' = disambiguation. Infer stage: discovery (goals, pain, why-now), evaluation (architecture, pilot, metrics), negotiation (pricing, budget, procurement, legal). Adapt: discovery -> clarify outcome + next step; evaluation -> tie feature to their outcome and propose pilot/measure; negotiation -> surface blockers, decision path/owners, and close timeline. </context_model> ")
-
-	// Output contract
-	sb.WriteString("<output_contract> Return EXACTLY one compact JSON object only: {\"answer\":\"<=22 words, directive, empathetic, concrete\",\"followUp\":\"<=16 words, one open-ended question\"}. No newlines, no extra whitespace, no code fences, no other keys. </output_contract> ")
+// Mode returns the coaching persona hints are generated for.
+func (s *Service) Mode() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mode
+}
 
-	// Quality bar and examples
-	sb.WriteString("<quality> The answer proposes one next move (e.g., anchor ROI to budget owner, confirm risk mitigation, propose time-bound step). The followUp asks one specific question that progresses approval, scope, or timeline. Examples—answer: 'Tie uptime risk to your SLOs; propose a 2-week pilot'. followUp: 'Who owns final approval on this?'. </quality> ")
+// SetMode switches the coaching persona, e.g. "sales" or "interview". An
+// unknown mode is an error and leaves the current one in place.
+func (s *Service) SetMode(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if !DefaultPrompts.Has(mode) {
+		return fmt.Errorf("unknown mode %q (have %s)", mode, strings.Join(DefaultPrompts.Modes(), ", "))
+	}
+	s.mu.Lock()
+	s.mode = mode
+	s.mu.Unlock()
+	return nil
+}
 
-	// Inject live context
-	sb.WriteString("Transcript:\n")
-	sb.WriteString(transcript)
-	sb.WriteString("\n\nRecent OCR tokens: ")
-	if len(ocr) == 0 {
-		sb.WriteString("none")
-	} else {
-		sb.WriteString(strings.Join(ocr, ", "))
+// Available returns an *rt.OpenError while the provider's breaker is open,
+// so callers can tell the user at once instead of waiting for a timeout.
+func (s *Service) Available() error { return s.breaker.Err() }
+
+// guard runs fn unless the provider is not configured or its breaker is
+// open and records how it went, in the breaker, the metrics and an
+// llm.generate span whose context fn gets. A call the caller cancelled says
+// nothing about the provider, and neither does a missing API key.
+func (s *Service) guard(ctx context.Context, task Task, fn func(ctx context.Context) error) error {
+	name := s.provider.Name()
+	ctx, span := trace.Start(ctx, "llm.generate", trace.String("llm.provider", name), trace.String("llm.task", string(task)))
+	defer span.End()
+	if err := checkConfigured(s.provider); err != nil {
+		span.RecordError(err)
+		return err
 	}
-	sb.WriteString("\nFirst frame tokens: ")
-	if len(first) == 0 {
-		sb.WriteString("none")
-	} else {
-		sb.WriteString(strings.Join(first, ", "))
+	breaker := s.breaker
+	if task == TaskSummary {
+		breaker = s.summaries
 	}
-	sb.WriteString("\nLast frame tokens: ")
-	if len(last) == 0 {
-		sb.WriteString("none")
-	} else {
-		sb.WriteString(strings.Join(last, ", "))
+	if err := breaker.Allow(); err != nil {
+		obs.Error(obs.StageLLM, name, err)
+		span.RecordError(err)
+		return err
 	}
-	sb.WriteString("\nUnique context tokens: ")
-	if len(uniqTokens) == 0 {
-		sb.WriteString("none")
-	} else {
-		sb.WriteString(strings.Join(uniqTokens, ", "))
+	start := time.Now()
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		breaker.Cancel()
+		obs.LLMLatency.With(name, string(task), "canceled").ObserveSince(start)
+		span.SetAttrs(trace.String("llm.outcome", "canceled"))
+		return err
+	}
+	breaker.Record(err)
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		obs.Error(obs.StageLLM, name, err)
+		span.RecordError(err)
 	}
-	return sb.String()
+	obs.LLMLatency.With(name, string(task), outcome).ObserveSince(start)
+	span.SetAttrs(trace.String("llm.outcome", outcome))
+	return err
 }
 
-func (s *Service) callGemini(prompt string) (*Answer, error) {
-	requestPayload := geminiRequest{
-		Contents: []geminiContent{
-			{
-				Role:  "user",
-				Parts: []geminiPart{{Text: prompt}},
-			},
-		},
-		GenerationConfig: &geminiGenerationConfig{
-			Temperature:     0.7,
-			TopP:            0.95,
-			TopK:            32,
-			MaxOutputTokens: 120,
-		},
-	}
+// startMicro opens the answer.micro span covering one hint: prompt, model
+// call and parsing.
+func (s *Service) startMicro(ctx context.Context) (context.Context, *trace.Span) {
+	return trace.Start(ctx, "answer.micro", trace.String("llm.provider", s.provider.Name()), trace.String("hint.mode", s.Mode()))
+}
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(requestPayload); err != nil {
-		return nil, fmt.Errorf("encode request: %w", err)
+// endMicro stamps ans, if any, with the span's trace and ends the span.
+func endMicro(span *trace.Span, ans *Answer) {
+	if ans != nil {
+		ans.TraceID = span.Context().TraceID.String()
+		span.SetAttrs(trace.Float("hint.confidence", ans.Confidence))
 	}
+	span.End()
+}
 
-	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", strings.TrimRight(s.baseURL, "/"), s.model, s.apiKey)
-	req, err := http.NewRequest(http.MethodPost, url, &buf)
-	if err != nil {
-		return nil, fmt.Errorf("build request: %w", err)
+// Micro generates one hint for the final transcript text, using the on-screen
+// OCR context and what was said earlier in mem. Cancelling ctx abandons the
+// request.
+func (s *Service) Micro(ctx context.Context, text string, ocr []string, firstOCR []string, lastOCR []string, mem Memory) (ans *Answer) {
+	transcript := strings.TrimSpace(text)
+	if transcript == "" {
+		s.log.Debug("empty text, skipping")
+		return nil
 	}
-	req.Header.Set("Content-Type", "application/json")
+	ctx, span := s.startMicro(ctx)
+	defer func() { endMicro(span, ans) }()
 
-	resp, err := s.client.Do(req)
+	ans, err := s.complete(ctx, s.hintRequest(transcript, ocr, firstOCR, lastOCR, mem))
 	if err != nil {
-		return nil, fmt.Errorf("execute request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= http.StatusBadRequest {
-		return nil, parseGeminiError(resp)
+		s.log.Warn("llm request failed", "provider", s.provider.Name(), "err", err)
+		span.RecordError(err)
+		return nil
 	}
+	return ans
+}
 
-	var genResp geminiResponse
-	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+func (s *Service) hintRequest(transcript string, ocr, firstOCR, lastOCR []string, mem Memory) GenerateRequest {
+	tokens := contextualTokens(ocr, firstOCR, lastOCR)
+	req := GenerateRequest{
+		Task:            TaskHint,
+		Prompt:          buildPrompt(s.Mode(), transcript, ocr, firstOCR, lastOCR, mem),
+		Transcript:      transcript,
+		Context:         tokens,
+		Richness:        contextRichness(transcript, tokens, mem),
+		Temperature:     0.7,
+		TopP:            0.95,
+		TopK:            32,
+		MaxOutputTokens: 120,
+		JSON:            true,
+		Schema:          hintSchema,
 	}
-
-	candidateText := extractCandidateText(genResp.Candidates)
-	if candidateText == "" {
-		return nil, errors.New("gemini returned empty candidate text")
+	if s.logprobs {
+		req.Logprobs = &Logprobs{}
 	}
-	candidateText = trimCodeFence(candidateText)
+	return req
+}
 
-	var ans Answer
-	if err := json.Unmarshal([]byte(candidateText), &ans); err != nil {
-		return nil, fmt.Errorf("unmarshal candidate: %w", err)
+// buildPrompt renders the hint prompt for the persona named by mode; see
+// Prompts.
+func buildPrompt(mode, transcript string, ocr []string, first []string, last []string, mem Memory) string {
+	data := promptData{
+		Transcript:       transcript,
+		OCR:              ocr,
+		First:            first,
+		Last:             last,
+		Unique:           contextualTokens(ocr, first, last),
+		Summary:          mem.Summary,
+		MaxAnswerWords:   maxAnswerWords,
+		MaxFollowUpWords: maxFollowUpWords,
 	}
-	if ans.Answer == "" && ans.FollowUp == "" {
-		return nil, errors.New("gemini returned empty payload")
+	if len(mem.Turns) > 0 {
+		now := mem.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		data.Turns = formatTurns(mem.Turns, now)
 	}
-	if ans.Confidence == 0 {
-		ans.Confidence = 0.8
+	prompt, err := DefaultPrompts.render(mode, data)
+	if err != nil {
+		slog.Warn("prompt template failed; using the built-in one", "mode", mode, "err", err)
+		prompt, _ = builtinPromptSet.render(mode, data)
 	}
-	return &ans, nil
+	return prompt
 }
 
-func parseGeminiError(resp *http.Response) error {
-	body, err := io.ReadAll(resp.Body)
+// complete runs a hint request and parses the provider's JSON reply.
+func (s *Service) complete(ctx context.Context, req GenerateRequest) (*Answer, error) {
+	var candidateText string
+	err := s.guard(ctx, req.Task, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		var err error
+		candidateText, err = s.provider.Generate(ctx, req)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("gemini http %d: failed to read error body: %w", resp.StatusCode, err)
+		return nil, err
 	}
-	var apiErr geminiError
-	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.Message != "" {
-		return fmt.Errorf("gemini http %d: %s", resp.StatusCode, apiErr.Error.Message)
+	replied := time.Now()
+	ans, err := parseAnswer(candidateText)
+	if err != nil {
+		obs.Error(obs.StageLLM, s.provider.Name(), err)
+		return nil, err
 	}
-	return fmt.Errorf("gemini http %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	ans.FirstToken = replied
+	score(ans, req)
+	return ans, nil
 }
 
-func extractCandidateText(candidates []geminiCandidate) string {
-	for _, c := range candidates {
-		for _, part := range c.Content.Parts {
-			if t := strings.TrimSpace(part.Text); t != "" {
-				return t
-			}
-		}
+// score fills in the signals only the request knows and sets Confidence.
+func score(ans *Answer, req GenerateRequest) {
+	ans.Signals.Context = req.Richness
+	if lp := req.Logprobs; lp != nil && lp.Tokens > 0 {
+		ans.Signals.Logprob, ans.Signals.HasLogprob = lp.Mean(), true
 	}
-	return ""
+	ans.Confidence = ans.Signals.Score()
 }
 
-func trimCodeFence(text string) string {
-	trimmed := strings.TrimSpace(text)
-	if strings.HasPrefix(trimmed, "```") {
-		trimmed = strings.TrimPrefix(trimmed, "```")
-		trimmed = strings.TrimSpace(trimmed)
-		if strings.HasPrefix(strings.ToLower(trimmed), "json") {
-			trimmed = strings.TrimSpace(trimmed[4:])
-		}
-		if idx := strings.LastIndex(trimmed, "```"); idx != -1 {
-			trimmed = trimmed[:idx]
-		}
-		trimmed = strings.TrimSpace(trimmed)
+// Summarize folds evicted turns into the running conversation summary.
+// Cancelling ctx, normally the session's, abandons the request.
+func (s *Service) Summarize(ctx context.Context, prev string, turns []Turn) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("Update the running summary of a live conversation. Keep names, numbers, objections, commitments and open questions. Plain text, at most 80 words, no preamble.\n\n")
+	sb.WriteString("Current summary:\n")
+	if prev == "" {
+		sb.WriteString("none")
+	} else {
+		sb.WriteString(prev)
 	}
-	return trimmed
+	sb.WriteString("\n\nNew turns:\n")
+	sb.WriteString(formatTurns(turns, time.Now()))
+
+	var summary string
+	err := s.guard(ctx, TaskSummary, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		var err error
+		summary, err = s.provider.Generate(ctx, GenerateRequest{
+			Task:            TaskSummary,
+			Prompt:          sb.String(),
+			Summary:         prev,
+			Turns:           turns,
+			Temperature:     0.2,
+			TopP:            0.9,
+			MaxOutputTokens: 160,
+		})
+		return err
+	})
+	return summary, err
 }
 
 func contextualTokens(ocr []string, firstOCR []string, lastOCR []string) []string {
@@ -240,48 +319,3 @@ func contextualTokens(ocr []string, firstOCR []string, lastOCR []string) []strin
 	}
 	return uniq
 }
-
-type geminiRequest struct {
-	Contents         []geminiContent         `json:"contents"`
-	GenerationConfig *geminiGenerationConfig `json:"generationConfig,omitempty"`
-}
-
-type geminiContent struct {
-	Role  string       `json:"role,omitempty"`
-	Parts []geminiPart `json:"parts"`
-}
-
-type geminiPart struct {
-	Text       string            `json:"text,omitempty"`
-	InlineData *geminiInlineData `json:"inlineData,omitempty"`
-}
-
-type geminiInlineData struct {
-	MimeType string `json:"mimeType"`
-	Data     string `json:"data"`
-}
-
-type geminiGenerationConfig struct {
-	Temperature     float64 `json:"temperature,omitempty"`
-	TopP            float64 `json:"topP,omitempty"`
-	TopK            int     `json:"topK,omitempty"`
-	MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
-}
-
-type geminiResponse struct {
-	Candidates []geminiCandidate `json:"candidates"`
-}
-
-type geminiCandidate struct {
-	Content struct {
-		Parts []geminiPart `json:"parts"`
-	} `json:"content"`
-}
-
-type geminiError struct {
-	Error struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-		Status  string `json:"status"`
-	} `json:"error"`
-}
@@ -1,11 +1,16 @@
 package answer
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
 )
 
 func TestCallGeminiSuccess(t *testing.T) {
@@ -16,23 +21,24 @@ func TestCallGeminiSuccess(t *testing.T) {
 		if r.URL.Path != "/models/gemini-1.5-flash:generateContent" {
 			t.Fatalf("unexpected path: %s", r.URL.Path)
 		}
-		if r.URL.Query().Get("key") != "test-key" {
-			t.Fatalf("missing or incorrect key: %s", r.URL.RawQuery)
+		if r.Header.Get("x-goog-api-key") != "test-key" || r.URL.Query().Has("key") {
+			t.Fatalf("key not sent in the header only: %q %s", r.Header.Get("x-goog-api-key"), r.URL.RawQuery)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write([]byte(`{"candidates":[{"content":{"parts":[{"text":"{\"answer\":\"Anchor ROI to their uptime risk\",\"followUp\":\"Who signs off on this?\"}"}]}}]}`))
 	}))
 	defer srv.Close()
 
-	svc := NewServiceFromEnv()
-	svc.apiKey = "test-key"
-	svc.model = "gemini-1.5-flash"
-	svc.baseURL = srv.URL
-	svc.client = srv.Client()
+	svc := NewService(&geminiProvider{
+		apiKey:  "test-key",
+		model:   "gemini-1.5-flash",
+		baseURL: srv.URL,
+		client:  srv.Client(),
+	})
 
-	ans, err := svc.callGemini("prompt")
+	ans, err := svc.complete(context.Background(), GenerateRequest{Prompt: "prompt"})
 	if err != nil {
-		t.Fatalf("callGemini returned error: %v", err)
+		t.Fatalf("complete returned error: %v", err)
 	}
 	if ans.Answer != "Anchor ROI to their uptime risk" {
 		t.Fatalf("unexpected answer: %q", ans.Answer)
@@ -40,13 +46,38 @@ func TestCallGeminiSuccess(t *testing.T) {
 	if ans.FollowUp != "Who signs off on this?" {
 		t.Fatalf("unexpected followUp: %q", ans.FollowUp)
 	}
-	if ans.Confidence != 0.8 {
-		t.Fatalf("expected confidence 0.8, got %v", ans.Confidence)
+	// No signal at all: the model gave no confidence and the request no
+	// context.
+	if ans.Confidence != 0.5 {
+		t.Fatalf("expected neutral confidence 0.5, got %v", ans.Confidence)
+	}
+}
+
+func TestGeminiKeyStaysOutOfSpans(t *testing.T) {
+	var sb strings.Builder
+	trace.SetExporter(trace.NewStdoutExporter(&sb))
+	t.Cleanup(func() { trace.SetExporter(nil) })
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close() // dialing it fails, and the error quotes the URL
+	svc := NewService(&geminiProvider{apiKey: "secret-key", model: "gemini-1.5-flash", baseURL: srv.URL, client: srv.Client()})
+	svc.breaker = rt.NewBreaker("llm:gemini", rt.BreakerConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := svc.complete(ctx, GenerateRequest{Prompt: "prompt"}); err == nil {
+		t.Fatal("expected a dial error")
+	}
+	if !strings.Contains(sb.String(), srv.URL) {
+		t.Fatalf("span error does not quote the request: %s", sb.String())
+	}
+	if strings.Contains(sb.String(), "secret-key") {
+		t.Fatalf("API key exported in a span: %s", sb.String())
 	}
 }
 
 func TestBuildPromptIncludesContext(t *testing.T) {
-	got := buildPrompt("We need approval from finance soon", []string{"budget", "renewal"}, []string{"kickoff"}, []string{"procurement"})
+	got := buildPrompt(DefaultMode, "We need approval from finance soon", []string{"budget", "renewal"}, []string{"kickoff"}, []string{"procurement"}, Memory{})
 
 	checks := []string{
 		"<core_identity>",
@@ -69,21 +100,57 @@ func TestCallGeminiHandlesError(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	svc := NewServiceFromEnv()
-	svc.apiKey = "test-key"
-	svc.model = "gemini-1.5-flash"
-	svc.baseURL = srv.URL
-	svc.client = srv.Client()
+	svc := NewService(&geminiProvider{
+		apiKey:  "test-key",
+		model:   "gemini-1.5-flash",
+		baseURL: srv.URL,
+		client:  srv.Client(),
+	})
 
-	if _, err := svc.callGemini("prompt"); err == nil {
+	if _, err := svc.complete(context.Background(), GenerateRequest{Prompt: "prompt"}); err == nil {
 		t.Fatal("expected error, got nil")
 	}
 }
 
-func TestMain(m *testing.M) {
-	os.Setenv("GEMINI_API_KEY", "")
-	os.Setenv("GEMINI_MODEL", "")
-	os.Setenv("GEMINI_BASE_URL", "")
-	code := m.Run()
-	os.Exit(code)
+func TestMissingKeyDoesNotTripBreaker(t *testing.T) {
+	svc := NewService(newGeminiProvider(Endpoint{}))
+	svc.breaker = rt.NewBreaker("llm:gemini", rt.BreakerConfig{Failures: 1})
+	for i := 0; i < 3; i++ {
+		if _, err := svc.complete(context.Background(), GenerateRequest{Prompt: "p"}); !errors.Is(err, ErrNotConfigured) {
+			t.Fatalf("hint %d: got %v, want ErrNotConfigured", i, err)
+		}
+	}
+	if err := svc.Available(); err != nil {
+		t.Fatalf("breaker opened on a missing key: %v", err)
+	}
+}
+
+func TestSummaryFailuresDoNotOpenHintBreaker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":400,"message":"bad"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	svc := NewService(&geminiProvider{apiKey: "k", model: "m", baseURL: srv.URL, client: srv.Client()})
+	svc.breaker = rt.NewBreaker("llm:gemini", rt.BreakerConfig{Failures: 1})
+	svc.summaries = rt.NewBreaker("llm:gemini:summary", rt.BreakerConfig{Failures: 1})
+	if _, err := svc.Summarize(context.Background(), "", []Turn{{Text: "hello"}}); err == nil {
+		t.Fatal("expected the summary to fail")
+	}
+	if err := svc.Available(); err != nil {
+		t.Fatalf("a failed summary opened the hint breaker: %v", err)
+	}
+	if err := svc.summaries.Err(); err == nil {
+		t.Fatal("summary breaker did not record the failure")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc.summaries = rt.NewBreaker("llm:gemini:summary", rt.BreakerConfig{Failures: 1})
+	if _, err := svc.Summarize(ctx, "", []Turn{{Text: "hello"}}); err == nil {
+		t.Fatal("expected a cancelled summary to fail")
+	}
+	if err := svc.summaries.Err(); err != nil {
+		t.Fatalf("a cancelled summary counted against the breaker: %v", err)
+	}
 }
@@ -0,0 +1,295 @@
+package answer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"cluely/server/internal/obs"
+)
+
+// StreamingProvider is a Provider that can deliver output as it is generated.
+// onText receives the accumulated text after every chunk.
+type StreamingProvider interface {
+	Provider
+	Stream(ctx context.Context, req GenerateRequest, onText func(text string)) (string, error)
+}
+
+// Partial is a snapshot of a hint while it is being generated. A field is
+// Done once its closing quote has arrived, so it can be shown as final even
+// while the rest of the object is still streaming. Signals hold what is
+// known so far; the model's confidence is generated first.
+type Partial struct {
+	Answer       string
+	FollowUp     string
+	AnswerDone   bool
+	FollowUpDone bool
+	Signals      Signals
+	// FirstToken is when the provider's first output arrived.
+	FirstToken time.Time
+}
+
+// MicroStream is Micro with incremental output: onPartial is called whenever
+// the partially generated answer or follow-up grows. Providers that cannot
+// stream produce a single, complete Partial. It returns nil on failure or
+// when ctx is cancelled.
+func (s *Service) MicroStream(ctx context.Context, text string, ocr []string, firstOCR []string, lastOCR []string, mem Memory, onPartial func(Partial)) (ans *Answer) {
+	transcript := strings.TrimSpace(text)
+	if transcript == "" {
+		s.log.Debug("empty text, skipping")
+		return nil
+	}
+	ctx, span := s.startMicro(ctx)
+	defer func() { endMicro(span, ans) }()
+	req := s.hintRequest(transcript, ocr, firstOCR, lastOCR, mem)
+
+	sp, ok := s.provider.(StreamingProvider)
+	if !ok || onPartial == nil {
+		ans, err := s.complete(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.log.Warn("llm request failed", "provider", s.provider.Name(), "err", err)
+			return nil
+		}
+		if onPartial != nil {
+			onPartial(Partial{Answer: ans.Answer, FollowUp: ans.FollowUp, AnswerDone: true, FollowUpDone: true, Signals: ans.Signals, FirstToken: ans.FirstToken})
+		}
+		return ans
+	}
+
+	var (
+		last       Partial
+		full       string
+		firstToken time.Time
+	)
+	err := s.guard(ctx, req.Task, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		var err error
+		full, err = sp.Stream(ctx, req, func(acc string) {
+			if firstToken.IsZero() {
+				firstToken = time.Now()
+			}
+			p := clampPartial(parsePartial(acc))
+			p.Signals.Context = req.Richness
+			p.FirstToken = firstToken
+			if p != last {
+				last = p
+				onPartial(p)
+			}
+		})
+		return err
+	})
+	if err != nil {
+		span.RecordError(err)
+		if ctx.Err() == nil {
+			s.log.Warn("llm stream failed", "provider", s.provider.Name(), "err", err)
+		}
+		return nil
+	}
+	ans, err = parseAnswer(full)
+	if err != nil {
+		s.log.Warn("llm stream returned a bad payload", "provider", s.provider.Name(), "err", err)
+		obs.Error(obs.StageLLM, s.provider.Name(), err)
+		span.RecordError(err)
+		return nil
+	}
+	ans.FirstToken = firstToken
+	score(ans, req)
+	return ans
+}
+
+// readSSE calls onData with the payload of every server-sent event in r
+// until r ends, onData returns false, or a "[DONE]" sentinel arrives.
+func readSSE(r io.Reader, onData func(data string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var data strings.Builder
+	dispatch := func() bool {
+		payload := strings.TrimSpace(data.String())
+		data.Reset()
+		if payload == "" {
+			return true
+		}
+		if payload == "[DONE]" {
+			return false
+		}
+		return onData(payload)
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			continue
+		}
+		if strings.TrimSpace(line) == "" && !dispatch() {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	dispatch()
+	return nil
+}
+
+// parsePartial extracts the "answer" and "followUp" string values and the
+// "confidence" number from a JSON object that may be cut off anywhere.
+// Unterminated strings yield what has arrived so far; escapes are decoded;
+// other keys are skipped. A number counts only once a delimiter follows it.
+func parsePartial(buf string) Partial {
+	var p Partial
+	i := strings.IndexByte(buf, '{')
+	if i < 0 {
+		return p
+	}
+	i++
+	for i < len(buf) {
+		i = skipSpaceAndCommas(buf, i)
+		if i >= len(buf) || buf[i] == '}' {
+			return p
+		}
+		if buf[i] != '"' {
+			return p
+		}
+		key, next, done := readJSONString(buf, i)
+		if !done {
+			return p
+		}
+		i = skipSpace(buf, next)
+		if i >= len(buf) || buf[i] != ':' {
+			return p
+		}
+		i = skipSpace(buf, i+1)
+		if i >= len(buf) {
+			return p
+		}
+		if buf[i] != '"' {
+			// Non-string value: skip to the next comma or brace.
+			start := i
+			for i < len(buf) && buf[i] != ',' && buf[i] != '}' {
+				i++
+			}
+			if key == "confidence" && i < len(buf) {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(buf[start:i]), 64); err == nil {
+					p.Signals.Model, p.Signals.HasModel = clamp01(v), true
+				}
+			}
+			continue
+		}
+		val, next, closed := readJSONString(buf, i)
+		switch key {
+		case "answer":
+			p.Answer, p.AnswerDone = val, closed
+		case "followUp":
+			p.FollowUp, p.FollowUpDone = val, closed
+		}
+		if !closed {
+			return p
+		}
+		i = next
+	}
+	return p
+}
+
+// hexRune decodes the four hex digits of a \u escape, or returns
+// utf8.RuneError if they are not hex.
+func hexRune(h string) rune {
+	var r rune
+	for _, c := range h {
+		r <<= 4
+		switch {
+		case c >= '0' && c <= '9':
+			r |= c - '0'
+		case c >= 'a' && c <= 'f':
+			r |= c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			r |= c - 'A' + 10
+		default:
+			return utf8.RuneError
+		}
+	}
+	return r
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n' || s[i] == '\r' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+func skipSpaceAndCommas(s string, i int) int {
+	for i < len(s) && (s[i] == ',' || s[i] == ' ' || s[i] == '\n' || s[i] == '\r' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// readJSONString decodes the string starting at the quote s[i]. It returns the
+// decoded prefix, the index after the closing quote and whether the string
+// was closed. An escape cut off mid-way is dropped until it completes.
+func readJSONString(s string, i int) (string, int, bool) {
+	var sb strings.Builder
+	i++
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '"':
+			return sb.String(), i + 1, true
+		case c == '\\':
+			if i+1 >= len(s) {
+				return sb.String(), i, false
+			}
+			switch e := s[i+1]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b', 'f':
+			case 'u':
+				if i+6 > len(s) {
+					return sb.String(), i, false
+				}
+				r := hexRune(s[i+2 : i+6])
+				i += 6
+				if utf16.IsSurrogate(r) {
+					// A high surrogate pairs with the \u escape after it;
+					// wait for that one to arrive in full.
+					rest := s[i:]
+					if len(rest) < 6 && strings.HasPrefix(`\u`, rest[:min(len(rest), 2)]) {
+						return sb.String(), i - 6, false
+					}
+					if strings.HasPrefix(rest, `\u`) {
+						if pair := utf16.DecodeRune(r, hexRune(rest[2:6])); pair != utf8.RuneError {
+							r = pair
+							i += 6
+						}
+					}
+				}
+				sb.WriteRune(r)
+				continue
+			default:
+				sb.WriteByte(e)
+			}
+			i += 2
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String(), i, false
+}
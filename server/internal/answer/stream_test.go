@@ -0,0 +1,82 @@
+package answer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsePartial(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Partial
+	}{
+		{``, Partial{}},
+		{`{"answ`, Partial{}},
+		{`{"answer":"Tie up`, Partial{Answer: "Tie up"}},
+		{`{"answer":"Tie uptime \"risk\"","fo`, Partial{Answer: `Tie uptime "risk"`, AnswerDone: true}},
+		{`{"confidence":0.9,"answer":"A","followUp":"Who `, Partial{Answer: "A", AnswerDone: true, FollowUp: "Who ", Signals: Signals{Model: 0.9, HasModel: true}}},
+		{`{"confidence":0.`, Partial{}},
+		{`{"confidence":7,"answer":"`, Partial{Signals: Signals{Model: 1, HasModel: true}}},
+		{"```json\n{\"answer\":\"A\",\"followUp\":\"B?\"}\n```", Partial{Answer: "A", AnswerDone: true, FollowUp: "B?", FollowUpDone: true}},
+		{`{"answer":"café \u00`, Partial{Answer: "café "}},
+		{`{"answer":"Nice \ud83d\ude80 launch"}`, Partial{Answer: "Nice 🚀 launch", AnswerDone: true}},
+		{`{"answer":"Nice \ud83d\ude`, Partial{Answer: "Nice "}},
+		{`{"answer":"Nice \ud83d`, Partial{Answer: "Nice "}},
+		{`{"answer":"lone \ud83d!"}`, Partial{Answer: "lone \ufffd!", AnswerDone: true}},
+	}
+	for _, tc := range cases {
+		if got := parsePartial(tc.in); got != tc.want {
+			t.Errorf("parsePartial(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMicroStreamForwardsGeminiChunks(t *testing.T) {
+	chunks := []string{
+		`{\"answer\":\"Anchor ROI`,
+		` to uptime\",\"followUp\":\"Who`,
+		` signs off?\"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-1.5-flash:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Fatalf("unexpected request: %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"%s\"}]}}]}\n\n", c)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	svc := NewService(&geminiProvider{apiKey: "k", model: "gemini-1.5-flash", baseURL: srv.URL, client: srv.Client()})
+	var got []Partial
+	ans := svc.MicroStream(context.Background(), "what about uptime", nil, nil, nil, Memory{}, func(p Partial) { got = append(got, p) })
+	if ans == nil || ans.Answer != "Anchor ROI to uptime" || ans.FollowUp != "Who signs off?" {
+		t.Fatalf("unexpected answer: %+v", ans)
+	}
+	// Three words, no screen or memory.
+	sig := Signals{Context: 0.5}
+	want := []Partial{
+		{Answer: "Anchor ROI", Signals: sig},
+		{Answer: "Anchor ROI to uptime", AnswerDone: true, FollowUp: "Who", Signals: sig},
+		{Answer: "Anchor ROI to uptime", AnswerDone: true, FollowUp: "Who signs off?", FollowUpDone: true, Signals: sig},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d partials, got %+v", len(want), got)
+	}
+	for i := range want {
+		// Every partial reports when the first chunk arrived.
+		if got[i].FirstToken.IsZero() || !got[i].FirstToken.Equal(ans.FirstToken) {
+			t.Errorf("partial %d first token at %v, answer's at %v", i, got[i].FirstToken, ans.FirstToken)
+		}
+		got[i].FirstToken = time.Time{}
+		if got[i] != want[i] {
+			t.Fatalf("partial %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
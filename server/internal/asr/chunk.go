@@ -0,0 +1,444 @@
+package asr
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/trace"
+)
+
+// pcmBytesPerSecond is 16 kHz mono signed 16-bit little-endian PCM.
+const pcmBytesPerSecond = 16000 * 2
+
+// ChunkConfig controls how a batch transcription backend (gemini, whisper)
+// slices audio. Zero fields take the defaults below.
+type ChunkConfig struct {
+	// Chunk is how much new audio triggers a transcription.
+	Chunk time.Duration `yaml:"chunk"`
+	// Overlap is how much of the previous chunk is resent with the next so
+	// words cut at a boundary are heard whole at least once.
+	Overlap time.Duration `yaml:"overlap"`
+	// MaxBuffer caps audio held while the backend is busy. Frames beyond it
+	// are dropped and counted.
+	MaxBuffer time.Duration `yaml:"maxBuffer"`
+	// Silence is how long the input must stay below SilenceRMS after speech
+	// before the utterance is finalized.
+	Silence    time.Duration `yaml:"silence"`
+	SilenceRMS float64       `yaml:"silenceRMS"`
+}
+
+func (c ChunkConfig) withDefaults() ChunkConfig {
+	if c.Chunk <= 0 {
+		c.Chunk = 3 * time.Second
+	}
+	if c.Overlap <= 0 {
+		c.Overlap = 500 * time.Millisecond
+	}
+	if c.Overlap >= c.Chunk {
+		c.Overlap = c.Chunk / 2
+	}
+	if c.MaxBuffer <= 0 {
+		c.MaxBuffer = 30 * time.Second
+	}
+	if c.MaxBuffer < c.Chunk {
+		c.MaxBuffer = c.Chunk
+	}
+	if c.Silence <= 0 {
+		c.Silence = 800 * time.Millisecond
+	}
+	if c.SilenceRMS <= 0 {
+		c.SilenceRMS = 500
+	}
+	return c
+}
+
+func pcmBytes(d time.Duration) int {
+	n := int(d.Seconds() * pcmBytesPerSecond)
+	return n &^ 1 // whole samples
+}
+
+// transcribeFunc turns one chunk of PCM into text. onPartial, when the
+// backend streams, receives the chunk's text so far. ctx carries the
+// chunk's span.
+type transcribeFunc func(ctx context.Context, audio []byte, onPartial func(string)) (string, error)
+
+type chunkJob struct {
+	audio []byte
+	final bool
+	// discard ends the utterance without a final.
+	discard bool
+	// utterance is the span of the utterance the audio belongs to; a final
+	// job ends it.
+	utterance *trace.Span
+}
+
+// chunkedStream turns a batch transcription backend into a streaming one.
+// Audio is cut into overlapping chunks that are transcribed in order by a
+// single worker; chunk transcripts are stitched into a running partial and
+// the utterance is emitted as a final after trailing silence or on Flush.
+type chunkedStream struct {
+	cfg        ChunkConfig
+	transcribe transcribeFunc
+	name       string
+	log        *slog.Logger
+	events     chan Event
+	wake       chan struct{} // tells the worker queue has grown
+	done       chan struct{}
+	// ctx is cancelled when Close has waited closeGrace for the worker, so
+	// a slow upstream cannot hold up the end of a session.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	closeGrace time.Duration
+
+	chunkBytes, overlapBytes, maxBytes, silenceBytes int
+
+	mu        sync.Mutex
+	queue     []chunkJob // jobs for the worker, in order
+	pending   []byte     // overlap from the last chunk followed by unsent audio
+	unsent    int        // bytes at the end of pending not yet sent
+	voiced    bool       // pending utterance contains speech
+	silentRun int        // trailing bytes below SilenceRMS
+	dropped   int64
+	closed    bool
+	closeOnce sync.Once
+	utterance *trace.Span // from the first speech to the final; nil between
+	running   string      // stitched text of the current utterance (worker only)
+	lastSent  string      // last partial emitted (worker only)
+}
+
+// newChunkedStream transcribes with fn; log, nil for slog.Default(), gets
+// its failures.
+func newChunkedStream(name string, cfg ChunkConfig, log *slog.Logger, fn transcribeFunc) *chunkedStream {
+	cfg = cfg.withDefaults()
+	if log == nil {
+		log = slog.Default()
+	}
+	s := &chunkedStream{
+		cfg:          cfg,
+		transcribe:   fn,
+		name:         name,
+		log:          log.With("asr", name),
+		events:       make(chan Event, 16),
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		chunkBytes:   pcmBytes(cfg.Chunk),
+		overlapBytes: pcmBytes(cfg.Overlap),
+		maxBytes:     pcmBytes(cfg.MaxBuffer),
+		silenceBytes: pcmBytes(cfg.Silence),
+		closeGrace:   closeGrace,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.work()
+	return s
+}
+
+// WritePCM buffers data and hands a chunk to the worker when enough new
+// audio has arrived. It never blocks: if the worker is behind, audio piles
+// up to MaxBuffer and further frames are dropped.
+func (s *chunkedStream) WritePCM(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if len(s.pending)+len(data) > s.maxBytes {
+		s.dropped++
+		obs.IncPCMFrameDrop()
+		return false
+	}
+	s.pending = append(s.pending, data...)
+	s.unsent += len(data)
+
+	if rms(data) < s.cfg.SilenceRMS {
+		s.silentRun += len(data)
+	} else {
+		s.silentRun = 0
+		s.voiced = true
+		s.utteranceLocked()
+	}
+
+	switch {
+	case s.voiced && s.silentRun >= s.silenceBytes:
+		s.submitLocked(true)
+	case !s.voiced && s.silentRun >= s.chunkBytes:
+		// Nothing but silence: keep only the overlap so it cannot pile up.
+		s.pending = append(s.pending[:0], s.pending[len(s.pending)-s.overlapBytes:]...)
+		s.unsent = 0
+	case s.unsent >= s.chunkBytes:
+		s.submitLocked(false)
+	}
+	return true
+}
+
+// maxQueuedChunks is how many jobs WritePCM may leave waiting for the
+// worker. Flush and Close always queue their final.
+const maxQueuedChunks = 2
+
+// submitLocked queues pending for the worker if it has room. A final job
+// ends the utterance; otherwise the overlap is kept for the next chunk.
+// Caller holds s.mu.
+func (s *chunkedStream) submitLocked(final bool) {
+	if len(s.queue) >= maxQueuedChunks {
+		return // worker busy; retry on the next frame
+	}
+	audio := append([]byte(nil), s.pending...)
+	s.queueLocked(chunkJob{audio: audio, final: final, utterance: s.utteranceLocked()})
+	if final {
+		s.utterance = nil
+		s.pending = s.pending[:0]
+		s.voiced = false
+		s.silentRun = 0
+		s.unsent = 0
+		return
+	}
+	if keep := s.overlapBytes; len(s.pending) > keep {
+		s.pending = append(s.pending[:0], s.pending[len(s.pending)-keep:]...)
+	}
+	s.unsent = 0
+}
+
+// utteranceLocked returns the current utterance's span, starting it with the
+// first audio worth transcribing. Caller holds s.mu.
+func (s *chunkedStream) utteranceLocked() *trace.Span {
+	if s.utterance == nil {
+		s.utterance = trace.StartFrom(trace.SpanContext{}, "asr.utterance", trace.String("asr.provider", s.name))
+	}
+	return s.utterance
+}
+
+func (s *chunkedStream) Events() <-chan Event { return s.events }
+
+func (s *chunkedStream) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// queueLocked hands job to the worker. It never blocks. Caller holds s.mu.
+func (s *chunkedStream) queueLocked(job chunkJob) {
+	s.queue = append(s.queue, job)
+	select {
+	case s.wake <- struct{}{}:
+	default: // a wake-up is already pending
+	}
+}
+
+// Flush ends the current utterance: whatever audio is buffered is
+// transcribed and emitted as a final. It returns at once; the final is
+// queued behind the chunks already waiting.
+func (s *chunkedStream) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.utterance.AddEvent("flush")
+	s.queueLocked(s.takeFinalLocked())
+}
+
+// takeFinalLocked empties the buffer into a final job. Audio that already
+// went out in chunks is not resent. Caller holds s.mu.
+func (s *chunkedStream) takeFinalLocked() chunkJob {
+	job := chunkJob{final: true}
+	if s.unsent > 0 {
+		job.audio = append([]byte(nil), s.pending...)
+		s.utteranceLocked()
+	}
+	job.utterance, s.utterance = s.utterance, nil
+	s.pending = s.pending[:0]
+	s.unsent = 0
+	s.voiced = false
+	s.silentRun = 0
+	return job
+}
+
+// Discard drops the buffered utterance and the chunks still queued for it
+// without transcribing them. A chunk already in flight finishes but its text
+// is thrown away.
+func (s *chunkedStream) Discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, job := range s.queue {
+		if job.final {
+			job.utterance.End()
+		}
+	}
+	s.queue = s.queue[:0]
+	job := s.takeFinalLocked()
+	job.audio, job.discard = nil, true
+	s.queueLocked(job)
+}
+
+// closeGrace is how long Close lets queued jobs finish before it abandons
+// them.
+const closeGrace = 2 * time.Second
+
+// Close finalizes buffered audio, waits up to closeGrace for the worker and
+// closes Events. Jobs still running after that are cancelled.
+func (s *chunkedStream) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.queueLocked(s.takeFinalLocked())
+		s.mu.Unlock()
+		abandon := time.AfterFunc(s.closeGrace, s.cancel)
+		<-s.done
+		abandon.Stop()
+		s.cancel()
+		close(s.events)
+	})
+}
+
+// work runs queued jobs in order until the stream is closed and the queue
+// is empty.
+func (s *chunkedStream) work() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			<-s.wake
+			continue
+		}
+		job := s.queue[0]
+		s.queue[0] = chunkJob{}
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		s.run(job)
+	}
+}
+
+// run transcribes one job and emits what it yields.
+func (s *chunkedStream) run(job chunkJob) {
+	if len(job.audio) > 0 {
+		start := time.Now()
+		ctx, span := trace.Start(trace.ContextWith(s.ctx, job.utterance.Context()), "asr.transcribe",
+			trace.String("asr.provider", s.name),
+			trace.Duration("asr.audio_ms", time.Duration(len(job.audio))*time.Second/pcmBytesPerSecond),
+			trace.Bool("asr.final", job.final),
+		)
+		text, err := s.transcribe(ctx, job.audio, func(partial string) {
+			s.emitPartial(stitch(s.running, partial))
+		})
+		span.RecordError(err)
+		span.End()
+		if err != nil {
+			s.log.Warn("transcribe failed", "err", err)
+			obs.Error(obs.StageASR, s.name, err)
+			s.emit(Event{Type: "error", Err: err})
+		} else {
+			obs.ASRLatency.With(s.name).ObserveSince(start)
+			s.running = stitch(s.running, text)
+			s.emitPartial(s.running)
+		}
+	}
+	if job.discard {
+		job.utterance.AddEvent("discard")
+		job.utterance.End()
+		s.running, s.lastSent = "", ""
+		return
+	}
+	if job.final {
+		if strings.TrimSpace(s.running) != "" {
+			s.emit(Event{Type: "final", Text: s.running, IsFinal: true, Trace: job.utterance.Context()})
+		}
+		job.utterance.SetAttrs(trace.Int("asr.words", len(strings.Fields(s.running))))
+		job.utterance.End()
+		s.running, s.lastSent = "", ""
+	}
+}
+
+func (s *chunkedStream) emitPartial(text string) {
+	if strings.TrimSpace(text) == "" || text == s.lastSent {
+		return
+	}
+	s.lastSent = text
+	s.emit(Event{Type: "partial", Text: text})
+}
+
+func (s *chunkedStream) emit(evt Event) {
+	select {
+	case s.events <- evt:
+	default:
+		s.log.Warn("dropping event, channel full", "event", evt.Type)
+	}
+}
+
+// stitch appends next to prev, dropping the words next repeats from the
+// end of prev because the chunks overlapped. Words are compared without
+// case or punctuation; the longest overlap of up to maxStitchWords wins.
+func stitch(prev, next string) string {
+	prev, next = strings.TrimSpace(prev), strings.TrimSpace(next)
+	if prev == "" {
+		return next
+	}
+	if next == "" {
+		return prev
+	}
+	pw, nw := strings.Fields(prev), strings.Fields(next)
+	max := maxStitchWords
+	if len(pw) < max {
+		max = len(pw)
+	}
+	if len(nw) < max {
+		max = len(nw)
+	}
+	for k := max; k > 0; k-- {
+		if sameWords(pw[len(pw)-k:], nw[:k]) {
+			nw = nw[k:]
+			break
+		}
+	}
+	if len(nw) == 0 {
+		return prev
+	}
+	return prev + " " + strings.Join(nw, " ")
+}
+
+const maxStitchWords = 8
+
+func sameWords(a, b []string) bool {
+	for i := range a {
+		if normWord(a[i]) != normWord(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func normWord(w string) string {
+	return strings.ToLower(strings.TrimFunc(w, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}))
+}
+
+// rms is the root mean square of 16-bit little-endian samples.
+func rms(pcm []byte) float64 {
+	n := len(pcm) / 2
+	if n == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		v := float64(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(n))
+}
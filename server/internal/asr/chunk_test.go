@@ -0,0 +1,188 @@
+package asr
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestStitchDropsOverlappingWords(t *testing.T) {
+	cases := []struct{ prev, next, want string }{
+		{"", "hello there", "hello there"},
+		{"so what is the", "is the budget", "so what is the budget"},
+		{"so what is the budget", "Budget, for this quarter", "so what is the budget for this quarter"},
+		{"this quarter", "quarter.", "this quarter"},
+		{"we shipped", "last week", "we shipped last week"},
+	}
+	for _, c := range cases {
+		if got := stitch(c.prev, c.next); got != c.want {
+			t.Errorf("stitch(%q, %q) = %q, want %q", c.prev, c.next, got, c.want)
+		}
+	}
+}
+
+// frame returns 20ms of 16 kHz PCM at a constant amplitude.
+func frame(amp int16) []byte {
+	b := make([]byte, pcmBytes(20*time.Millisecond))
+	for i := 0; i < len(b); i += 2 {
+		binary.LittleEndian.PutUint16(b[i:], uint16(amp))
+	}
+	return b
+}
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case evt := <-ch:
+		return evt
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for ASR event")
+		return Event{}
+	}
+}
+
+func TestChunkedStreamPartialsThenFinalOnSilence(t *testing.T) {
+	script := []string{"so what is the", "is the budget this quarter", "quarter."}
+	calls := 0
+	s := newChunkedStream("test", ChunkConfig{
+		Chunk:   100 * time.Millisecond,
+		Overlap: 20 * time.Millisecond,
+		Silence: 60 * time.Millisecond,
+	}, nil, func(_ context.Context, audio []byte, _ func(string)) (string, error) {
+		text := script[calls]
+		calls++
+		return text, nil
+	})
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		if !s.WritePCM(frame(3000)) {
+			t.Fatalf("frame %d rejected", i)
+		}
+	}
+	if evt := nextEvent(t, s.Events()); evt.Type != "partial" || evt.Text != "so what is the" {
+		t.Fatalf("unexpected first event: %#v", evt)
+	}
+	if evt := nextEvent(t, s.Events()); evt.Type != "partial" || evt.Text != "so what is the budget this quarter" {
+		t.Fatalf("unexpected second event: %#v", evt)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.WritePCM(frame(0))
+	}
+	if evt := nextEvent(t, s.Events()); !evt.IsFinal || evt.Text != "so what is the budget this quarter" {
+		t.Fatalf("unexpected final: %#v", evt)
+	}
+	if s.Dropped() != 0 {
+		t.Fatalf("unexpected drops: %d", s.Dropped())
+	}
+}
+
+func TestChunkedStreamDropsBeyondBufferCap(t *testing.T) {
+	release := make(chan struct{})
+	s := newChunkedStream("test", ChunkConfig{
+		Chunk:     100 * time.Millisecond,
+		MaxBuffer: 200 * time.Millisecond,
+	}, nil, func(_ context.Context, audio []byte, _ func(string)) (string, error) {
+		<-release
+		return "", nil
+	})
+
+	var rejected int64
+	for i := 0; i < 100; i++ {
+		if !s.WritePCM(frame(3000)) {
+			rejected++
+		}
+	}
+	if rejected == 0 {
+		t.Fatal("expected frames to be dropped while the backend is stalled")
+	}
+	if got := s.Dropped(); got != rejected {
+		t.Fatalf("Dropped() = %d, want %d", got, rejected)
+	}
+	s.mu.Lock()
+	held := len(s.pending)
+	s.mu.Unlock()
+	if held > pcmBytes(200*time.Millisecond) {
+		t.Fatalf("buffer grew past cap: %d bytes", held)
+	}
+	close(release)
+	s.Close()
+}
+
+func TestChunkedStreamFlushDoesNotWaitForBackend(t *testing.T) {
+	release := make(chan struct{})
+	s := newChunkedStream("test", ChunkConfig{Chunk: 100 * time.Millisecond}, nil, func(_ context.Context, audio []byte, _ func(string)) (string, error) {
+		<-release
+		return "done", nil
+	})
+
+	returned := make(chan struct{})
+	go func() {
+		// More finals than the queue holds chunks, all behind a stalled
+		// transcription.
+		for i := 0; i < 5; i++ {
+			s.WritePCM(frame(3000))
+			s.Flush()
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Flush blocked on a busy backend")
+	}
+	close(release)
+	evt := nextEvent(t, s.Events())
+	for evt.Type == "partial" {
+		evt = nextEvent(t, s.Events())
+	}
+	if !evt.IsFinal || evt.Text != "done" {
+		t.Fatalf("unexpected event: %#v", evt)
+	}
+	s.Close()
+}
+
+func TestChunkedStreamCloseAbandonsStalledBackend(t *testing.T) {
+	s := newChunkedStream("test", ChunkConfig{Chunk: 100 * time.Millisecond}, nil, func(ctx context.Context, audio []byte, _ func(string)) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	s.closeGrace = 50 * time.Millisecond
+	s.WritePCM(frame(3000))
+	s.Flush()
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close waited on a stalled backend")
+	}
+}
+
+func TestChunkedStreamDiscardSkipsTranscription(t *testing.T) {
+	calls := 0
+	s := newChunkedStream("test", ChunkConfig{Chunk: time.Second}, nil, func(_ context.Context, audio []byte, _ func(string)) (string, error) {
+		calls++
+		return "next", nil
+	})
+	s.WritePCM(frame(3000))
+	s.Discard()
+	s.WritePCM(frame(3000))
+	s.Flush()
+	if evt := nextEvent(t, s.Events()); evt.Type != "partial" {
+		t.Fatalf("unexpected event: %#v", evt)
+	}
+	if evt := nextEvent(t, s.Events()); !evt.IsFinal || evt.Text != "next" {
+		t.Fatalf("unexpected event: %#v", evt)
+	}
+	s.Close()
+	if calls != 1 {
+		t.Fatalf("transcribed %d times, want only the utterance after Discard", calls)
+	}
+}
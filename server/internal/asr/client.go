@@ -3,19 +3,27 @@ package asr
 import (
 	"errors"
 	"fmt"
-	"os"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
-	"cluely/server/internal/obs"
+	"cluely/server/internal/trace"
 )
 
+// Event is a transcript update. Type is "partial" or "final"; a provider
+// that loses audio reports Type "error" with Err set so a failover chain
+// can retry it elsewhere. The chain itself reports "degraded" (Text names
+// the provider now in use) and "unavailable".
 type Event struct {
 	Type    string
 	Text    string
 	IsFinal bool
+	Err     error
+	// Confidence is the recognizer's confidence in a final, 0..1, or 0
+	// when it does not report one.
+	Confidence float64
+	// Trace is the span of the utterance a final ends, so the hint for it
+	// joins the same trace. Zero for providers that do not trace.
+	Trace trace.SpanContext
 }
 
 type Client interface {
@@ -23,79 +31,54 @@ type Client interface {
 	Events() <-chan Event
 	Dropped() int64
 	Flush()
+	// Discard drops the utterance in progress without transcribing it.
+	Discard()
 	Close()
 }
 
-func New(provider string) (Client, error) {
-	switch strings.ToLower(strings.TrimSpace(provider)) {
+// New builds the client cfg.Provider names. An empty provider, "none" or
+// "disabled" returns a nil Client and no error. A comma-separated list
+// builds a failover chain in priority order.
+func New(cfg Config) (Client, error) {
+	if strings.Contains(cfg.Provider, ",") {
+		return newFailoverFromConfig(cfg)
+	}
+	switch provider := strings.ToLower(strings.TrimSpace(cfg.Provider)); provider {
 	case "", "none", "disabled":
 		return nil, nil
 	case "stub":
-		return newStubClient(), nil
+		return newStubClientFromConfig(cfg.Stub)
 	case "gemini":
-		return newGeminiClientFromEnv()
+		return newGeminiClientFromConfig(cfg)
+	case "whisper", "openai":
+		return newWhisperClientFromConfig(cfg)
+	case "vosk", "ws":
+		return newVoskClientFromConfig(cfg)
+	case "replay":
+		return newReplayClientFromFile(cfg.ReplayFile)
 	default:
 		return nil, fmt.Errorf("asr provider %q not supported", provider)
 	}
 }
 
-type stubClient struct {
-	events    chan Event
-	dropped   int64
-	closeOnce sync.Once
-}
-
-func newStubClient() Client {
-	ch := make(chan Event)
-	close(ch)
-	return &stubClient{events: ch}
-}
-
-func (c *stubClient) WritePCM(_ []byte) bool {
-	atomic.AddInt64(&c.dropped, 1)
-	obs.IncPCMFrameDrop()
-	return false
-}
-
-func (c *stubClient) Events() <-chan Event { return c.events }
-
-func (c *stubClient) Dropped() int64 { return atomic.LoadInt64(&c.dropped) }
-
-func (c *stubClient) Flush() {}
-
-func (c *stubClient) Close() {
-	c.closeOnce.Do(func() {})
-}
-
 const (
 	defaultGeminiASRModel = "gemini-1.5-flash"
 	defaultGeminiBaseURL  = "https://generativelanguage.googleapis.com/v1beta"
 )
 
-func newGeminiClientFromEnv() (Client, error) {
-	apiKey := strings.TrimSpace(os.Getenv("GEMINI_API_KEY"))
+func newGeminiClientFromConfig(cfg Config) (Client, error) {
+	apiKey := strings.TrimSpace(cfg.Gemini.APIKey)
 	if apiKey == "" {
-		return nil, errors.New("GEMINI_API_KEY is required for ASR provider gemini")
+		return nil, errors.New("an API key is required for ASR provider gemini")
 	}
-
-	model := firstNonEmpty(
-		os.Getenv("GEMINI_ASR_MODEL"),
-		os.Getenv("GEMINI_MODEL"),
-		defaultGeminiASRModel,
-	)
-	base := firstNonEmpty(
-		os.Getenv("GEMINI_ASR_BASE_URL"),
-		os.Getenv("GEMINI_BASE_URL"),
-		defaultGeminiBaseURL,
-	)
-
-	cfg := geminiConfig{
-		APIKey:  strings.TrimSpace(apiKey),
-		Model:   strings.TrimSpace(model),
-		BaseURL: strings.TrimRight(strings.TrimSpace(base), "/"),
+	return newGeminiClient(geminiConfig{
+		APIKey:  apiKey,
+		Model:   firstNonEmpty(cfg.Gemini.Model, defaultGeminiASRModel),
+		BaseURL: strings.TrimRight(firstNonEmpty(cfg.Gemini.BaseURL, defaultGeminiBaseURL), "/"),
 		Timeout: 12 * time.Second,
-	}
-	return newGeminiClient(cfg)
+		Chunk:   cfg.Chunk,
+		Logger:  cfg.logger(),
+	})
 }
 
 func firstNonEmpty(values ...string) string {
@@ -0,0 +1,136 @@
+package asr
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config selects the speech recognizer and tunes it. The zero value turns
+// ASR off.
+type Config struct {
+	// Provider names the backend: stub, gemini, whisper, vosk or replay. A
+	// comma-separated list is a failover chain in priority order; empty,
+	// none or disabled turns ASR off.
+	Provider string `yaml:"provider"`
+
+	Gemini  Endpoint      `yaml:"gemini"`
+	Whisper WhisperConfig `yaml:"whisper"`
+	Vosk    VoskConfig    `yaml:"vosk"`
+	Stub    StubConfig    `yaml:"stub"`
+	// ReplayFile is the JSONL transcript the replay provider plays back.
+	ReplayFile string `yaml:"replayFile"`
+	// Chunk tunes the batch backends, gemini and whisper.
+	Chunk ChunkConfig `yaml:"chunk"`
+
+	// Logger receives backend errors and failovers; nil means
+	// slog.Default(). Sessions set their own so these lines carry the
+	// session id.
+	Logger *slog.Logger `yaml:"-"`
+}
+
+func (c Config) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
+
+// Endpoint says where a backend is and how to authenticate. Empty fields
+// take the backend's defaults.
+type Endpoint struct {
+	APIKey  string `yaml:"apiKey"`
+	Model   string `yaml:"model"`
+	BaseURL string `yaml:"baseURL"`
+}
+
+// WhisperConfig is an OpenAI-compatible /audio/transcriptions endpoint.
+type WhisperConfig struct {
+	Endpoint `yaml:",inline"`
+	// Language is an ISO-639-1 hint, empty to let the model detect it.
+	Language string `yaml:"language"`
+}
+
+// VoskConfig is a recognizer speaking the Vosk WebSocket protocol.
+type VoskConfig struct {
+	URL string `yaml:"url"`
+	// Buffer is how many frames may queue for the socket; 0 means 128.
+	Buffer int `yaml:"buffer"`
+}
+
+// StubConfig scripts the stub provider. With neither Script nor Lines it
+// plays a built-in three-line script.
+type StubConfig struct {
+	// Script is a file with one utterance per line; # starts a comment.
+	Script string   `yaml:"script"`
+	Lines  []string `yaml:"lines"`
+	// Pace is "pcm" (default) to advance one word per Word of received
+	// audio, or "time" to advance on the clock.
+	Pace string        `yaml:"pace"`
+	Word time.Duration `yaml:"word"`
+	// Gap is the pause after each final.
+	Gap  time.Duration `yaml:"gap"`
+	Loop bool          `yaml:"loop"`
+}
+
+// Providers returns the backends Provider names, in order, without the
+// ones that mean off.
+func (c Config) Providers() []string {
+	var names []string
+	for _, name := range strings.Split(c.Provider, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "", "none", "disabled":
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Validate checks what New would refuse, without connecting anywhere.
+func (c Config) Validate() error {
+	var errs []error
+	for _, name := range c.Providers() {
+		if err := c.validate(name); err != nil {
+			errs = append(errs, fmt.Errorf("asr provider %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c Config) validate(name string) error {
+	switch name {
+	case "stub":
+		if p := c.Stub.Pace; p != "" && p != "pcm" && p != "time" {
+			return fmt.Errorf("stub pace %q is not pcm or time", p)
+		}
+		if c.Stub.Script != "" {
+			_, err := os.Stat(c.Stub.Script)
+			return err
+		}
+	case "gemini":
+		if strings.TrimSpace(c.Gemini.APIKey) == "" {
+			return errors.New("gemini needs an API key")
+		}
+	case "whisper", "openai":
+		if c.Whisper.APIKey == "" && strings.TrimRight(firstNonEmpty(c.Whisper.BaseURL, defaultWhisperBaseURL), "/") == defaultWhisperBaseURL {
+			return errors.New("api.openai.com needs an API key; set a base URL for a local server")
+		}
+	case "vosk", "ws":
+		if u := c.Vosk.URL; u != "" && !strings.HasPrefix(u, "ws://") && !strings.HasPrefix(u, "wss://") {
+			return fmt.Errorf("vosk URL must be ws:// or wss://, got %q", u)
+		}
+	case "replay":
+		if c.ReplayFile == "" {
+			return errors.New("replay needs a file")
+		}
+		_, err := os.Stat(c.ReplayFile)
+		return err
+	default:
+		return errors.New("not supported")
+	}
+	return nil
+}
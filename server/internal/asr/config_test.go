@@ -0,0 +1,35 @@
+package asr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want string // substring of the error, "" for none
+	}{
+		{Config{}, ""},
+		{Config{Provider: " none "}, ""},
+		{Config{Provider: "stub"}, ""},
+		{Config{Provider: "stub,vosk", Vosk: VoskConfig{URL: "wss://asr.local"}}, ""},
+		{Config{Provider: "carrier-pigeon"}, "carrier-pigeon: not supported"},
+		{Config{Provider: "gemini"}, "API key"},
+		{Config{Provider: "whisper", Whisper: WhisperConfig{Endpoint: Endpoint{BaseURL: "http://localhost:8081/v1"}}}, ""},
+		{Config{Provider: "vosk", Vosk: VoskConfig{URL: "http://localhost:2700"}}, "ws://"},
+		{Config{Provider: "replay"}, "needs a file"},
+		{Config{Provider: "replay", ReplayFile: "testdata/missing.jsonl"}, "missing.jsonl"},
+		{Config{Provider: "stub", Stub: StubConfig{Pace: "fast"}}, "pace"},
+		// Every broken provider in a chain is reported.
+		{Config{Provider: "gemini,replay"}, "replay needs a file"},
+	} {
+		err := tc.cfg.Validate()
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%+v: %v", tc.cfg, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%+v: got %v, want an error containing %q", tc.cfg, err, tc.want)
+		}
+	}
+}
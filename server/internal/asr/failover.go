@@ -0,0 +1,500 @@
+package asr
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"cluely/server/internal/obs"
+)
+
+// failoverConfig tunes a failover chain. Zero fields take the defaults.
+type failoverConfig struct {
+	// Cooldown is how long a provider is skipped after a failure. It doubles
+	// with each consecutive failure up to MaxCooldown.
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+	// Replay caps the audio kept for retrying the current utterance.
+	Replay time.Duration
+	// Pending is how long flushed audio waits for its final before it is no
+	// longer replayed; an utterance with no words never gets one.
+	Pending time.Duration
+	Logger  *slog.Logger
+}
+
+func (c failoverConfig) withDefaults() failoverConfig {
+	if c.Cooldown <= 0 {
+		c.Cooldown = 5 * time.Second
+	}
+	if c.MaxCooldown < c.Cooldown {
+		c.MaxCooldown = 2 * time.Minute
+	}
+	if c.Replay <= 0 {
+		c.Replay = 30 * time.Second
+	}
+	if c.Pending <= 0 {
+		c.Pending = 30 * time.Second
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	return c
+}
+
+// ProviderHealth is a snapshot of one provider in a failover chain.
+type ProviderHealth struct {
+	Name      string
+	Active    bool
+	Finals    int64
+	Errors    int64
+	ErrorRate float64       // moving average over recent outcomes, 0..1
+	Latency   time.Duration // moving average from end of audio to final
+	Until     time.Time     // skipped until then after a failure
+}
+
+// healthAlpha weights the newest outcome in the moving averages.
+const healthAlpha = 0.2
+
+type provider struct {
+	name   string
+	client Client
+	health ProviderHealth
+	fails  int // consecutive
+}
+
+// record folds one outcome into the provider's health.
+func (p *provider) record(err error, latency time.Duration, cfg failoverConfig) {
+	h := &p.health
+	if err == nil {
+		h.Finals++
+		h.ErrorRate *= 1 - healthAlpha
+		if h.Latency == 0 {
+			h.Latency = latency
+		} else {
+			h.Latency += time.Duration(healthAlpha * float64(latency-h.Latency))
+		}
+		p.fails = 0
+		return
+	}
+	h.Errors++
+	h.ErrorRate = h.ErrorRate*(1-healthAlpha) + healthAlpha
+	p.fails++
+	cool := cfg.Cooldown << (p.fails - 1)
+	if cool > cfg.MaxCooldown || cool <= 0 {
+		cool = cfg.MaxCooldown
+	}
+	h.Until = time.Now().Add(cool)
+}
+
+func (p *provider) healthy(now time.Time) bool { return !now.Before(p.health.Until) }
+
+// replaySegment is audio written to the active provider, ended by a Flush
+// when flushed is set.
+type replaySegment struct {
+	audio   []byte
+	flushed bool
+	at      time.Time // when the segment last grew or was flushed
+}
+
+type tagged struct {
+	idx int
+	ev  Event
+}
+
+// failoverClient feeds the first healthy provider in priority order and
+// keeps the audio of the utterance in flight. When the active provider
+// reports an error the next healthy one takes over and is given that audio
+// again, so the utterance is not lost. A failed provider is skipped for a
+// growing cooldown; the chain returns to a higher-priority provider between
+// utterances once its cooldown ends.
+type failoverClient struct {
+	cfg       failoverConfig
+	log       *slog.Logger
+	providers []*provider
+	events    chan Event
+	merged    chan tagged
+	pumps     sync.WaitGroup
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	active   int // -1 when every provider is cooling down
+	segments []replaySegment
+	buffered int
+	dropped  int64
+	closed   bool
+	// While kept audio is replayed into a new provider, audio written
+	// meanwhile waits in backlog so it reaches the provider after it.
+	replaying  bool
+	backlog    []replaySegment
+	backlogN   int
+	discarding bool // Discard was called during the replay
+}
+
+func newFailoverFromConfig(cfg Config) (Client, error) {
+	var names []string
+	var clients []Client
+	var errs []error
+	for _, name := range strings.Split(cfg.Provider, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		one := cfg
+		one.Provider = name
+		c, err := New(one)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		case c == nil:
+			continue
+		}
+		names, clients = append(names, name), append(clients, c)
+	}
+	if len(clients) == 0 {
+		if len(errs) == 0 {
+			return nil, nil
+		}
+		return nil, errors.Join(errs...)
+	}
+	log := cfg.logger()
+	for _, err := range errs {
+		log.Warn("skipping asr provider", "err", err)
+	}
+	return newFailoverClient(failoverConfig{Replay: cfg.Chunk.MaxBuffer, Logger: log}, names, clients), nil
+}
+
+func newFailoverClient(cfg failoverConfig, names []string, clients []Client) *failoverClient {
+	cfg = cfg.withDefaults()
+	c := &failoverClient{
+		cfg:    cfg,
+		log:    cfg.Logger.With("asr", "failover"),
+		events: make(chan Event, 16),
+		merged: make(chan tagged, 16),
+		done:   make(chan struct{}),
+	}
+	for i, cl := range clients {
+		c.providers = append(c.providers, &provider{name: names[i], client: cl, health: ProviderHealth{Name: names[i]}})
+		c.pumps.Add(1)
+		go c.pump(i, cl)
+	}
+	c.providers[0].health.Active = true
+	go func() {
+		c.pumps.Wait()
+		close(c.merged)
+	}()
+	go c.run()
+	return c
+}
+
+func (c *failoverClient) pump(idx int, cl Client) {
+	defer c.pumps.Done()
+	for ev := range cl.Events() {
+		c.merged <- tagged{idx, ev}
+	}
+}
+
+// WritePCM sends data to the active provider and keeps it for replay.
+// Between utterances it first moves back to the best healthy provider.
+func (c *failoverClient) WritePCM(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.expireLocked()
+	if len(c.segments) == 0 && !c.replaying {
+		c.promoteLocked()
+	}
+	if c.active < 0 || c.replaying && c.backlogN+len(data) > pcmBytes(c.cfg.Replay) {
+		c.dropped++
+		obs.IncPCMFrameDrop()
+		return false
+	}
+	c.keepLocked(data)
+	if c.replaying {
+		c.backlog = appendSegment(c.backlog, data, false)
+		c.backlogN += len(data)
+		return true
+	}
+	return c.providers[c.active].client.WritePCM(data)
+}
+
+// appendSegment adds data to the open segment of segs, or ends it when
+// flush is set.
+func appendSegment(segs []replaySegment, data []byte, flush bool) []replaySegment {
+	if n := len(segs); n == 0 || segs[n-1].flushed {
+		segs = append(segs, replaySegment{})
+	}
+	last := &segs[len(segs)-1]
+	last.audio = append(last.audio, data...)
+	last.flushed = flush
+	last.at = time.Now()
+	return segs
+}
+
+// keepLocked appends data to the open segment, trimming the oldest audio
+// beyond the replay cap. Caller holds c.mu.
+func (c *failoverClient) keepLocked(data []byte) {
+	c.segments = appendSegment(c.segments, data, false)
+	c.buffered += len(data)
+	for limit := pcmBytes(c.cfg.Replay); c.buffered > limit && len(c.segments) > 0; {
+		first := &c.segments[0]
+		cut := c.buffered - limit
+		if cut >= len(first.audio) && len(c.segments) > 1 {
+			c.buffered -= len(first.audio)
+			c.segments = c.segments[1:]
+			continue
+		}
+		if cut > len(first.audio) {
+			cut = len(first.audio)
+		}
+		first.audio = first.audio[cut&^1:]
+		c.buffered -= cut &^ 1
+		break
+	}
+}
+
+// expireLocked forgets flushed utterances whose final is overdue. Caller
+// holds c.mu.
+func (c *failoverClient) expireLocked() {
+	for len(c.segments) > 0 && c.segments[0].flushed && time.Since(c.segments[0].at) > c.cfg.Pending {
+		c.buffered -= len(c.segments[0].audio)
+		c.segments = c.segments[1:]
+	}
+}
+
+// promoteLocked switches to the first healthy provider if it outranks the
+// active one. Only called with no utterance in flight. Caller holds c.mu.
+func (c *failoverClient) promoteLocked() {
+	now := time.Now()
+	for i, p := range c.providers {
+		if i == c.active {
+			return
+		}
+		if p.healthy(now) {
+			c.switchLocked(i)
+			c.log.Info("back to provider", "provider", p.name)
+			return
+		}
+	}
+}
+
+func (c *failoverClient) switchLocked(idx int) {
+	if c.active >= 0 {
+		c.providers[c.active].health.Active = false
+	}
+	c.active = idx
+	if idx >= 0 {
+		c.providers[idx].health.Active = true
+	}
+}
+
+func (c *failoverClient) Events() <-chan Event { return c.events }
+
+func (c *failoverClient) Dropped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.dropped
+	for _, p := range c.providers {
+		n += p.client.Dropped()
+	}
+	return n
+}
+
+// Flush ends the utterance on the active provider. The audio is kept until
+// its final arrives.
+func (c *failoverClient) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.active < 0 {
+		return
+	}
+	if n := len(c.segments); n > 0 && !c.segments[n-1].flushed {
+		c.segments[n-1].flushed = true
+		c.segments[n-1].at = time.Now()
+	}
+	if c.replaying {
+		c.backlog = appendSegment(c.backlog, nil, true)
+		return
+	}
+	c.providers[c.active].client.Flush()
+}
+
+// Discard drops the utterance in progress on the active provider and the
+// audio kept for it.
+func (c *failoverClient) Discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.active < 0 {
+		return
+	}
+	c.segments, c.buffered = nil, 0
+	if c.replaying {
+		c.backlog, c.backlogN, c.discarding = nil, 0, true
+		return
+	}
+	c.providers[c.active].client.Discard()
+}
+
+// Health reports each provider in priority order.
+func (c *failoverClient) Health() []ProviderHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]ProviderHealth, len(c.providers))
+	for i, p := range c.providers {
+		out[i] = p.health
+	}
+	return out
+}
+
+// Close closes every provider and then Events.
+func (c *failoverClient) Close() {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		for _, p := range c.providers {
+			p.client.Close()
+		}
+		<-c.done
+		close(c.events)
+	})
+}
+
+func (c *failoverClient) run() {
+	defer close(c.done)
+	for t := range c.merged {
+		c.mu.Lock()
+		if t.idx != c.active {
+			// A provider we left may still be finishing; its output is stale.
+			c.mu.Unlock()
+			continue
+		}
+		var (
+			out    []Event
+			failed Client
+			next   Client
+			replay []replaySegment
+		)
+		switch t.ev.Type {
+		case "error":
+			failed = c.providers[t.idx].client
+			out, next, replay = c.failLocked(t.ev.Err)
+		case "final":
+			c.providers[t.idx].record(nil, c.takeUtteranceLocked(), c.cfg)
+			out = []Event{t.ev}
+		default:
+			out = []Event{t.ev}
+		}
+		c.mu.Unlock()
+		for _, ev := range out {
+			c.emit(ev)
+		}
+		if failed != nil {
+			// Drop the failed provider's utterance so leftover audio does not
+			// open its next one, without sending it upstream again.
+			failed.Discard()
+		}
+		if next != nil {
+			c.replay(next, replay)
+		}
+	}
+}
+
+// replay hands kept audio to cl without holding c.mu, then the audio
+// written meanwhile, until none is left.
+func (c *failoverClient) replay(cl Client, segs []replaySegment) {
+	for {
+		for _, seg := range segs {
+			for off := 0; off < len(seg.audio); off += replayFrame {
+				end := off + replayFrame
+				if end > len(seg.audio) {
+					end = len(seg.audio)
+				}
+				cl.WritePCM(seg.audio[off:end])
+			}
+			if seg.flushed {
+				cl.Flush()
+			}
+		}
+		c.mu.Lock()
+		discard := c.discarding
+		segs = c.backlog
+		c.backlog, c.backlogN, c.discarding = nil, 0, false
+		if len(segs) == 0 && !discard {
+			c.replaying = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+		if discard {
+			cl.Discard()
+		}
+	}
+}
+
+// takeUtteranceLocked drops the audio a final answered and returns how long
+// the final took after that audio ended. Caller holds c.mu.
+func (c *failoverClient) takeUtteranceLocked() time.Duration {
+	if len(c.segments) == 0 {
+		return 0
+	}
+	seg := c.segments[0]
+	if seg.flushed {
+		c.segments = c.segments[1:]
+		c.buffered -= len(seg.audio)
+	} else {
+		// Finalized on the provider's own endpointing.
+		c.segments, c.buffered = nil, 0
+	}
+	return time.Since(seg.at)
+}
+
+// failLocked marks the active provider failed and switches to the next
+// healthy one, returning it with the kept audio to replay once c.mu is
+// released. Caller holds c.mu.
+func (c *failoverClient) failLocked(err error) ([]Event, Client, []replaySegment) {
+	failed := c.providers[c.active]
+	failed.record(err, 0, c.cfg)
+	obs.IncASRFailover()
+
+	now := time.Now()
+	next := -1
+	for i, p := range c.providers {
+		if p != failed && p.healthy(now) {
+			next = i
+			break
+		}
+	}
+	c.switchLocked(next)
+	if next < 0 {
+		c.log.Warn("provider failed; no provider left", "provider", failed.name, "err", err)
+		c.segments, c.buffered = nil, 0
+		return []Event{{Type: "unavailable", Err: err}}, nil, nil
+	}
+	p := c.providers[next]
+	c.log.Warn("provider failed; switching", "provider", failed.name, "err", err, "to", p.name, "replay_bytes", c.buffered)
+	c.replaying = true
+	replay := append([]replaySegment(nil), c.segments...)
+	return []Event{{Type: "degraded", Text: p.name, Err: err}}, p.client, replay
+}
+
+// replayFrame is the write size used when handing kept audio to the next
+// provider: 100ms, so queue-based providers see ordinary frames.
+const replayFrame = pcmBytesPerSecond / 10
+
+func (c *failoverClient) emit(evt Event) {
+	select {
+	case c.events <- evt:
+	default:
+		c.log.Warn("dropping event, channel full", "event", evt.Type)
+	}
+}
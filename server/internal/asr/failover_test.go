@@ -0,0 +1,188 @@
+package asr
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider records what it is fed; the test drives its events.
+type fakeProvider struct {
+	mu       sync.Mutex
+	audio    []byte
+	flushes  int
+	discards int
+	events   chan Event
+	once     sync.Once
+	gate     chan struct{} // when set, WritePCM waits for it
+}
+
+func newFakeProvider() *fakeProvider { return &fakeProvider{events: make(chan Event, 16)} }
+
+func (f *fakeProvider) WritePCM(b []byte) bool {
+	if f.gate != nil {
+		<-f.gate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audio = append(f.audio, b...)
+	return true
+}
+
+func (f *fakeProvider) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushes++
+}
+
+func (f *fakeProvider) Discard() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.discards++
+}
+
+func (f *fakeProvider) fed() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.audio), f.flushes
+}
+
+func (f *fakeProvider) Events() <-chan Event { return f.events }
+func (f *fakeProvider) Dropped() int64       { return 0 }
+func (f *fakeProvider) Close()               { f.once.Do(func() { close(f.events) }) }
+
+func newTestChain(cfg failoverConfig, providers ...*fakeProvider) *failoverClient {
+	names := []string{"primary", "backup", "last"}[:len(providers)]
+	clients := make([]Client, len(providers))
+	for i, p := range providers {
+		clients[i] = p
+	}
+	return newFailoverClient(cfg, names, clients)
+}
+
+func TestFailoverReplaysUtteranceOnNextProvider(t *testing.T) {
+	primary, backup := newFakeProvider(), newFakeProvider()
+	c := newTestChain(failoverConfig{}, primary, backup)
+	defer c.Close()
+
+	c.WritePCM(make([]byte, 3200))
+	c.WritePCM(make([]byte, 3200))
+	c.Flush()
+	primary.events <- Event{Type: "error", Err: errors.New("http 429")}
+
+	ev := nextEvent(t, c.Events())
+	if ev.Type != "degraded" || ev.Text != "backup" {
+		t.Fatalf("expected degraded to backup, got %+v", ev)
+	}
+	if n, flushes := backup.fed(); n != 6400 || flushes != 1 {
+		t.Fatalf("backup got %d bytes and %d flushes, want the 6400-byte utterance flushed once", n, flushes)
+	}
+
+	// The failed provider's late output is ignored; the backup's is relayed.
+	primary.events <- Event{Type: "final", Text: "stale", IsFinal: true}
+	backup.events <- Event{Type: "final", Text: "what's the budget", IsFinal: true}
+	if ev := nextEvent(t, c.Events()); ev.Text != "what's the budget" {
+		t.Fatalf("expected the backup's final, got %+v", ev)
+	}
+
+	h := c.Health()
+	if h[0].Errors != 1 || h[0].Active || !h[1].Active || h[1].Finals != 1 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+	primary.mu.Lock()
+	defer primary.mu.Unlock()
+	if primary.flushes != 1 || primary.discards != 1 {
+		t.Fatalf("failed provider flushed %d times and discarded %d, want its utterance discarded, not flushed again",
+			primary.flushes, primary.discards)
+	}
+}
+
+func TestFailoverWritesDuringReplayFollowIt(t *testing.T) {
+	primary, backup := newFakeProvider(), newFakeProvider()
+	backup.gate = make(chan struct{})
+	c := newTestChain(failoverConfig{}, primary, backup)
+	defer c.Close()
+
+	c.WritePCM(bytes.Repeat([]byte{1}, 640))
+	primary.events <- Event{Type: "error", Err: errors.New("http 503")}
+	nextEvent(t, c.Events()) // degraded; the replay is now stuck on the backup
+
+	wrote := make(chan bool)
+	go func() {
+		ok := c.WritePCM(bytes.Repeat([]byte{2}, 640))
+		c.Flush()
+		c.Health()
+		wrote <- ok
+	}()
+	select {
+	case ok := <-wrote:
+		if !ok {
+			t.Fatal("audio written during the replay was refused")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the chain stayed locked while replaying into a slow provider")
+	}
+	close(backup.gate)
+
+	deadline := time.Now().Add(time.Second)
+	for n, flushes := backup.fed(); n != 1280 || flushes != 1; n, flushes = backup.fed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("backup got %d bytes and %d flushes, want 1280 and 1", n, flushes)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	backup.mu.Lock()
+	defer backup.mu.Unlock()
+	if backup.audio[0] != 1 || backup.audio[640] != 2 {
+		t.Fatal("audio written during the replay reached the backup before the replayed audio")
+	}
+}
+
+func TestFailoverReportsUnavailableWhenAllFail(t *testing.T) {
+	primary, backup := newFakeProvider(), newFakeProvider()
+	c := newTestChain(failoverConfig{Cooldown: time.Minute}, primary, backup)
+	defer c.Close()
+
+	c.WritePCM(make([]byte, 640))
+	primary.events <- Event{Type: "error", Err: errors.New("down")}
+	if ev := nextEvent(t, c.Events()); ev.Type != "degraded" {
+		t.Fatalf("expected degraded, got %+v", ev)
+	}
+	backup.events <- Event{Type: "error", Err: errors.New("down too")}
+	if ev := nextEvent(t, c.Events()); ev.Type != "unavailable" {
+		t.Fatalf("expected unavailable, got %+v", ev)
+	}
+	if c.WritePCM(make([]byte, 640)) {
+		t.Fatal("audio accepted with no provider available")
+	}
+	if c.Dropped() != 1 {
+		t.Fatalf("dropped = %d, want 1", c.Dropped())
+	}
+}
+
+func TestFailoverReturnsToPrimaryBetweenUtterances(t *testing.T) {
+	primary, backup := newFakeProvider(), newFakeProvider()
+	c := newTestChain(failoverConfig{Cooldown: 20 * time.Millisecond}, primary, backup)
+	defer c.Close()
+
+	c.WritePCM(make([]byte, 640))
+	primary.events <- Event{Type: "error", Err: errors.New("timeout")}
+	nextEvent(t, c.Events()) // degraded
+
+	time.Sleep(30 * time.Millisecond)
+	// Mid-utterance the chain stays on the backup.
+	c.WritePCM(make([]byte, 640))
+	if n, _ := backup.fed(); n != 1280 {
+		t.Fatalf("backup got %d bytes, want 1280", n)
+	}
+	backup.events <- Event{Type: "final", Text: "done", IsFinal: true}
+	nextEvent(t, c.Events())
+
+	before, _ := primary.fed()
+	c.WritePCM(make([]byte, 640))
+	if after, _ := primary.fed(); after != before+640 {
+		t.Fatalf("next utterance went to the backup, not the recovered primary")
+	}
+}
@@ -0,0 +1,295 @@
+package asr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Encoding is a PCM sample encoding.
+type Encoding string
+
+const (
+	S16LE Encoding = "s16le" // signed 16-bit little-endian
+	F32LE Encoding = "f32le" // 32-bit float little-endian, nominally [-1, 1]
+	MuLaw Encoding = "mulaw" // G.711 μ-law, 8 bits per sample
+
+	// Compressed encodings; see opusInput.
+	Opus    Encoding = "opus"     // length-prefixed Opus packets
+	OggOpus Encoding = "ogg_opus" // Ogg/Opus stream
+)
+
+// Compressed reports whether e is a packetized codec rather than raw PCM.
+func (e Encoding) Compressed() bool { return e == Opus || e == OggOpus }
+
+func (e Encoding) bytesPerSample() int {
+	switch e {
+	case S16LE:
+		return 2
+	case F32LE:
+		return 4
+	case MuLaw:
+		return 1
+	}
+	return 0
+}
+
+// Format describes interleaved PCM audio.
+type Format struct {
+	SampleRate int
+	Channels   int
+	Encoding   Encoding
+}
+
+// PCM16k is what the VAD and every provider consume: 16 kHz mono s16le.
+var PCM16k = Format{SampleRate: 16000, Channels: 1, Encoding: S16LE}
+
+func (f Format) String() string {
+	return fmt.Sprintf("%s %dHz %dch", f.Encoding, f.SampleRate, f.Channels)
+}
+
+// MimeType is the audio/pcm MIME type vendors expect for f.
+func (f Format) MimeType() string {
+	return fmt.Sprintf("audio/pcm;rate=%d", f.SampleRate)
+}
+
+// ParseFormat validates a client-declared format. Zero values default to
+// PCM16k's, so a client only has to name what differs.
+func ParseFormat(sampleRate, channels int, encoding string) (Format, error) {
+	f := PCM16k
+	if sampleRate != 0 {
+		f.SampleRate = sampleRate
+	}
+	if channels != 0 {
+		f.Channels = channels
+	}
+	if enc := strings.ToLower(strings.TrimSpace(encoding)); enc != "" {
+		switch enc {
+		case "s16le", "pcm_s16le":
+			f.Encoding = S16LE
+		case "f32le", "pcm_f32le", "float32":
+			f.Encoding = F32LE
+		case "mulaw", "ulaw", "μ-law", "pcm_mulaw":
+			f.Encoding = MuLaw
+		case "opus":
+			f.Encoding = Opus
+		case "ogg_opus", "ogg", "audio/ogg":
+			f.Encoding = OggOpus
+		default:
+			return Format{}, fmt.Errorf("unsupported audio encoding %q (want s16le, f32le, mulaw, opus or ogg_opus)", encoding)
+		}
+	}
+	if f.Encoding.Compressed() {
+		if !opusAvailable() {
+			return Format{}, ErrOpusUnavailable
+		}
+		// Opus carries its own rate; decoding always yields 16 kHz.
+		f.SampleRate = PCM16k.SampleRate
+		if f.Channels > 2 {
+			return Format{}, fmt.Errorf("unsupported channel count %d for opus (want 1 or 2)", f.Channels)
+		}
+	}
+	if f.SampleRate < 8000 || f.SampleRate > 192000 {
+		return Format{}, fmt.Errorf("unsupported sample rate %d (want 8000-192000)", f.SampleRate)
+	}
+	if f.Channels < 1 || f.Channels > 8 {
+		return Format{}, fmt.Errorf("unsupported channel count %d (want 1-8)", f.Channels)
+	}
+	return f, nil
+}
+
+// Input turns binary frames in a client's declared format into PCM16k.
+type Input interface {
+	Decode(data []byte) ([]byte, error)
+}
+
+// NewInput returns the decoder for f, or nil when f is already PCM16k.
+func NewInput(f Format) (Input, error) {
+	if f == PCM16k {
+		return nil, nil
+	}
+	if f.Encoding.Compressed() {
+		in, err := newOpusInput(f)
+		if err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	c, err := NewConverter(f, PCM16k)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Converter normalizes a stream of PCM in one format to another: it
+// decodes, downmixes to mono and resamples. Frames may split samples
+// anywhere; the remainder is carried to the next call. A Converter is not
+// safe for concurrent use.
+type Converter struct {
+	in, out Format
+	frame   int // bytes per interleaved input frame
+	rest    []byte
+
+	// Resampler state: position of the next output sample in input
+	// samples, relative to prev, which is the last input sample seen.
+	step    float64
+	pos     float64
+	prev    float32
+	hasPrev bool
+
+	// Box low-pass ahead of downsampling, so content above the new Nyquist
+	// frequency is smeared rather than folded back as aliasing.
+	box  []float32
+	boxN int
+	boxI int
+	sum  float32
+}
+
+// NewConverter returns a converter from in to out. out must be mono.
+func NewConverter(in, out Format) (*Converter, error) {
+	if in.Encoding.bytesPerSample() == 0 || out.Encoding.bytesPerSample() == 0 {
+		return nil, fmt.Errorf("unsupported conversion %s -> %s", in, out)
+	}
+	if out.Channels != 1 {
+		return nil, fmt.Errorf("converter output must be mono, got %d channels", out.Channels)
+	}
+	c := &Converter{
+		in:    in,
+		out:   out,
+		frame: in.Encoding.bytesPerSample() * in.Channels,
+		step:  float64(in.SampleRate) / float64(out.SampleRate),
+	}
+	if n := int(math.Round(c.step)); n > 1 {
+		c.boxN = n
+		c.box = make([]float32, n)
+	}
+	return c, nil
+}
+
+// Convert returns data in the output format.
+func (c *Converter) Convert(data []byte) []byte {
+	buf := append(c.rest, data...)
+	n := len(buf) / c.frame
+	mono := make([]float32, n)
+	bps := c.in.Encoding.bytesPerSample()
+	for i := 0; i < n; i++ {
+		var sum float32
+		for ch := 0; ch < c.in.Channels; ch++ {
+			off := i*c.frame + ch*bps
+			sum += decodeSample(c.in.Encoding, buf[off:off+bps])
+		}
+		mono[i] = sum / float32(c.in.Channels)
+	}
+	c.rest = append(c.rest[:0], buf[n*c.frame:]...)
+
+	if c.boxN > 1 {
+		for i, v := range mono {
+			c.sum += v - c.box[c.boxI]
+			c.box[c.boxI] = v
+			c.boxI = (c.boxI + 1) % c.boxN
+			mono[i] = c.sum / float32(c.boxN)
+		}
+	}
+	return encodeSamples(c.out.Encoding, c.resample(mono))
+}
+
+// Decode implements Input.
+func (c *Converter) Decode(data []byte) ([]byte, error) { return c.Convert(data), nil }
+
+// resample linearly interpolates x at out.SampleRate, carrying the last
+// sample and the fractional position across calls.
+func (c *Converter) resample(x []float32) []float32 {
+	if c.in.SampleRate == c.out.SampleRate {
+		return x
+	}
+	if len(x) == 0 {
+		return nil
+	}
+	if c.hasPrev {
+		x = append([]float32{c.prev}, x...)
+	} else {
+		c.hasPrev = true
+	}
+	var y []float32
+	for {
+		i := int(c.pos)
+		if i+1 >= len(x) {
+			break
+		}
+		f := float32(c.pos - float64(i))
+		y = append(y, x[i]*(1-f)+x[i+1]*f)
+		c.pos += c.step
+	}
+	c.prev = x[len(x)-1]
+	c.pos -= float64(len(x) - 1)
+	return y
+}
+
+func decodeSample(enc Encoding, b []byte) float32 {
+	switch enc {
+	case S16LE:
+		return float32(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case F32LE:
+		v := math.Float32frombits(binary.LittleEndian.Uint32(b))
+		if v != v { // NaN
+			return 0
+		}
+		return float32(math.Max(-1, math.Min(1, float64(v))))
+	case MuLaw:
+		return float32(mulawDecode(b[0])) / 32768
+	}
+	return 0
+}
+
+func encodeSamples(enc Encoding, x []float32) []byte {
+	out := make([]byte, len(x)*enc.bytesPerSample())
+	for i, v := range x {
+		if v > 1 {
+			v = 1
+		} else if v < -1 {
+			v = -1
+		}
+		switch enc {
+		case S16LE:
+			binary.LittleEndian.PutUint16(out[2*i:], uint16(int16(math.Round(float64(v)*32767))))
+		case F32LE:
+			binary.LittleEndian.PutUint32(out[4*i:], math.Float32bits(v))
+		case MuLaw:
+			out[i] = mulawEncode(int16(math.Round(float64(v) * 32767)))
+		}
+	}
+	return out
+}
+
+// mulawDecode expands a G.711 μ-law byte to a 16-bit sample.
+func mulawDecode(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0f) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+// mulawEncode compresses a 16-bit sample to G.711 μ-law.
+func mulawEncode(s int16) byte {
+	const bias, clip = 0x84, 32635
+	v := int(s)
+	sign := 0
+	if v < 0 {
+		v, sign = -v, 0x80
+	}
+	if v > clip {
+		v = clip
+	}
+	v += bias
+	exp := 7
+	for mask := 0x4000; v&mask == 0 && exp > 0; mask >>= 1 {
+		exp--
+	}
+	mant := (v >> (exp + 3)) & 0x0f
+	return ^byte(sign | exp<<4 | mant)
+}
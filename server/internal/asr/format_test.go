@@ -0,0 +1,87 @@
+package asr
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestParseFormatDefaultsAndRejects(t *testing.T) {
+	f, err := ParseFormat(0, 0, "")
+	if err != nil || f != PCM16k {
+		t.Fatalf("empty declaration should be PCM16k, got %v (%v)", f, err)
+	}
+	f, err = ParseFormat(48000, 2, "F32LE")
+	if err != nil || f != (Format{SampleRate: 48000, Channels: 2, Encoding: F32LE}) {
+		t.Fatalf("unexpected format %v (%v)", f, err)
+	}
+	for _, bad := range []struct {
+		rate, ch int
+		enc      string
+	}{{16000, 1, "opus"}, {4000, 1, ""}, {16000, 12, ""}, {-1, 1, ""}} {
+		if _, err := ParseFormat(bad.rate, bad.ch, bad.enc); err == nil {
+			t.Errorf("ParseFormat(%d, %d, %q) should fail", bad.rate, bad.ch, bad.enc)
+		}
+	}
+}
+
+func TestMuLawRoundTrip(t *testing.T) {
+	for _, v := range []int16{0, 1, -1, 100, -100, 1000, -1000, 8000, -8000, 32000, -32000} {
+		got := mulawDecode(mulawEncode(v))
+		// μ-law keeps roughly 4 significant bits.
+		tol := math.Max(8, math.Abs(float64(v))/16)
+		if math.Abs(float64(got-v)) > tol {
+			t.Errorf("mulaw(%d) round-tripped to %d", v, got)
+		}
+	}
+}
+
+func TestConverterDownmixesAndResamples(t *testing.T) {
+	in := Format{SampleRate: 48000, Channels: 2, Encoding: F32LE}
+	conv, err := NewConverter(in, PCM16k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One second of a 440 Hz sine at half scale, left and right in phase.
+	const frames = 48000
+	src := make([]byte, frames*8)
+	for i := 0; i < frames; i++ {
+		v := float32(0.5 * math.Sin(2*math.Pi*440*float64(i)/48000))
+		binary.LittleEndian.PutUint32(src[8*i:], math.Float32bits(v))
+		binary.LittleEndian.PutUint32(src[8*i+4:], math.Float32bits(v))
+	}
+	// Odd chunk sizes split frames and samples.
+	var out []byte
+	for len(src) > 0 {
+		n := 1237
+		if n > len(src) {
+			n = len(src)
+		}
+		out = append(out, conv.Convert(src[:n])...)
+		src = src[n:]
+	}
+	samples := len(out) / 2
+	if samples < 15990 || samples > 16000 {
+		t.Fatalf("expected ~16000 output samples, got %d", samples)
+	}
+	// A half-scale sine has RMS 0.5/sqrt(2) of full scale.
+	want := 0.5 / math.Sqrt2 * 32767
+	if got := rms(out); math.Abs(got-want) > want*0.05 {
+		t.Fatalf("rms %.0f, want about %.0f", got, want)
+	}
+}
+
+func TestConverterMuLaw8k(t *testing.T) {
+	conv, err := NewConverter(Format{SampleRate: 8000, Channels: 1, Encoding: MuLaw}, PCM16k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := make([]byte, 800) // 100ms
+	for i := range src {
+		src[i] = mulawEncode(int16(8000 * math.Sin(2*math.Pi*200*float64(i)/8000)))
+	}
+	out := conv.Convert(src)
+	if n := len(out) / 2; n < 1590 || n > 1600 {
+		t.Fatalf("expected ~1600 samples from 8 kHz upsampling, got %d", n)
+	}
+}
@@ -3,15 +3,19 @@ package asr
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
 )
 
 type geminiConfig struct {
@@ -19,17 +23,17 @@ type geminiConfig struct {
 	Model   string
 	BaseURL string
 	Timeout time.Duration
+	Chunk   ChunkConfig
+	Logger  *slog.Logger
 }
 
+// geminiClient transcribes through generateContent, one overlapping chunk
+// at a time; chunkedStream supplies the streaming behaviour.
 type geminiClient struct {
-	cfg       geminiConfig
-	http      *http.Client
-	events    chan Event
-	mu        sync.Mutex
-	buf       []byte
-	closeOnce sync.Once
-	closed    bool
-	wg        sync.WaitGroup
+	*chunkedStream
+	cfg     geminiConfig
+	http    *http.Client
+	breaker *rt.Breaker
 }
 
 func newGeminiClient(cfg geminiConfig) (Client, error) {
@@ -47,86 +51,28 @@ func newGeminiClient(cfg geminiConfig) (Client, error) {
 		cfg.Timeout = 12 * time.Second
 	}
 	client := &geminiClient{
-		cfg:    cfg,
-		http:   &http.Client{Timeout: cfg.Timeout},
-		events: make(chan Event, 4),
+		cfg:     cfg,
+		http:    trace.NewClient(cfg.Timeout),
+		breaker: rt.DefaultBreakers.Get("asr:gemini"),
 	}
+	client.chunkedStream = newChunkedStream("gemini", cfg.Chunk, cfg.Logger, client.transcribe)
 	return client, nil
 }
 
-func (c *geminiClient) WritePCM(data []byte) bool {
-	if len(data) == 0 {
-		return true
-	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.closed {
-		return false
-	}
-	c.buf = append(c.buf, data...)
-	return true
-}
-
-func (c *geminiClient) Events() <-chan Event {
-	return c.events
-}
-
-func (c *geminiClient) Dropped() int64 {
-	return 0
-}
-
-func (c *geminiClient) Flush() {
-	c.mu.Lock()
-	if c.closed || len(c.buf) == 0 {
-		c.mu.Unlock()
-		return
-	}
-	audio := make([]byte, len(c.buf))
-	copy(audio, c.buf)
-	c.buf = c.buf[:0]
-	c.mu.Unlock()
-
-	c.launchTranscription(audio, true)
-}
-
-func (c *geminiClient) Close() {
-	c.closeOnce.Do(func() {
-		c.mu.Lock()
-		c.closed = true
-		audio := make([]byte, len(c.buf))
-		copy(audio, c.buf)
-		c.buf = nil
-		c.mu.Unlock()
-		if len(audio) > 0 {
-			c.launchTranscription(audio, true)
-		}
-		c.wg.Wait()
-		close(c.events)
-	})
-}
-
-func (c *geminiClient) launchTranscription(audio []byte, final bool) {
-	if len(audio) == 0 {
-		return
+// transcribe skips the request while Gemini's breaker is open, so a chunk
+// fails at once instead of after the full timeout.
+func (c *geminiClient) transcribe(ctx context.Context, audio []byte, onPartial func(string)) (string, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return "", err
 	}
-	c.wg.Add(1)
-	go func(buf []byte, isFinal bool) {
-		defer c.wg.Done()
-		if err := c.streamTranscribe(buf, isFinal); err != nil {
-			log.Printf("[asr][gemini] transcribe error: %v", err)
-		}
-	}(audio, final)
+	text, err := c.streamTranscribe(ctx, audio, onPartial)
+	c.breaker.Record(err)
+	return text, err
 }
 
-func (c *geminiClient) emitEvent(evt Event) {
-	select {
-	case c.events <- evt:
-	default:
-		log.Printf("[asr][gemini] dropping %s event (channel full)", evt.Type)
-	}
-}
-
-func (c *geminiClient) streamTranscribe(audio []byte, expectFinal bool) error {
+// streamTranscribe sends one chunk and reports the transcript as it streams
+// back. It returns the chunk's final text.
+func (c *geminiClient) streamTranscribe(ctx context.Context, audio []byte, onPartial func(string)) (string, error) {
 	inline := base64.StdEncoding.EncodeToString(audio)
 
 	payload := geminiASRRequest{
@@ -135,7 +81,7 @@ func (c *geminiClient) streamTranscribe(audio []byte, expectFinal bool) error {
 				Role: "user",
 				Parts: []geminiASRPart{
 					{Text: "Transcribe the provided audio into clear English text. Return only the transcript."},
-					{InlineData: &geminiInlineData{MimeType: "audio/pcm;rate=16000", Data: inline}},
+					{InlineData: &geminiInlineData{MimeType: PCM16k.MimeType(), Data: inline}},
 				},
 			},
 		},
@@ -148,24 +94,36 @@ func (c *geminiClient) streamTranscribe(audio []byte, expectFinal bool) error {
 
 	var body bytes.Buffer
 	if err := json.NewEncoder(&body).Encode(payload); err != nil {
-		return fmt.Errorf("encode request: %w", err)
+		return "", fmt.Errorf("encode request: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?key=%s&alt=sse", c.cfg.BaseURL, c.cfg.Model, c.cfg.APIKey)
-	req, err := http.NewRequest(http.MethodPost, url, &body)
-	if err != nil {
-		return fmt.Errorf("build request: %w", err)
+	// The key goes in a header, not the URL that request errors quote.
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", c.cfg.BaseURL, c.cfg.Model)
+	// Retries share one Timeout: a chunk that arrives much later than its
+	// audio is no use as a live transcript.
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	retry := geminiRetry
+	retry.OnRetry = func(attempt int, err error) {
+		obs.IncRetryASR()
+		c.log.Warn("asr request failed, retrying", "attempt", attempt, "err", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.http.Do(req)
+	resp, err := retry.Do(ctx, c.http, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-goog-api-key", c.cfg.APIKey)
+		return req, nil
+	})
 	if err != nil {
-		return fmt.Errorf("execute request: %w", err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= http.StatusBadRequest {
-		return c.parseError(resp)
+		return "", c.parseError(resp)
 	}
 
 	scanner := bufio.NewScanner(resp.Body)
@@ -174,13 +132,14 @@ func (c *geminiClient) streamTranscribe(audio []byte, expectFinal bool) error {
 
 	var (
 		eventData     strings.Builder
+		acc           strings.Builder
 		lastText      string
-		emittedFinal  bool
+		finished      bool
 		streamClosing bool
 	)
 
 	flushEvent := func(data string) {
-		if data == "" {
+		if data == "" || finished {
 			return
 		}
 		if data == "[DONE]" {
@@ -189,30 +148,24 @@ func (c *geminiClient) streamTranscribe(audio []byte, expectFinal bool) error {
 		}
 		var chunk geminiASRResponse
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			log.Printf("[asr][gemini] failed to parse stream chunk: %v", err)
-			return
-		}
-		text := extractCandidateText(chunk.Candidates)
-		if text == "" {
+			c.log.Warn("bad stream chunk", "err", err)
 			return
 		}
-
-		isChunkFinal := candidateFinished(chunk.Candidates)
-
-		if isChunkFinal && expectFinal {
-			if text != lastText {
-				c.emitEvent(Event{Type: "partial", Text: text, IsFinal: false})
-				lastText = text
+		// Each event carries the next slice of the transcript.
+		for _, c := range chunk.Candidates {
+			for _, part := range c.Content.Parts {
+				acc.WriteString(part.Text)
 			}
-			c.emitEvent(Event{Type: "final", Text: text, IsFinal: true})
-			emittedFinal = true
+		}
+		text := strings.TrimSpace(acc.String())
+		if text == "" {
 			return
 		}
-
 		if text != lastText {
-			c.emitEvent(Event{Type: "partial", Text: text, IsFinal: false})
+			onPartial(text)
 			lastText = text
 		}
+		finished = candidateFinished(chunk.Candidates)
 	}
 
 	for scanner.Scan() {
@@ -234,23 +187,28 @@ func (c *geminiClient) streamTranscribe(audio []byte, expectFinal bool) error {
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("read stream: %w", err)
+		return "", fmt.Errorf("read stream: %w", err)
 	}
 
 	if eventData.Len() > 0 {
 		flushEvent(strings.TrimSpace(eventData.String()))
 	}
+	// A silent chunk legitimately yields no text.
+	return lastText, nil
+}
 
-	if expectFinal && !emittedFinal && lastText != "" {
-		c.emitEvent(Event{Type: "final", Text: lastText, IsFinal: true})
-		emittedFinal = true
-	}
+var geminiRetry = rt.RetryPolicy{Retryable: geminiRetryable}
 
-	if lastText == "" {
-		return fmt.Errorf("no transcription returned")
+// geminiRetryable goes by error.status when Gemini sends one and falls
+// back to the HTTP status code otherwise.
+func geminiRetryable(status int, body []byte) bool {
+	var apiErr geminiError
+	if json.Unmarshal(body, &apiErr) == nil {
+		if retry, known := rt.RetryableRPCStatus(apiErr.Error.Status); known {
+			return retry
+		}
 	}
-
-	return nil
+	return rt.RetryableStatus(status)
 }
 
 func (c *geminiClient) parseError(resp *http.Response) error {
@@ -265,17 +223,6 @@ func (c *geminiClient) parseError(resp *http.Response) error {
 	return fmt.Errorf("gemini http %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
 }
 
-func extractCandidateText(candidates []geminiCandidate) string {
-	for _, c := range candidates {
-		for _, part := range c.Content.Parts {
-			if t := strings.TrimSpace(part.Text); t != "" {
-				return t
-			}
-		}
-	}
-	return ""
-}
-
 func candidateFinished(candidates []geminiCandidate) bool {
 	for _, c := range candidates {
 		if reason := strings.TrimSpace(strings.ToUpper(c.FinishReason)); reason != "" && reason != "INCOMPLETE" {
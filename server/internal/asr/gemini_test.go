@@ -31,6 +31,9 @@ func TestGeminiClientFlushEmitsFinalEvent(t *testing.T) {
 		if q := r.URL.Query().Get("alt"); q != "sse" {
 			t.Fatalf("expected alt=sse, got %q", q)
 		}
+		if r.Header.Get("x-goog-api-key") != "test-key" || r.URL.Query().Has("key") {
+			t.Fatalf("key not sent in the header only: %q %s", r.Header.Get("x-goog-api-key"), r.URL.RawQuery)
+		}
 		var payload requestPayload
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			t.Fatalf("decode request: %v", err)
@@ -48,7 +51,7 @@ func TestGeminiClientFlushEmitsFinalEvent(t *testing.T) {
 		if flusher != nil {
 			flusher.Flush()
 		}
-		_, _ = fmt.Fprintf(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"hello world\"}]},\"finishReason\":\"STOP\"}]}\n\n")
+		_, _ = fmt.Fprintf(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\" world\"}]},\"finishReason\":\"STOP\"}]}\n\n")
 		if flusher != nil {
 			flusher.Flush()
 		}
@@ -59,11 +62,7 @@ func TestGeminiClientFlushEmitsFinalEvent(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	t.Setenv("GEMINI_API_KEY", "test-key")
-	t.Setenv("GEMINI_ASR_MODEL", "test-model")
-	t.Setenv("GEMINI_ASR_BASE_URL", srv.URL)
-
-	client, err := New("gemini")
+	client, err := New(Config{Provider: "gemini", Gemini: Endpoint{APIKey: "test-key", Model: "test-model", BaseURL: srv.URL}})
 	if err != nil {
 		t.Fatalf("New gemini client failed: %v", err)
 	}
@@ -0,0 +1,157 @@
+package asr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// oggReader demultiplexes an Ogg byte stream into packets. Input may be
+// split anywhere; incomplete pages are held until the rest arrives. Only
+// the first logical stream is followed, which is all a single microphone
+// produces. A corrupt page is dropped and reading resumes at the next
+// capture pattern, so the buffer never holds more than one page plus the
+// latest write.
+type oggReader struct {
+	buf     []byte
+	partial []byte // packet continued on the next page
+	serial  uint32
+	locked  bool
+	lost    bool // a page was dropped; a continued packet is incomplete
+}
+
+var errOggSync = errors.New("ogg: lost page sync")
+
+const oggHeaderLen = 27
+
+var oggCapture = []byte("OggS")
+
+// Write appends data and returns every packet completed by it. The error
+// reports the first corrupt page skipped on the way.
+func (r *oggReader) Write(data []byte) ([][]byte, error) {
+	r.buf = append(r.buf, data...)
+	var (
+		packets [][]byte
+		err     error
+	)
+	drop := func(e error) {
+		if err == nil {
+			err = e
+		}
+		r.partial = r.partial[:0]
+		r.lost = true
+		r.resync(1)
+	}
+	for {
+		if !bytes.HasPrefix(r.buf, oggCapture) {
+			if len(r.buf) >= len(oggCapture) {
+				drop(errOggSync)
+				continue
+			}
+			n := len(r.buf)
+			if r.resync(0); len(r.buf) < n && err == nil {
+				err = errOggSync
+			}
+			break
+		}
+		if len(r.buf) < oggHeaderLen {
+			break
+		}
+		if r.buf[4] != 0 {
+			drop(fmt.Errorf("ogg: unsupported version %d", r.buf[4]))
+			continue
+		}
+		nseg := int(r.buf[26])
+		if len(r.buf) < oggHeaderLen+nseg {
+			break
+		}
+		lacing := r.buf[oggHeaderLen : oggHeaderLen+nseg]
+		bodyLen := 0
+		for _, l := range lacing {
+			bodyLen += int(l)
+		}
+		pageLen := oggHeaderLen + nseg + bodyLen
+		if len(r.buf) < pageLen {
+			break
+		}
+		page := r.buf[:pageLen]
+		if want, got := binary.LittleEndian.Uint32(page[22:26]), oggCRC(page); want != got {
+			drop(errors.New("ogg: page checksum mismatch"))
+			continue
+		}
+		serial := binary.LittleEndian.Uint32(page[14:18])
+		if !r.locked {
+			r.serial, r.locked = serial, true
+		}
+		if serial == r.serial {
+			continued := page[5]&0x01 != 0
+			if !continued {
+				r.partial = r.partial[:0]
+			}
+			// After a dropped page the head of a continued packet is gone;
+			// skip the rest of it.
+			skip := continued && r.lost
+			r.lost = false
+			body := page[oggHeaderLen+nseg:]
+			for _, l := range lacing {
+				if !skip {
+					r.partial = append(r.partial, body[:l]...)
+				}
+				body = body[l:]
+				if l < 255 {
+					if !skip {
+						packets = append(packets, append([]byte(nil), r.partial...))
+					}
+					r.partial = r.partial[:0]
+					skip = false
+				}
+			}
+		}
+		r.buf = append(r.buf[:0], r.buf[pageLen:]...)
+	}
+	return packets, err
+}
+
+// resync drops the buffer up to the next capture pattern at or after from.
+// Without one it keeps only a tail that could be the start of a pattern.
+func (r *oggReader) resync(from int) {
+	if i := bytes.Index(r.buf[from:], oggCapture); i >= 0 {
+		r.buf = append(r.buf[:0], r.buf[from+i:]...)
+		return
+	}
+	for k := min(len(oggCapture)-1, len(r.buf)); k > 0; k-- {
+		if tail := r.buf[len(r.buf)-k:]; bytes.HasPrefix(oggCapture, tail) {
+			r.buf = append(r.buf[:0], tail...)
+			return
+		}
+	}
+	r.buf = r.buf[:0]
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// oggCRC is the page checksum, computed with the checksum field zeroed.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for i, b := range page {
+		if i >= 22 && i < 26 {
+			b = 0
+		}
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
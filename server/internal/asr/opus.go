@@ -0,0 +1,165 @@
+package asr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// OpusDecoder decodes one Opus packet to interleaved s16le samples at the
+// rate and channel count it was created with.
+type OpusDecoder interface {
+	Decode(packet []byte) ([]int16, error)
+}
+
+// OpusDecoderFactory creates a decoder. Opus decodes natively at 8, 12,
+// 16, 24 or 48 kHz; we always ask for 16 kHz.
+type OpusDecoderFactory func(sampleRate, channels int) (OpusDecoder, error)
+
+var (
+	opusMu      sync.RWMutex
+	opusFactory OpusDecoderFactory
+)
+
+// RegisterOpusDecoder installs the Opus implementation. Builds with the
+// opus tag register libopus; without one, Opus input is refused.
+func RegisterOpusDecoder(f OpusDecoderFactory) {
+	opusMu.Lock()
+	opusFactory = f
+	opusMu.Unlock()
+}
+
+// ErrOpusUnavailable is returned when no Opus decoder is registered.
+var ErrOpusUnavailable = errors.New("opus decoding is not available in this build")
+
+func newOpusDecoder(channels int) (OpusDecoder, error) {
+	opusMu.RLock()
+	f := opusFactory
+	opusMu.RUnlock()
+	if f == nil {
+		return nil, ErrOpusUnavailable
+	}
+	return f(PCM16k.SampleRate, channels)
+}
+
+// opusAvailable reports whether Opus formats can be accepted.
+func opusAvailable() bool {
+	opusMu.RLock()
+	defer opusMu.RUnlock()
+	return opusFactory != nil
+}
+
+// opusInput decodes Opus binary frames to PCM16k. With Encoding Opus each
+// packet is prefixed by its length as a big-endian uint16; with OggOpus the
+// frames are an Ogg/Opus file cut at arbitrary points.
+type opusInput struct {
+	format   Format
+	ogg      *oggReader
+	framed   []byte // unconsumed bytes of the length-prefixed stream
+	dec      OpusDecoder
+	channels int
+	downmix  *Converter
+	headers  int // Ogg header packets seen (OpusHead, OpusTags)
+}
+
+func newOpusInput(f Format) (*opusInput, error) {
+	if !opusAvailable() {
+		return nil, ErrOpusUnavailable
+	}
+	in := &opusInput{format: f}
+	if f.Encoding == OggOpus {
+		in.ogg = &oggReader{}
+		return in, nil
+	}
+	if err := in.open(f.Channels); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (in *opusInput) open(channels int) error {
+	dec, err := newOpusDecoder(channels)
+	if err != nil {
+		return err
+	}
+	in.dec, in.channels = dec, channels
+	in.downmix = nil
+	if channels > 1 {
+		in.downmix, err = NewConverter(Format{SampleRate: PCM16k.SampleRate, Channels: channels, Encoding: S16LE}, PCM16k)
+	}
+	return err
+}
+
+// Decode returns the PCM16k audio carried by data.
+func (in *opusInput) Decode(data []byte) ([]byte, error) {
+	packets, err := in.packets(data)
+	var out []byte
+	for _, p := range packets {
+		if in.ogg != nil && in.headers < 2 {
+			if herr := in.header(p); herr != nil {
+				return out, herr
+			}
+			continue
+		}
+		samples, derr := in.dec.Decode(p)
+		if derr != nil {
+			return out, fmt.Errorf("opus: %w", derr)
+		}
+		pcm := make([]byte, 2*len(samples))
+		for i, v := range samples {
+			binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+		}
+		if in.downmix != nil {
+			pcm = in.downmix.Convert(pcm)
+		}
+		out = append(out, pcm...)
+	}
+	return out, err
+}
+
+func (in *opusInput) packets(data []byte) ([][]byte, error) {
+	if in.ogg != nil {
+		return in.ogg.Write(data)
+	}
+	in.framed = append(in.framed, data...)
+	var (
+		packets [][]byte
+		err     error
+	)
+	for len(in.framed) >= 2 {
+		n := int(binary.BigEndian.Uint16(in.framed))
+		if n == 0 {
+			// Drop the bad prefix so the rest of the stream still decodes.
+			err = errors.New("opus: zero-length packet")
+			in.framed = append(in.framed[:0], in.framed[2:]...)
+			continue
+		}
+		if len(in.framed) < 2+n {
+			break
+		}
+		packets = append(packets, append([]byte(nil), in.framed[2:2+n]...))
+		in.framed = append(in.framed[:0], in.framed[2+n:]...)
+	}
+	return packets, err
+}
+
+// header consumes the OpusHead and OpusTags packets of an Ogg stream.
+func (in *opusInput) header(p []byte) error {
+	in.headers++
+	if in.headers == 2 {
+		if !bytes.HasPrefix(p, []byte("OpusTags")) {
+			return errors.New("ogg/opus: missing OpusTags")
+		}
+		return nil
+	}
+	if len(p) < 19 || !bytes.HasPrefix(p, []byte("OpusHead")) {
+		return errors.New("ogg/opus: stream does not start with OpusHead")
+	}
+	channels := int(p[9])
+	if channels < 1 || channels > 2 {
+		return fmt.Errorf("ogg/opus: unsupported channel count %d", channels)
+	}
+	return in.open(channels)
+}
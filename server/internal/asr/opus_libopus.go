@@ -0,0 +1,54 @@
+//go:build opus && cgo
+
+package asr
+
+/*
+#cgo pkg-config: opus
+#include <opus.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// Building with -tags opus links libopus and enables Opus input.
+func init() { RegisterOpusDecoder(newLibopusDecoder) }
+
+// maxOpusFrame is the longest Opus frame (120ms) at 48 kHz.
+const maxOpusFrame = 5760
+
+type libopusDecoder struct {
+	dec      *C.OpusDecoder
+	channels int
+	pcm      []int16
+}
+
+func newLibopusDecoder(sampleRate, channels int) (OpusDecoder, error) {
+	var rc C.int
+	dec := C.opus_decoder_create(C.opus_int32(sampleRate), C.int(channels), &rc)
+	if rc != C.OPUS_OK {
+		return nil, fmt.Errorf("opus_decoder_create: %s", C.GoString(C.opus_strerror(rc)))
+	}
+	d := &libopusDecoder{dec: dec, channels: channels, pcm: make([]int16, maxOpusFrame*channels)}
+	runtime.SetFinalizer(d, func(d *libopusDecoder) { C.opus_decoder_destroy(d.dec) })
+	return d, nil
+}
+
+func (d *libopusDecoder) Decode(packet []byte) ([]int16, error) {
+	if len(packet) == 0 {
+		return nil, errors.New("empty packet")
+	}
+	n := C.opus_decode(d.dec,
+		(*C.uchar)(unsafe.Pointer(&packet[0])), C.opus_int32(len(packet)),
+		(*C.opus_int16)(unsafe.Pointer(&d.pcm[0])), C.int(maxOpusFrame), 0)
+	if n < 0 {
+		return nil, errors.New(C.GoString(C.opus_strerror(n)))
+	}
+	out := make([]int16, int(n)*d.channels)
+	copy(out, d.pcm)
+	return out, nil
+}
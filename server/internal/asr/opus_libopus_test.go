@@ -0,0 +1,27 @@
+//go:build opus && cgo
+
+package asr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLibopusIsRegistered(t *testing.T) {
+	if !opusAvailable() {
+		t.Fatal("opus build did not register libopus")
+	}
+	in, err := NewInput(Format{SampleRate: 48000, Channels: 1, Encoding: Opus})
+	if err != nil {
+		t.Fatalf("NewInput: %v", err)
+	}
+	// A bare TOC byte (CELT, 20ms, mono) is a lost frame; libopus conceals
+	// it with 20ms of audio.
+	pcm, err := in.Decode([]byte{0x00, 0x01, 0xF8})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got, want := len(pcm), pcmBytes(20*time.Millisecond); got != want {
+		t.Fatalf("decoded %d bytes, want %d", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package asr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// rawDecoder stands in for libopus: each "packet" is s16le samples.
+type rawDecoder struct{}
+
+func (rawDecoder) Decode(p []byte) ([]int16, error) {
+	if len(p)%2 != 0 {
+		return nil, errors.New("odd packet")
+	}
+	out := make([]int16, len(p)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(p[2*i:]))
+	}
+	return out, nil
+}
+
+func withRawOpus(t *testing.T) {
+	t.Helper()
+	opusMu.RLock()
+	prev := opusFactory
+	opusMu.RUnlock()
+	RegisterOpusDecoder(func(rate, channels int) (OpusDecoder, error) { return rawDecoder{}, nil })
+	t.Cleanup(func() { RegisterOpusDecoder(prev) })
+}
+
+// oggPage builds one Ogg page holding packets; a packet of 255*k bytes is
+// not terminated, so the next page continues it.
+func oggPage(seq uint32, continued bool, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for n >= 255 {
+			lacing = append(lacing, 255)
+			n -= 255
+		}
+		if len(p)%255 != 0 || len(p) == 0 {
+			lacing = append(lacing, byte(n))
+		}
+		body = append(body, p...)
+	}
+	h := make([]byte, oggHeaderLen, oggHeaderLen+len(lacing)+len(body))
+	copy(h, "OggS")
+	if continued {
+		h[5] = 0x01
+	}
+	binary.LittleEndian.PutUint32(h[14:], 0xC0FFEE)
+	binary.LittleEndian.PutUint32(h[18:], seq)
+	h[26] = byte(len(lacing))
+	page := append(append(h, lacing...), body...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+func opusHead(channels byte) []byte {
+	h := make([]byte, 19)
+	copy(h, "OpusHead")
+	h[8], h[9] = 1, channels
+	binary.LittleEndian.PutUint32(h[12:], 48000)
+	return h
+}
+
+func TestParseFormatRefusesOpusWithoutDecoder(t *testing.T) {
+	opusMu.RLock()
+	prev := opusFactory
+	opusMu.RUnlock()
+	RegisterOpusDecoder(nil)
+	defer RegisterOpusDecoder(prev)
+	if _, err := ParseFormat(0, 0, "opus"); !errors.Is(err, ErrOpusUnavailable) {
+		t.Fatalf("expected ErrOpusUnavailable, got %v", err)
+	}
+}
+
+func TestOggOpusInputSplitAnywhere(t *testing.T) {
+	withRawOpus(t)
+	f, err := ParseFormat(0, 0, "ogg_opus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := NewInput(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio := make([]byte, 600) // spans two pages below
+	for i := range audio {
+		audio[i] = byte(i)
+	}
+	var stream []byte
+	stream = append(stream, oggPage(0, false, opusHead(1))...)
+	stream = append(stream, oggPage(1, false, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00"))...)
+	stream = append(stream, oggPage(2, false, audio[:510])...)
+	stream = append(stream, oggPage(3, true, audio[510:])...)
+
+	var out []byte
+	for len(stream) > 0 {
+		n := 7
+		if n > len(stream) {
+			n = len(stream)
+		}
+		pcm, err := in.Decode(stream[:n])
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		out = append(out, pcm...)
+		stream = stream[n:]
+	}
+	if !bytes.Equal(out, audio) {
+		t.Fatalf("reassembled %d bytes, want the original %d", len(out), len(audio))
+	}
+}
+
+func TestOggRejectsCorruptPage(t *testing.T) {
+	page := oggPage(0, false, []byte("hello"))
+	page[len(page)-1] ^= 0xff
+	if _, err := (&oggReader{}).Write(page); err == nil {
+		t.Fatal("expected checksum error")
+	}
+}
+
+func TestOggResyncsAfterCorruptPage(t *testing.T) {
+	bad := oggPage(0, false, []byte("hello"))
+	bad[len(bad)-1] ^= 0xff
+	var stream []byte
+	stream = append(stream, "junk"...)
+	stream = append(stream, bad...)
+	stream = append(stream, oggPage(1, true, []byte("lost tail"), []byte("world"))...)
+	stream = append(stream, oggPage(2, false, []byte("again"))...)
+
+	r := &oggReader{}
+	packets, err := r.Write(stream)
+	if err == nil {
+		t.Fatal("expected the corrupt page to be reported")
+	}
+	if len(packets) != 2 || string(packets[0]) != "world" || string(packets[1]) != "again" {
+		t.Fatalf("packets after resync = %q", packets)
+	}
+	if len(r.buf) != 0 {
+		t.Fatalf("%d bytes left buffered", len(r.buf))
+	}
+	packets, err = r.Write(oggPage(3, false, []byte("more")))
+	if err != nil || len(packets) != 1 || string(packets[0]) != "more" {
+		t.Fatalf("after resync: %q %v", packets, err)
+	}
+}
+
+func TestFramedOpusSkipsZeroLengthPrefix(t *testing.T) {
+	withRawOpus(t)
+	f, err := ParseFormat(0, 1, "opus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := NewInput(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := in.Decode([]byte{0, 0, 0, 2, 0x10, 0x00})
+	if err == nil {
+		t.Fatal("expected the zero-length prefix to be reported")
+	}
+	if len(out) == 0 {
+		t.Fatal("packet after the bad prefix was not decoded")
+	}
+	if out, err = in.Decode([]byte{0, 2, 0x10, 0x00}); err != nil || len(out) == 0 {
+		t.Fatalf("after the bad prefix: %d bytes, %v", len(out), err)
+	}
+}
+
+func TestFramedOpusDownmixesStereo(t *testing.T) {
+	withRawOpus(t)
+	f, err := ParseFormat(0, 2, "opus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := NewInput(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two stereo frames: (1000, 3000) and (-2000, -4000).
+	packet := make([]byte, 8)
+	for i, v := range []int16{1000, 3000, -2000, -4000} {
+		binary.LittleEndian.PutUint16(packet[2*i:], uint16(v))
+	}
+	framed := append([]byte{0, byte(len(packet))}, packet...)
+	first, err := in.Decode(framed[:5])
+	if err != nil || len(first) != 0 {
+		t.Fatalf("partial packet decoded early: %v %v", first, err)
+	}
+	out, err := in.Decode(framed[5:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 4 {
+		t.Fatalf("expected 2 mono samples, got %d bytes", len(out))
+	}
+	for i, want := range []int16{2000, -3000} {
+		if got := int16(binary.LittleEndian.Uint16(out[2*i:])); got < want-2 || got > want+2 {
+			t.Errorf("sample %d = %d, want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package asr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayLine is one line of a replay fixture. AtMs is measured from the
+// start of the session.
+type replayLine struct {
+	AtMs  int    `json:"atMs"`
+	Text  string `json:"text"`
+	Final bool   `json:"final"`
+}
+
+// replayClient plays a recorded transcript back on its original timing,
+// ignoring audio. It lets the whole pipeline run offline and repeatably.
+type replayClient struct {
+	events    chan Event
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newReplayClientFromFile(path string) (Client, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, errors.New("a replay file is required for ASR provider replay")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := parseReplay(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return newReplayClient(lines), nil
+}
+
+// parseReplay reads JSON lines such as
+//
+//	{"atMs":1200,"text":"what's the budget","final":false}
+//
+// Blank lines and lines starting with # are skipped. Lines must be in time
+// order.
+func parseReplay(data []byte) ([]replayLine, error) {
+	var lines []replayLine
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		raw := strings.TrimSpace(sc.Text())
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			continue
+		}
+		var l replayLine
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&l); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if len(lines) > 0 && l.AtMs < lines[len(lines)-1].AtMs {
+			return nil, fmt.Errorf("line %d: atMs %d goes back in time", n, l.AtMs)
+		}
+		lines = append(lines, l)
+	}
+	return lines, sc.Err()
+}
+
+func newReplayClient(lines []replayLine) *replayClient {
+	c := &replayClient{
+		events: make(chan Event, 16),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.play(lines, time.Now())
+	return c
+}
+
+func (c *replayClient) play(lines []replayLine, start time.Time) {
+	defer close(c.done)
+	for _, l := range lines {
+		wait := time.Until(start.Add(time.Duration(l.AtMs) * time.Millisecond))
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-c.stop:
+				t.Stop()
+				return
+			}
+		}
+		evt := Event{Type: "partial", Text: l.Text, IsFinal: l.Final}
+		if l.Final {
+			evt.Type = "final"
+		}
+		select {
+		case c.events <- evt:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *replayClient) WritePCM([]byte) bool { return true }
+func (c *replayClient) Events() <-chan Event { return c.events }
+func (c *replayClient) Dropped() int64       { return 0 }
+func (c *replayClient) Flush()               {}
+func (c *replayClient) Discard()             {}
+
+func (c *replayClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+		close(c.events)
+	})
+}
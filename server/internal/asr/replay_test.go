@@ -0,0 +1,49 @@
+package asr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayClientFollowsFixtureTiming(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "call.jsonl")
+	err := os.WriteFile(fixture, []byte(`# demo call
+{"atMs":0,"text":"what is","final":false}
+
+{"atMs":50,"text":"what is the budget","final":true}
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	client, err := New(Config{Provider: "replay", ReplayFile: fixture})
+	if err != nil {
+		t.Fatalf("New replay: %v", err)
+	}
+	defer client.Close()
+	first := nextEvent(t, client.Events())
+	if first.IsFinal || first.Text != "what is" {
+		t.Fatalf("unexpected first event %#v", first)
+	}
+	final := nextEvent(t, client.Events())
+	if !final.IsFinal || final.Text != "what is the budget" {
+		t.Fatalf("unexpected final %#v", final)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("final arrived after %v, before its atMs", elapsed)
+	}
+}
+
+func TestParseReplayRejectsBadFixtures(t *testing.T) {
+	for _, bad := range []string{
+		`{"atMs":10,"text":"a"}` + "\n" + `{"atMs":5,"text":"b"}`,
+		`{"at":10,"text":"typo"}`,
+		`not json`,
+	} {
+		if _, err := parseReplay([]byte(bad)); err == nil {
+			t.Errorf("parseReplay(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package asr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStubScript is used when StubConfig names no script, so the stub
+// provider alone shows the full pipeline.
+var defaultStubScript = []string{
+	"What does the budget look like for this quarter",
+	"We need to run it past our security team first",
+	"When could we start a pilot",
+}
+
+type stubConfig struct {
+	Utterances []string
+	// PCMPaced advances one word per WordEvery of received audio; otherwise
+	// words advance on the wall clock from construction.
+	PCMPaced  bool
+	WordEvery time.Duration
+	// Gap is the pause after each final before the next utterance starts.
+	Gap  time.Duration
+	Loop bool
+}
+
+// stubClient replays a script as ASR events: partials that grow word by
+// word, then a final, for each utterance in turn. Flush finalizes the words
+// spoken so far, like a real provider at stop.
+type stubClient struct {
+	cfg    stubConfig
+	script [][]string
+	events chan Event
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu        sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+	utt, word int
+	idle      int // steps left in the current gap
+	audio     time.Duration
+}
+
+func newStubClientFromConfig(sc StubConfig) (Client, error) {
+	cfg := stubConfig{
+		PCMPaced:  !strings.EqualFold(strings.TrimSpace(sc.Pace), "time"),
+		WordEvery: sc.Word,
+		Gap:       sc.Gap,
+		Loop:      sc.Loop,
+	}
+	switch {
+	case strings.TrimSpace(sc.Script) != "":
+		path := strings.TrimSpace(sc.Script)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Utterances = parseStubScript(data)
+		if len(cfg.Utterances) == 0 {
+			return nil, fmt.Errorf("%s: stub script has no utterances", path)
+		}
+	default:
+		for _, u := range sc.Lines {
+			if u = strings.TrimSpace(u); u != "" {
+				cfg.Utterances = append(cfg.Utterances, u)
+			}
+		}
+	}
+	return newStubClient(cfg), nil
+}
+
+// parseStubScript reads one utterance per line; blank lines and lines
+// starting with # are skipped.
+func parseStubScript(data []byte) []string {
+	var out []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func newStubClient(cfg stubConfig) *stubClient {
+	if len(cfg.Utterances) == 0 {
+		cfg.Utterances = defaultStubScript
+	}
+	if cfg.WordEvery <= 0 {
+		cfg.WordEvery = 250 * time.Millisecond
+	}
+	if cfg.Gap <= 0 {
+		cfg.Gap = time.Second
+	}
+	c := &stubClient{
+		cfg:    cfg,
+		events: make(chan Event, 16),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, u := range cfg.Utterances {
+		c.script = append(c.script, strings.Fields(u))
+	}
+	if cfg.PCMPaced {
+		close(c.done)
+	} else {
+		go c.tick()
+	}
+	return c
+}
+
+func (c *stubClient) tick() {
+	defer close(c.done)
+	t := time.NewTicker(c.cfg.WordEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			c.mu.Lock()
+			c.step()
+			c.mu.Unlock()
+		}
+	}
+}
+
+// WritePCM accepts every frame; with PCM pacing each WordEvery of audio
+// advances the script by one step.
+func (c *stubClient) WritePCM(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	if !c.cfg.PCMPaced {
+		return true
+	}
+	c.audio += time.Duration(len(data)) * time.Second / pcmBytesPerSecond
+	for c.audio >= c.cfg.WordEvery {
+		c.audio -= c.cfg.WordEvery
+		c.step()
+	}
+	return true
+}
+
+// step reveals the next word, or finalizes an utterance whose words are all
+// out. Caller holds c.mu.
+func (c *stubClient) step() {
+	if c.idle > 0 {
+		c.idle--
+		return
+	}
+	if c.utt >= len(c.script) {
+		if !c.cfg.Loop {
+			return
+		}
+		c.utt = 0
+	}
+	words := c.script[c.utt]
+	if c.word < len(words) {
+		c.word++
+		c.emit(Event{Type: "partial", Text: strings.Join(words[:c.word], " ")})
+		return
+	}
+	c.finalize()
+}
+
+// finalize emits the words revealed so far as a final and moves to the
+// next utterance. Caller holds c.mu.
+func (c *stubClient) finalize() {
+	if c.utt >= len(c.script) || c.word == 0 {
+		return
+	}
+	c.emit(Event{Type: "final", Text: strings.Join(c.script[c.utt][:c.word], " "), IsFinal: true})
+	c.utt++
+	c.word = 0
+	c.idle = int(c.cfg.Gap / c.cfg.WordEvery)
+}
+
+func (c *stubClient) emit(evt Event) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.events <- evt:
+	default:
+	}
+}
+
+func (c *stubClient) Events() <-chan Event { return c.events }
+
+func (c *stubClient) Dropped() int64 { return 0 }
+
+func (c *stubClient) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finalize()
+}
+
+// Discard restarts the current utterance without a final.
+func (c *stubClient) Discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.word = 0
+}
+
+func (c *stubClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+		c.mu.Lock()
+		c.closed = true
+		close(c.events)
+		c.mu.Unlock()
+	})
+}
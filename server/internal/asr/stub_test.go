@@ -0,0 +1,71 @@
+package asr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStubPacedByAudio(t *testing.T) {
+	s := newStubClient(stubConfig{
+		Utterances: []string{"who owns budget"},
+		PCMPaced:   true,
+		WordEvery:  40 * time.Millisecond,
+	})
+	defer s.Close()
+
+	// 20ms frames: every second frame reveals a word.
+	for i := 0; i < 8; i++ {
+		if !s.WritePCM(frame(0)) {
+			t.Fatal("stub rejected audio")
+		}
+	}
+	for _, want := range []Event{
+		{Type: "partial", Text: "who"},
+		{Type: "partial", Text: "who owns"},
+		{Type: "partial", Text: "who owns budget"},
+		{Type: "final", Text: "who owns budget", IsFinal: true},
+	} {
+		if got := nextEvent(t, s.Events()); got != want {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	}
+	select {
+	case evt := <-s.Events():
+		t.Fatalf("script should be exhausted, got %#v", evt)
+	default:
+	}
+}
+
+func TestStubFlushFinalizesWordsSoFar(t *testing.T) {
+	s := newStubClient(stubConfig{Utterances: []string{"one two three", "next"}, PCMPaced: true, WordEvery: 20 * time.Millisecond})
+	defer s.Close()
+	s.WritePCM(frame(0))
+	s.WritePCM(frame(0))
+	s.Flush()
+	nextEvent(t, s.Events())
+	nextEvent(t, s.Events())
+	if got := nextEvent(t, s.Events()); !got.IsFinal || got.Text != "one two" {
+		t.Fatalf("expected early final, got %#v", got)
+	}
+}
+
+func TestStubFromConfigScriptOnWallClock(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(script, []byte("# demo\nhello there\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{Provider: "stub", Stub: StubConfig{Script: script, Pace: "time", Word: 5 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var last Event
+	for !last.IsFinal {
+		last = nextEvent(t, c.Events())
+	}
+	if last.Text != "hello there" {
+		t.Fatalf("unexpected final %#v", last)
+	}
+}
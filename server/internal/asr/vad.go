@@ -0,0 +1,183 @@
+package asr
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// VADConfig tunes the voice activity detector. Zero fields take defaults.
+type VADConfig struct {
+	// MinRMS is the absolute energy floor for speech.
+	MinRMS float64 `yaml:"minRMS"`
+	// Ratio is how far above the tracked noise floor a window must be to
+	// count as speech.
+	Ratio float64 `yaml:"ratio"`
+	// MaxZCR is the zero-crossing rate (crossings per sample) above which a
+	// window is treated as hiss rather than voice.
+	MaxZCR float64 `yaml:"maxZCR"`
+	// Onset is how long speech must last before SpeechStart fires.
+	Onset time.Duration `yaml:"onset"`
+	// Hangover is how long silence must last before SpeechEnd fires.
+	Hangover time.Duration `yaml:"hangover"`
+	// PreRoll is audio kept from before the onset and forwarded with it so
+	// the first syllable is not clipped.
+	PreRoll time.Duration `yaml:"preRoll"`
+}
+
+func (c VADConfig) withDefaults() VADConfig {
+	if c.MinRMS <= 0 {
+		c.MinRMS = 300
+	}
+	if c.Ratio <= 0 {
+		c.Ratio = 3
+	}
+	if c.MaxZCR <= 0 {
+		c.MaxZCR = 0.45
+	}
+	if c.Onset <= 0 {
+		c.Onset = 60 * time.Millisecond
+	}
+	if c.Hangover <= 0 {
+		c.Hangover = 600 * time.Millisecond
+	}
+	if c.PreRoll <= 0 {
+		c.PreRoll = 200 * time.Millisecond
+	}
+	return c
+}
+
+// VADEvent is a speech/silence transition.
+type VADEvent int
+
+const (
+	SpeechStart VADEvent = iota + 1
+	SpeechEnd
+)
+
+// vadWindow is the analysis window: 20ms of 16 kHz PCM.
+const vadWindow = 20 * time.Millisecond
+
+// VAD is an energy and zero-crossing voice activity detector for 16-bit
+// mono PCM. The speech threshold follows the background level, so room noise
+// that builds up gradually does not read as speech. It is not safe for
+// concurrent use.
+type VAD struct {
+	cfg                     VADConfig
+	window                  int
+	onsetWins, hangoverWins int
+	preRollBytes            int
+
+	rest     []byte // partial window carried to the next frame
+	preRoll  []byte
+	floor    float64 // running estimate of background energy
+	speaking bool
+	run      int // consecutive windows disagreeing with speaking
+}
+
+// NewVAD returns a detector that starts in silence.
+func NewVAD(cfg VADConfig) *VAD {
+	cfg = cfg.withDefaults()
+	win := pcmBytes(vadWindow)
+	return &VAD{
+		cfg:          cfg,
+		window:       win,
+		onsetWins:    windows(cfg.Onset),
+		hangoverWins: windows(cfg.Hangover),
+		preRollBytes: pcmBytes(cfg.PreRoll),
+		floor:        cfg.MinRMS / cfg.Ratio,
+	}
+}
+
+func windows(d time.Duration) int {
+	n := int(d / vadWindow)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Speaking reports whether the detector is currently inside an utterance.
+func (v *VAD) Speaking() bool { return v.speaking }
+
+// Process analyses one frame. It returns the audio worth transcribing —
+// nothing while silent, the pre-roll plus the frame at onset, and every
+// frame through the hangover — along with any transitions the frame caused,
+// in order.
+func (v *VAD) Process(pcm []byte) (audio []byte, events []VADEvent) {
+	buf := append(v.rest, pcm...)
+	for len(buf) >= v.window {
+		win := buf[:v.window]
+		buf = buf[v.window:]
+		voiced := v.classify(win)
+
+		if voiced != v.speaking {
+			v.run++
+		} else {
+			v.run = 0
+		}
+
+		switch {
+		case !v.speaking && v.run >= v.onsetWins:
+			v.speaking, v.run = true, 0
+			events = append(events, SpeechStart)
+			audio = append(audio, v.preRoll...)
+			v.preRoll = v.preRoll[:0]
+		case v.speaking && v.run >= v.hangoverWins:
+			v.speaking, v.run = false, 0
+			events = append(events, SpeechEnd)
+		}
+
+		if v.speaking {
+			audio = append(audio, win...)
+		} else {
+			v.keepPreRoll(win)
+		}
+	}
+	v.rest = append(v.rest[:0], buf...)
+	return audio, events
+}
+
+// classify reports whether a window sounds like voice and, when it does
+// not, folds its energy into the noise floor.
+func (v *VAD) classify(win []byte) bool {
+	energy := rms(win)
+	threshold := v.floor * v.cfg.Ratio
+	if threshold < v.cfg.MinRMS {
+		threshold = v.cfg.MinRMS
+	}
+	voiced := energy >= threshold && zcr(win) <= v.cfg.MaxZCR
+	if !voiced {
+		// Slow to rise, quick to fall, so speech never drags the floor up.
+		if energy < v.floor {
+			v.floor = 0.7*v.floor + 0.3*energy
+		} else {
+			v.floor = 0.98*v.floor + 0.02*energy
+		}
+	}
+	return voiced
+}
+
+func (v *VAD) keepPreRoll(win []byte) {
+	v.preRoll = append(v.preRoll, win...)
+	if over := len(v.preRoll) - v.preRollBytes; over > 0 {
+		v.preRoll = append(v.preRoll[:0], v.preRoll[over:]...)
+	}
+}
+
+// zcr is the fraction of adjacent 16-bit samples that change sign.
+func zcr(pcm []byte) float64 {
+	n := len(pcm) / 2
+	if n < 2 {
+		return 0
+	}
+	crossings := 0
+	prev := int16(binary.LittleEndian.Uint16(pcm))
+	for i := 1; i < n; i++ {
+		cur := int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+		if (prev >= 0) != (cur >= 0) {
+			crossings++
+		}
+		prev = cur
+	}
+	return float64(crossings) / float64(n-1)
+}
@@ -0,0 +1,89 @@
+package asr
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// tone returns d of a 200 Hz square wave, voice-like in energy and
+// zero-crossing rate.
+func tone(d time.Duration, amp int16) []byte {
+	b := make([]byte, pcmBytes(d))
+	for i := 0; i < len(b)/2; i++ {
+		v := amp
+		if (i/40)%2 == 1 {
+			v = -amp
+		}
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(v))
+	}
+	return b
+}
+
+func TestVADSegmentsUtterance(t *testing.T) {
+	v := NewVAD(VADConfig{Hangover: 100 * time.Millisecond})
+
+	audio, events := v.Process(tone(500*time.Millisecond, 0))
+	if len(audio) != 0 || len(events) != 0 {
+		t.Fatalf("silence produced audio=%d events=%v", len(audio), events)
+	}
+
+	audio, events = v.Process(tone(300*time.Millisecond, 4000))
+	if len(events) != 1 || events[0] != SpeechStart {
+		t.Fatalf("expected SpeechStart, got %v", events)
+	}
+	// Speech plus up to 200ms of pre-roll.
+	if len(audio) < pcmBytes(300*time.Millisecond) {
+		t.Fatalf("speech not forwarded in full: %d bytes", len(audio))
+	}
+	if !v.Speaking() {
+		t.Fatal("expected to be speaking")
+	}
+
+	// A pause shorter than the hangover is still part of the utterance.
+	audio, events = v.Process(tone(60*time.Millisecond, 0))
+	if len(events) != 0 || len(audio) == 0 {
+		t.Fatalf("short pause: audio=%d events=%v", len(audio), events)
+	}
+
+	_, events = v.Process(tone(200*time.Millisecond, 0))
+	if len(events) != 1 || events[0] != SpeechEnd {
+		t.Fatalf("expected SpeechEnd, got %v", events)
+	}
+	if v.Speaking() {
+		t.Fatal("expected silence after hangover")
+	}
+}
+
+func TestVADIgnoresHiss(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hiss := make([]byte, pcmBytes(time.Second))
+	for i := 0; i < len(hiss)/2; i++ {
+		binary.LittleEndian.PutUint16(hiss[2*i:], uint16(int16(rng.Intn(8000)-4000)))
+	}
+	v := NewVAD(VADConfig{})
+	if _, events := v.Process(hiss); len(events) != 0 {
+		t.Fatalf("white noise triggered VAD: %v", events)
+	}
+}
+
+func TestVADHandlesOddFrameSizes(t *testing.T) {
+	v := NewVAD(VADConfig{})
+	speech := tone(200*time.Millisecond, 4000)
+	var started bool
+	for len(speech) > 0 {
+		n := 333
+		if n > len(speech) {
+			n = len(speech)
+		}
+		_, events := v.Process(speech[:n])
+		speech = speech[n:]
+		for _, ev := range events {
+			started = started || ev == SpeechStart
+		}
+	}
+	if !started {
+		t.Fatal("speech split across odd frames was not detected")
+	}
+}
@@ -0,0 +1,321 @@
+package asr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/trace"
+
+	"nhooyr.io/websocket"
+)
+
+const defaultVoskURL = "ws://localhost:2700"
+
+// voskClient streams PCM to a local recognizer speaking the Vosk WebSocket
+// protocol (vosk-server, and whisper streaming servers that emulate it):
+// a config message, binary audio, {"eof":1} to finish; the server answers
+// {"partial":...} while listening and {"text":...} at each endpoint.
+//
+// The recognizer finalizes on its own endpoints; Flush forces one by ending
+// the stream, and the next frame opens a fresh one.
+type voskClient struct {
+	url     string
+	log     *slog.Logger
+	frames  chan voskMsg
+	events  chan Event
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	readers sync.WaitGroup
+
+	mu      sync.RWMutex // guards closed against sends on frames
+	closed  bool
+	dropped atomic.Int64
+	// utterance runs from the first frame after a final to the next final.
+	utterance atomic.Pointer[trace.Span]
+
+	// Owned by run.
+	conn     *websocket.Conn
+	readDone chan struct{}
+	lastFail time.Time
+}
+
+type voskMsg struct {
+	pcm     []byte
+	eof     bool
+	discard bool
+}
+
+func newVoskClientFromConfig(cfg Config) (Client, error) {
+	url := firstNonEmpty(cfg.Vosk.URL, defaultVoskURL)
+	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		return nil, fmt.Errorf("vosk URL must be a ws:// or wss:// URL, got %q", url)
+	}
+	return newVoskClient(url, cfg.Vosk.Buffer, cfg.logger()), nil
+}
+
+func newVoskClient(url string, buffer int, log *slog.Logger) *voskClient {
+	if buffer <= 0 {
+		buffer = 128
+	}
+	if log == nil {
+		log = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &voskClient{
+		url:    url,
+		log:    log.With("asr", "vosk"),
+		frames: make(chan voskMsg, buffer),
+		events: make(chan Event, 16),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *voskClient) WritePCM(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.frames <- voskMsg{pcm: append([]byte(nil), data...)}:
+		return true
+	default:
+		c.drop()
+		return false
+	}
+}
+
+func (c *voskClient) drop() {
+	c.dropped.Add(1)
+	obs.IncPCMFrameDrop()
+}
+
+func (c *voskClient) Events() <-chan Event { return c.events }
+
+func (c *voskClient) Dropped() int64 { return c.dropped.Load() }
+
+// Flush ends the current recognizer stream so its last words come back as
+// a final.
+func (c *voskClient) Flush() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.frames <- voskMsg{eof: true}:
+	case <-c.ctx.Done():
+	}
+}
+
+// Discard drops the frames not yet sent and ends the recognizer stream
+// without asking for its final.
+func (c *voskClient) Discard() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	for drained := false; !drained; {
+		select {
+		case <-c.frames:
+		default:
+			drained = true
+		}
+	}
+	select {
+	case c.frames <- voskMsg{discard: true}:
+	default: // refilled meanwhile; those frames open the next stream
+	}
+}
+
+// Close finishes the stream, waits for its final and closes Events.
+func (c *voskClient) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.done
+		return
+	}
+	c.closed = true
+	close(c.frames)
+	c.mu.Unlock()
+	<-c.done
+	c.cancel()
+}
+
+func (c *voskClient) run() {
+	defer close(c.done)
+	defer close(c.events)
+	defer c.readers.Wait()
+	for m := range c.frames {
+		if m.eof {
+			c.utterance.Load().AddEvent("flush")
+			c.finish()
+			continue
+		}
+		if m.discard {
+			c.abandon()
+			continue
+		}
+		if c.utterance.Load() == nil {
+			c.utterance.Store(trace.StartFrom(trace.SpanContext{}, "asr.utterance", trace.String("asr.provider", "vosk")))
+		}
+		if c.conn == nil && !c.connect() {
+			c.drop()
+			continue
+		}
+		ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+		err := c.conn.Write(ctx, websocket.MessageBinary, m.pcm)
+		cancel()
+		if err != nil {
+			c.fail(fmt.Errorf("write: %w", err))
+		}
+	}
+	c.finish()
+}
+
+// connect dials the recognizer unless a recent attempt failed.
+func (c *voskClient) connect() bool {
+	if time.Since(c.lastFail) < time.Second {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, c.url, nil)
+	if err != nil {
+		c.fail(fmt.Errorf("dial %s: %w", c.url, err))
+		return false
+	}
+	cfg, _ := json.Marshal(map[string]any{"config": map[string]any{"sample_rate": PCM16k.SampleRate, "words": true}})
+	if err := conn.Write(ctx, websocket.MessageText, cfg); err != nil {
+		_ = conn.Close(websocket.StatusInternalError, "config failed")
+		c.fail(fmt.Errorf("config: %w", err))
+		return false
+	}
+	c.conn = conn
+	c.readDone = make(chan struct{})
+	c.readers.Add(1)
+	go c.read(conn, c.readDone)
+	return true
+}
+
+// finish sends eof and waits briefly for the recognizer's last result.
+func (c *voskClient) finish() {
+	if c.conn == nil {
+		return
+	}
+	conn, readDone := c.conn, c.readDone
+	c.conn = nil
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := conn.Write(ctx, websocket.MessageText, []byte(`{"eof":1}`))
+	if err == nil {
+		select {
+		case <-readDone:
+			obs.ASRLatency.With("vosk").ObserveSince(start)
+		case <-ctx.Done():
+			err = errors.New("no final result before timeout")
+		}
+	}
+	_ = conn.Close(websocket.StatusNormalClosure, "")
+	if err != nil && c.ctx.Err() == nil {
+		c.fail(fmt.Errorf("finish: %w", err))
+	}
+}
+
+// abandon closes the recognizer stream without waiting for a result.
+func (c *voskClient) abandon() {
+	if span := c.utterance.Swap(nil); span != nil {
+		span.AddEvent("discard")
+		span.End()
+	}
+	if c.conn != nil {
+		_ = c.conn.Close(websocket.StatusNormalClosure, "discarded")
+		c.conn = nil
+	}
+}
+
+func (c *voskClient) fail(err error) {
+	c.log.Warn("recognizer failed", "err", err)
+	obs.Error(obs.StageASR, "vosk", err)
+	c.emit(Event{Type: "error", Err: err})
+	c.lastFail = time.Now()
+	if c.conn != nil {
+		_ = c.conn.Close(websocket.StatusInternalError, "")
+		c.conn = nil
+	}
+}
+
+func (c *voskClient) read(conn *websocket.Conn, done chan struct{}) {
+	defer c.readers.Done()
+	defer close(done)
+	var lastPartial string
+	for {
+		_, data, err := conn.Read(context.Background())
+		if err != nil {
+			if websocket.CloseStatus(err) == -1 {
+				c.log.Warn("read failed", "err", err)
+			}
+			return
+		}
+		var res struct {
+			Partial *string `json:"partial"`
+			Text    *string `json:"text"`
+			Result  []struct {
+				Conf float64 `json:"conf"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(data, &res); err != nil {
+			c.log.Warn("bad result", "err", err)
+			continue
+		}
+		switch {
+		case res.Text != nil:
+			lastPartial = ""
+			if t := strings.TrimSpace(*res.Text); t != "" {
+				var conf float64
+				for _, w := range res.Result {
+					conf += w.Conf
+				}
+				if len(res.Result) > 0 {
+					conf /= float64(len(res.Result))
+				}
+				span := c.utterance.Swap(nil)
+				span.SetAttrs(trace.Int("asr.words", len(strings.Fields(t))), trace.Float("asr.confidence", conf))
+				span.End()
+				c.emit(Event{Type: "final", Text: t, IsFinal: true, Confidence: conf, Trace: span.Context()})
+			}
+		case res.Partial != nil:
+			if t := strings.TrimSpace(*res.Partial); t != "" && t != lastPartial {
+				lastPartial = t
+				c.emit(Event{Type: "partial", Text: t})
+			}
+		}
+	}
+}
+
+func (c *voskClient) emit(evt Event) {
+	select {
+	case c.events <- evt:
+	default:
+		c.log.Warn("dropping event, channel full", "event", evt.Type)
+	}
+}
@@ -0,0 +1,93 @@
+package asr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cluely/server/internal/trace"
+
+	"nhooyr.io/websocket"
+)
+
+// fakeVosk answers every audio frame with a growing partial and eof with
+// the final text, then closes like vosk-server does.
+func fakeVosk(t *testing.T, words ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+		ctx := context.Background()
+		var heard []string
+		for {
+			typ, data, err := c.Read(ctx)
+			if err != nil {
+				return
+			}
+			if typ == websocket.MessageText {
+				if strings.Contains(string(data), "config") {
+					continue
+				}
+				final, _ := json.Marshal(map[string]string{"text": strings.Join(heard, " ")})
+				_ = c.Write(ctx, websocket.MessageText, final)
+				return
+			}
+			if len(heard) < len(words) {
+				heard = append(heard, words[len(heard)])
+			}
+			partial, _ := json.Marshal(map[string]string{"partial": strings.Join(heard, " ")})
+			_ = c.Write(ctx, websocket.MessageText, partial)
+		}
+	}))
+}
+
+func TestVoskClientStreamsPartialsAndFinal(t *testing.T) {
+	srv := fakeVosk(t, "who", "owns", "budget")
+	defer srv.Close()
+	client, err := New(Config{Provider: "vosk", Vosk: VoskConfig{URL: "ws" + strings.TrimPrefix(srv.URL, "http")}})
+	if err != nil {
+		t.Fatalf("New vosk: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		client.WritePCM(frame(3000))
+	}
+	client.Flush()
+
+	var got []Event
+	timeout := time.After(3 * time.Second)
+	for len(got) < 4 {
+		select {
+		case evt := <-client.Events():
+			got = append(got, evt)
+		case <-timeout:
+			t.Fatalf("timed out; got %#v", got)
+		}
+	}
+	want := []Event{
+		{Type: "partial", Text: "who"},
+		{Type: "partial", Text: "who owns"},
+		{Type: "partial", Text: "who owns budget"},
+		{Type: "final", Text: "who owns budget", IsFinal: true},
+	}
+	if !got[3].Trace.IsValid() {
+		t.Error("final carries no utterance trace")
+	}
+	got[3].Trace = trace.SpanContext{}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d = %#v, want %#v", i, got[i], want[i])
+		}
+	}
+
+	// The next utterance opens a fresh stream.
+	client.WritePCM(frame(3000))
+	client.Close()
+	for range client.Events() {
+	}
+}
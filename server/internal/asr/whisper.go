@@ -0,0 +1,153 @@
+package asr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"cluely/server/internal/trace"
+)
+
+const (
+	defaultWhisperBaseURL = "https://api.openai.com/v1"
+	defaultWhisperModel   = "whisper-1"
+)
+
+type whisperConfig struct {
+	APIKey   string
+	Model    string
+	BaseURL  string
+	Language string
+	Timeout  time.Duration
+	Chunk    ChunkConfig
+	Logger   *slog.Logger
+}
+
+// whisperClient posts chunks to an OpenAI-compatible
+// /audio/transcriptions endpoint: OpenAI itself, whisper.cpp's server
+// (started with --inference-path /v1/audio/transcriptions), faster-whisper
+// servers and the like. The endpoint does not stream, so partials come
+// from chunking alone.
+type whisperClient struct {
+	*chunkedStream
+	cfg  whisperConfig
+	http *http.Client
+}
+
+func newWhisperClientFromConfig(cfg Config) (Client, error) {
+	w := cfg.Whisper
+	base := firstNonEmpty(w.BaseURL, defaultWhisperBaseURL)
+	key := firstNonEmpty(w.APIKey)
+	if key == "" && strings.TrimRight(base, "/") == defaultWhisperBaseURL {
+		return nil, errors.New("an API key is required for api.openai.com; set a whisper base URL for a local server")
+	}
+	return newWhisperClient(whisperConfig{
+		APIKey:   key,
+		Model:    firstNonEmpty(w.Model, defaultWhisperModel),
+		BaseURL:  base,
+		Language: firstNonEmpty(w.Language),
+		Chunk:    cfg.Chunk,
+		Logger:   cfg.logger(),
+	}), nil
+}
+
+func newWhisperClient(cfg whisperConfig) *whisperClient {
+	if cfg.Model == "" {
+		cfg.Model = defaultWhisperModel
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultWhisperBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	c := &whisperClient{cfg: cfg, http: trace.NewClient(cfg.Timeout)}
+	c.chunkedStream = newChunkedStream("whisper", cfg.Chunk, cfg.Logger, c.transcribe)
+	return c
+}
+
+func (c *whisperClient) transcribe(ctx context.Context, audio []byte, _ func(string)) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(wavFile(audio, PCM16k)); err != nil {
+		return "", err
+	}
+	_ = mw.WriteField("model", c.cfg.Model)
+	_ = mw.WriteField("response_format", "json")
+	if c.cfg.Language != "" {
+		_ = mw.WriteField("language", c.cfg.Language)
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.BaseURL+"/audio/transcriptions", &body)
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return "", fmt.Errorf("whisper http %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return "", fmt.Errorf("whisper http %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var out struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	return strings.TrimSpace(out.Text), nil
+}
+
+// wavFile wraps s16le PCM in a minimal RIFF/WAVE header.
+func wavFile(pcm []byte, f Format) []byte {
+	const header = 44
+	blockAlign := f.Channels * f.Encoding.bytesPerSample()
+	b := make([]byte, header, header+len(pcm))
+	copy(b[0:], "RIFF")
+	binary.LittleEndian.PutUint32(b[4:], uint32(header-8+len(pcm)))
+	copy(b[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(b[16:], 16)
+	binary.LittleEndian.PutUint16(b[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(b[22:], uint16(f.Channels))
+	binary.LittleEndian.PutUint32(b[24:], uint32(f.SampleRate))
+	binary.LittleEndian.PutUint32(b[28:], uint32(f.SampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(b[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(b[34:], uint16(8*f.Encoding.bytesPerSample()))
+	copy(b[36:], "data")
+	binary.LittleEndian.PutUint32(b[40:], uint32(len(pcm)))
+	return append(b, pcm...)
+}
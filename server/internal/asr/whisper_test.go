@@ -0,0 +1,83 @@
+package asr
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cluely/server/internal/trace"
+)
+
+func TestWhisperClientPostsWAVChunks(t *testing.T) {
+	traceparents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("local server should get no auth header, got %q", got)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("parse form: %v", err)
+		}
+		if m := r.FormValue("model"); m != "base.en" {
+			t.Errorf("model = %q", m)
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("form file: %v", err)
+		}
+		wav, _ := io.ReadAll(f)
+		if !bytes.HasPrefix(wav, []byte("RIFF")) || !bytes.Equal(wav[8:16], []byte("WAVEfmt ")) {
+			t.Errorf("file is not a WAV: %q", wav[:16])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"text":" what is the budget "}`)
+	}))
+	defer srv.Close()
+
+	client, err := New(Config{Provider: "whisper", Whisper: WhisperConfig{Endpoint: Endpoint{BaseURL: srv.URL + "/v1", Model: "base.en"}}})
+	if err != nil {
+		t.Fatalf("New whisper: %v", err)
+	}
+	defer client.Close()
+
+	client.WritePCM(frame(3000))
+	client.Flush()
+	for _, want := range []Event{
+		{Type: "partial", Text: "what is the budget"},
+		{Type: "final", Text: "what is the budget", IsFinal: true},
+	} {
+		select {
+		case evt := <-client.Events():
+			if evt.IsFinal {
+				// The final carries its utterance's span, whose trace the
+				// request joined.
+				sc, ok := trace.ParseTraceparent(<-traceparents)
+				if !ok || !evt.Trace.IsValid() || sc.TraceID != evt.Trace.TraceID {
+					t.Errorf("final trace %v, request traceparent %v", evt.Trace, sc)
+				}
+				evt.Trace = trace.SpanContext{}
+			}
+			if evt != want {
+				t.Fatalf("got %#v, want %#v", evt, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %#v", want)
+		}
+	}
+}
+
+func TestWhisperRequiresKeyForOpenAI(t *testing.T) {
+	cfg := Config{Provider: "whisper"}
+	if _, err := New(cfg); err == nil {
+		t.Fatal("expected an error without an API key")
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected Validate to want an API key")
+	}
+}
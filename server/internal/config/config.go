@@ -0,0 +1,240 @@
+// Package config assembles cluelyd's settings. Each layer overrides the one
+// before it: built-in defaults, a YAML or JSON file, environment variables,
+// then command-line flags. The result is validated as a whole.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"cluely/server/internal/answer"
+	"cluely/server/internal/asr"
+	"cluely/server/internal/obs"
+	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
+	"cluely/server/internal/ws"
+)
+
+// Config is everything cluelyd can be told. The file uses the yaml names;
+// durations are strings such as "1500ms" or "2m".
+type Config struct {
+	// Addr is the listen address.
+	Addr string `yaml:"addr"`
+	// MetricsInterval is how often a metrics summary is logged; 0 turns
+	// the log line off.
+	MetricsInterval time.Duration `yaml:"metricsInterval"`
+
+	Log     obs.LogConfig        `yaml:"log"`
+	Tracing trace.ExporterConfig `yaml:"tracing"`
+	Breaker rt.BreakerConfig     `yaml:"breaker"`
+	LLM     answer.Config        `yaml:"llm"`
+	ASR     asr.Config           `yaml:"asr"`
+	Session ws.Options           `yaml:"session"`
+}
+
+// Default returns the settings cluelyd runs with when nothing is
+// configured.
+func Default() Config {
+	return Config{
+		Addr:            ":8080",
+		MetricsInterval: 30 * time.Second,
+		Log:             obs.DefaultLogConfig(),
+		Breaker:         rt.DefaultBreakerConfig(),
+		LLM:             answer.DefaultConfig(),
+		Session:         ws.DefaultOptions(),
+	}
+}
+
+// Validate reports every problem it finds, not just the first.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr is empty"))
+	}
+	if c.MetricsInterval < 0 {
+		errs = append(errs, fmt.Errorf("metricsInterval %s is negative", c.MetricsInterval))
+	}
+	if err := c.Log.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := trace.NewExporter(trace.ExporterConfig{Exporter: c.Tracing.Exporter}); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.LLM.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.ASR.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Session.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// SessionOptions returns the options the WebSocket handler starts sessions
+// with.
+func (c Config) SessionOptions() ws.Options {
+	opts := c.Session
+	opts.LLM = c.LLM
+	opts.ASR = c.ASR
+	return opts
+}
+
+// Redacted returns a copy fit for printing, with API keys and the log
+// redaction key masked.
+func (c Config) Redacted() Config {
+	mask := func(s *string) {
+		if *s != "" {
+			*s = "********"
+		}
+	}
+	mask(&c.Log.RedactKey)
+	mask(&c.LLM.Gemini.APIKey)
+	mask(&c.LLM.OpenAI.APIKey)
+	mask(&c.LLM.Anthropic.APIKey)
+	mask(&c.ASR.Gemini.APIKey)
+	mask(&c.ASR.Whisper.APIKey)
+	return c
+}
+
+// WriteYAML writes c in the file format Load reads.
+func (c Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Reload returns next with the fields that only take effect at startup
+// kept from cur, and names the ones that differed. Everything else applies
+// to sessions started after the reload.
+func Reload(cur, next Config) (Config, []string) {
+	var kept []string
+	keep := func(name string, changed bool) {
+		if changed {
+			kept = append(kept, name)
+		}
+	}
+	keep("addr", next.Addr != cur.Addr)
+	keep("metricsInterval", next.MetricsInterval != cur.MetricsInterval)
+	keep("log.format", next.Log.Format != cur.Log.Format)
+	keep("tracing", next.Tracing != cur.Tracing)
+	keep("breaker", next.Breaker != cur.Breaker)
+	next.Addr = cur.Addr
+	next.MetricsInterval = cur.MetricsInterval
+	next.Log.Format = cur.Log.Format
+	next.Tracing = cur.Tracing
+	next.Breaker = cur.Breaker
+	return next, kept
+}
+
+// Loader reads the configuration the same way each time it is asked, so a
+// reload sees the file's new contents under the original flags.
+type Loader struct {
+	// Path is the config file; empty means none.
+	Path string
+	// Getenv looks up environment variables. Nil means os.LookupEnv.
+	Getenv func(string) (string, bool)
+
+	flags map[string]string // flags set on the command line
+}
+
+// NewLoader parses args, the command line after the program or subcommand
+// name. -config names the file, defaulting to $CLUELYD_CONFIG.
+func NewLoader(name string, args []string) (*Loader, error) {
+	l := &Loader{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&l.Path, "config", os.Getenv("CLUELYD_CONFIG"), "config `file` (YAML or JSON)")
+	for _, f := range flagVars {
+		fs.String(f.name, "", f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	l.flags = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			l.flags[f.Name] = f.Value.String()
+		}
+	})
+	return l, nil
+}
+
+// Load layers defaults, the file, the environment and the flags, fills in
+// settings one section borrows from another, and validates the result. On
+// error the returned Config is whatever could be assembled.
+func (l *Loader) Load() (Config, error) {
+	cfg := Default()
+	if l.Path != "" {
+		if err := readFile(l.Path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	getenv := l.Getenv
+	if getenv == nil {
+		getenv = os.LookupEnv
+	}
+	var errs []error
+	for _, e := range envVars {
+		v, ok := getenv(e.name)
+		if v = strings.TrimSpace(v); !ok || v == "" {
+			continue
+		}
+		if err := e.set(&cfg, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", e.name, v, err))
+		}
+	}
+	for _, f := range flagVars {
+		if v, ok := l.flags[f.name]; ok {
+			if err := f.set(&cfg, strings.TrimSpace(v)); err != nil {
+				errs = append(errs, fmt.Errorf("-%s %q: %w", f.name, v, err))
+			}
+		}
+	}
+	cfg.fillIn()
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return cfg, errors.Join(errs...)
+}
+
+func readFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// fillIn lets ASR backends share credentials with the LLM providers of the
+// same vendor when they have none of their own.
+func (c *Config) fillIn() {
+	fill := func(dst *string, src string) {
+		if strings.TrimSpace(*dst) == "" {
+			*dst = src
+		}
+	}
+	fill(&c.ASR.Gemini.APIKey, c.LLM.Gemini.APIKey)
+	fill(&c.ASR.Gemini.Model, c.LLM.Gemini.Model)
+	fill(&c.ASR.Gemini.BaseURL, c.LLM.Gemini.BaseURL)
+	fill(&c.ASR.Whisper.APIKey, c.LLM.OpenAI.APIKey)
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cluely/server/internal/obs"
+)
+
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	l := &Loader{Getenv: env(nil)}
+	cfg, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8080" || cfg.Session.HintInterval != 1500*time.Millisecond || cfg.Session.ReadLimit != 1<<20 {
+		t.Fatalf("defaults = %+v", cfg)
+	}
+}
+
+func TestLayering(t *testing.T) {
+	path := writeFile(t, "cluelyd.yaml", `
+addr: ":9000"
+log:
+  level: debug
+llm:
+  provider: openai
+  mode: interview
+session:
+  hintInterval: 2s
+  hintTTL: 6s
+asr:
+  provider: stub
+  stub:
+    word: 100ms
+`)
+	l, err := NewLoader("cluelyd", []string{"-config", path, "-llm-provider", "rules"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Getenv = env(map[string]string{
+		"PORT":             "9100",
+		"LLM_PROVIDER":     "anthropic",
+		"ASR_STUB_WORD_MS": "50",
+		"GEMINI_API_KEY":   "k",
+		"HINT_MODE":        "",
+	})
+	cfg, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"addr from env over file", cfg.Addr, ":9100"},
+		{"log level from file", cfg.Log.Level, slog.LevelDebug},
+		{"provider from flag over env", cfg.LLM.Provider, "rules"},
+		{"mode from file, empty env ignored", cfg.LLM.Mode, "interview"},
+		{"hint interval from file", cfg.Session.HintInterval, 2 * time.Second},
+		{"stub word from env", cfg.ASR.Stub.Word, 50 * time.Millisecond},
+		{"asr gemini key borrowed from llm", cfg.ASR.Gemini.APIKey, "k"},
+		{"default kept", cfg.Session.ResumeGrace, 2 * time.Minute},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	opts := cfg.SessionOptions()
+	if opts.LLM.Provider != "rules" || opts.ASR.Provider != "stub" {
+		t.Errorf("session options did not carry llm/asr: %+v", opts)
+	}
+}
+
+func TestJSONFile(t *testing.T) {
+	path := writeFile(t, "cluelyd.json", `{"addr": ":7000", "session": {"lowConfidence": "suppress", "minConfidence": 0}}`)
+	cfg, err := (&Loader{Path: path, Getenv: env(nil)}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":7000" || cfg.Session.LowConfidence != "suppress" || *cfg.Session.MinConfidence != 0 {
+		t.Fatalf("got %+v", cfg)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	cases := []struct {
+		name, file string
+		env        map[string]string
+		want       string
+	}{
+		{"unknown key", "sesion:\n  hintTTL: 1s\n", nil, "sesion"},
+		{"bad duration", "session:\n  hintTTL: soon\n", nil, "soon"},
+		{"bad env number", "", map[string]string{"ASR_CHUNK_MS": "3s"}, "ASR_CHUNK_MS"},
+		{"unknown llm provider", "llm:\n  provider: gpt\n", nil, "gpt"},
+		{"unknown mode", "", map[string]string{"HINT_MODE": "poker"}, "poker"},
+		{"unknown asr provider", "", map[string]string{"ASR_PROVIDER": "stub,siri"}, "siri"},
+		{"bad low confidence", "session:\n  lowConfidence: hide\n", nil, "hide"},
+		{"redaction off", "log:\n  redact: off\n", nil, "debug build"},
+		{"bad exporter", "", map[string]string{"OTEL_TRACES_EXPORTER": "jaeger"}, "jaeger"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.name == "redaction off" && (obs.LogConfig{Redact: obs.RedactOff}).Validate() == nil {
+				t.Skip("debug build allows it")
+			}
+			l := &Loader{Getenv: env(c.env)}
+			if c.file != "" {
+				l.Path = writeFile(t, "cluelyd.yaml", c.file)
+			}
+			_, err := l.Load()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("err = %v, want it to mention %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestEnvCompat(t *testing.T) {
+	cfg, err := (&Loader{Getenv: env(map[string]string{
+		"ASR_VAD":             "off",
+		"HINT_MIN_CONFIDENCE": "0",
+		"ASR_STUB_TEXT":       "one | two",
+		"METRICS_INTERVAL":    "0",
+		"OPENAI_API_KEY":      "sk",
+	})}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Session.DisableVAD {
+		t.Error("ASR_VAD=off did not disable VAD")
+	}
+	if *cfg.Session.MinConfidence != 0 {
+		t.Errorf("HINT_MIN_CONFIDENCE=0 should set the threshold to 0, got %v", *cfg.Session.MinConfidence)
+	}
+	if got := strings.Join(cfg.ASR.Stub.Lines, ","); got != "one,two" {
+		t.Errorf("stub lines = %q", got)
+	}
+	if cfg.MetricsInterval != 0 {
+		t.Errorf("metrics interval = %s", cfg.MetricsInterval)
+	}
+	if cfg.ASR.Whisper.APIKey != "sk" {
+		t.Error("whisper did not borrow the OpenAI key")
+	}
+}
+
+func TestRedactedYAML(t *testing.T) {
+	cfg := Default()
+	cfg.LLM.Gemini.APIKey = "secret-gemini"
+	cfg.Log.RedactKey = "secret-key"
+	cfg.fillIn()
+	var out bytes.Buffer
+	if err := cfg.Redacted().WriteYAML(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Fatalf("secret in output:\n%s", out.String())
+	}
+	// The printed config loads back as a file.
+	path := writeFile(t, "effective.yaml", out.String())
+	if _, err := (&Loader{Path: path, Getenv: env(nil)}).Load(); err != nil {
+		t.Fatalf("printed config does not load: %v\n%s", err, out.String())
+	}
+}
+
+func TestReloadKeepsRestartOnlyFields(t *testing.T) {
+	cur := Default()
+	next := Default()
+	next.Addr = ":9999"
+	next.Breaker.Failures = 1
+	next.Log.Level = slog.LevelDebug
+	next.Session.HintTTL = time.Second
+	got, kept := Reload(cur, next)
+	if got.Addr != cur.Addr || got.Breaker != cur.Breaker {
+		t.Errorf("restart-only fields changed: %+v", got)
+	}
+	if got.Log.Level != slog.LevelDebug || got.Session.HintTTL != time.Second {
+		t.Errorf("live fields not applied: %+v", got)
+	}
+	if strings.Join(kept, ",") != "addr,breaker" {
+		t.Errorf("kept = %v", kept)
+	}
+}
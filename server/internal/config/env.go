@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"cluely/server/internal/ws"
+)
+
+// A setter parses one environment variable or flag into the config.
+type setter func(c *Config, v string) error
+
+type envVar struct {
+	name string
+	set  setter
+}
+
+// envVars are the environment overrides, applied in order after the file.
+// Empty values are ignored.
+var envVars = []envVar{
+	{"PORT", func(c *Config, v string) error {
+		if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+			return errors.New("not a port number")
+		}
+		c.Addr = ":" + v
+		return nil
+	}},
+	{"METRICS_INTERVAL", seconds(func(c *Config) *time.Duration { return &c.MetricsInterval })},
+
+	{"LOG_LEVEL", level(func(c *Config) *slog.Level { return &c.Log.Level })},
+	{"LOG_FORMAT", lower(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_REDACT", lower(func(c *Config) *string { return &c.Log.Redact })},
+	{"LOG_REDACT_KEY", str(func(c *Config) *string { return &c.Log.RedactKey })},
+
+	{"OTEL_TRACES_EXPORTER", lower(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", str(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"OTEL_SERVICE_NAME", str(func(c *Config) *string { return &c.Tracing.Service })},
+
+	{"BREAKER_FAILURES", integer(func(c *Config) *int { return &c.Breaker.Failures })},
+	{"BREAKER_OPEN_MS", millis(func(c *Config) *time.Duration { return &c.Breaker.OpenFor })},
+	{"BREAKER_PROBES", integer(func(c *Config) *int { return &c.Breaker.Probes })},
+
+	{"LLM_PROVIDER", lower(func(c *Config) *string { return &c.LLM.Provider })},
+	{"HINT_MODE", lower(func(c *Config) *string { return &c.LLM.Mode })},
+	{"LLM_LOGPROBS", boolean(func(c *Config) *bool { return &c.LLM.Logprobs })},
+	{"PROMPT_DIR", str(func(c *Config) *string { return &c.LLM.PromptDir })},
+	{"GEMINI_API_KEY", str(func(c *Config) *string { return &c.LLM.Gemini.APIKey })},
+	{"GEMINI_MODEL", str(func(c *Config) *string { return &c.LLM.Gemini.Model })},
+	{"GEMINI_BASE_URL", str(func(c *Config) *string { return &c.LLM.Gemini.BaseURL })},
+	{"OPENAI_API_KEY", str(func(c *Config) *string { return &c.LLM.OpenAI.APIKey })},
+	{"OPENAI_MODEL", str(func(c *Config) *string { return &c.LLM.OpenAI.Model })},
+	{"OPENAI_BASE_URL", str(func(c *Config) *string { return &c.LLM.OpenAI.BaseURL })},
+	{"ANTHROPIC_API_KEY", str(func(c *Config) *string { return &c.LLM.Anthropic.APIKey })},
+	{"ANTHROPIC_MODEL", str(func(c *Config) *string { return &c.LLM.Anthropic.Model })},
+	{"ANTHROPIC_BASE_URL", str(func(c *Config) *string { return &c.LLM.Anthropic.BaseURL })},
+
+	{"ASR_PROVIDER", lower(func(c *Config) *string { return &c.ASR.Provider })},
+	{"GEMINI_ASR_MODEL", str(func(c *Config) *string { return &c.ASR.Gemini.Model })},
+	{"GEMINI_ASR_BASE_URL", str(func(c *Config) *string { return &c.ASR.Gemini.BaseURL })},
+	{"WHISPER_API_KEY", str(func(c *Config) *string { return &c.ASR.Whisper.APIKey })},
+	{"WHISPER_MODEL", str(func(c *Config) *string { return &c.ASR.Whisper.Model })},
+	{"WHISPER_BASE_URL", str(func(c *Config) *string { return &c.ASR.Whisper.BaseURL })},
+	{"WHISPER_LANGUAGE", str(func(c *Config) *string { return &c.ASR.Whisper.Language })},
+	{"ASR_WS_URL", str(func(c *Config) *string { return &c.ASR.Vosk.URL })},
+	{"ASR_PCM_BUFFER", integer(func(c *Config) *int { return &c.ASR.Vosk.Buffer })},
+	{"ASR_STUB_SCRIPT", str(func(c *Config) *string { return &c.ASR.Stub.Script })},
+	{"ASR_STUB_TEXT", func(c *Config, v string) error {
+		c.ASR.Stub.Lines = nil
+		for _, line := range strings.Split(v, "|") {
+			if line = strings.TrimSpace(line); line != "" {
+				c.ASR.Stub.Lines = append(c.ASR.Stub.Lines, line)
+			}
+		}
+		return nil
+	}},
+	{"ASR_STUB_PACE", lower(func(c *Config) *string { return &c.ASR.Stub.Pace })},
+	{"ASR_STUB_WORD_MS", millis(func(c *Config) *time.Duration { return &c.ASR.Stub.Word })},
+	{"ASR_STUB_GAP_MS", millis(func(c *Config) *time.Duration { return &c.ASR.Stub.Gap })},
+	{"ASR_STUB_LOOP", boolean(func(c *Config) *bool { return &c.ASR.Stub.Loop })},
+	{"ASR_REPLAY_FILE", str(func(c *Config) *string { return &c.ASR.ReplayFile })},
+	{"ASR_CHUNK_MS", millis(func(c *Config) *time.Duration { return &c.ASR.Chunk.Chunk })},
+	{"ASR_CHUNK_OVERLAP_MS", millis(func(c *Config) *time.Duration { return &c.ASR.Chunk.Overlap })},
+	{"ASR_SILENCE_MS", millis(func(c *Config) *time.Duration { return &c.ASR.Chunk.Silence })},
+	{"ASR_MAX_BUFFER_MS", millis(func(c *Config) *time.Duration { return &c.ASR.Chunk.MaxBuffer })},
+
+	{"ASR_VAD", func(c *Config, v string) error {
+		on, err := parseBool(v)
+		c.Session.DisableVAD = !on
+		return err
+	}},
+	{"HINT_MIN_CONFIDENCE", func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.New("not a number")
+		}
+		c.Session.MinConfidence = ws.Threshold(f)
+		return nil
+	}},
+	{"HINT_LOW_CONFIDENCE", lower(func(c *Config) *string { return &c.Session.LowConfidence })},
+}
+
+type flagVar struct {
+	name, usage string
+	set         setter
+}
+
+// flagVars are the command-line overrides, for what is most often changed
+// from one run to the next. Only flags given on the command line apply.
+var flagVars = []flagVar{
+	{"addr", "listen `address`, e.g. :8080", str(func(c *Config) *string { return &c.Addr })},
+	{"log-level", "log `level`: debug, info, warn or error", level(func(c *Config) *slog.Level { return &c.Log.Level })},
+	{"llm-provider", "hint engine `provider`", lower(func(c *Config) *string { return &c.LLM.Provider })},
+	{"hint-mode", "default coaching `mode`", lower(func(c *Config) *string { return &c.LLM.Mode })},
+	{"asr-provider", "speech recognizer `provider`s, comma-separated", lower(func(c *Config) *string { return &c.ASR.Provider })},
+}
+
+func str(field func(*Config) *string) setter {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func lower(field func(*Config) *string) setter {
+	return func(c *Config, v string) error {
+		*field(c) = strings.ToLower(v)
+		return nil
+	}
+}
+
+func integer(field func(*Config) *int) setter {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) setter {
+	return func(c *Config, v string) error {
+		b, err := parseBool(v)
+		*field(c) = b
+		return err
+	}
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return true, nil
+	case "0", "false", "no", "off":
+		return false, nil
+	}
+	return false, errors.New("not on or off")
+}
+
+// millis and seconds read the plain numbers the environment has always
+// used for durations.
+func millis(field func(*Config) *time.Duration) setter {
+	return duration(field, time.Millisecond)
+}
+
+func seconds(field func(*Config) *time.Duration) setter {
+	return duration(field, time.Second)
+}
+
+func duration(field func(*Config) *time.Duration, unit time.Duration) setter {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("not a whole number")
+		}
+		*field(c) = time.Duration(n) * unit
+		return nil
+	}
+}
+
+func level(field func(*Config) *slog.Level) setter {
+	return func(c *Config, v string) error {
+		return field(c).UnmarshalText([]byte(v))
+	}
+}
@@ -0,0 +1,155 @@
+package obs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// How customer content (transcripts, OCR tokens, hint text) appears in logs.
+const (
+	// RedactOmit logs only the length.
+	RedactOmit = "omit"
+	// RedactHash logs the length and a keyed hash, so the same text can be
+	// matched across log lines without being readable.
+	RedactHash = "hash"
+	// RedactOff logs the text itself. Only builds tagged debug allow it.
+	RedactOff = "off"
+)
+
+// LogConfig is the logging setup.
+type LogConfig struct {
+	Level slog.Level `yaml:"level"`
+	// Format is "text" (key=value, the default) or "json".
+	Format string `yaml:"format"`
+	// Redact is RedactHash (default), RedactOmit or RedactOff.
+	Redact string `yaml:"redact"`
+	// RedactKey keys the RedactHash hash. Empty picks a random key, so
+	// hashes only match within one process.
+	RedactKey string `yaml:"redactKey"`
+}
+
+// DefaultLogConfig returns the production logging settings.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{Level: slog.LevelInfo, Format: "text", Redact: RedactHash}
+}
+
+// Validate reports an unknown format or redaction policy, and RedactOff
+// outside a debug build, so a typo never turns redaction off.
+func (c LogConfig) Validate() error {
+	var errs []error
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log format %q is not text or json", c.Format))
+	}
+	switch c.Redact {
+	case "", RedactHash, RedactOmit:
+	case RedactOff:
+		if !plaintextLogs {
+			errs = append(errs, errors.New("log redaction off needs a debug build (go build -tags debug)"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("log redaction %q is not hash, omit or off", c.Redact))
+	}
+	return errors.Join(errs...)
+}
+
+// NewLogger returns a logger writing to w as cfg says.
+func NewLogger(w io.Writer, cfg LogConfig) *slog.Logger {
+	return newLogger(w, cfg.Format, cfg.Level)
+}
+
+func newLogger(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// logLevel is the default logger's level, so a reload can change it.
+var logLevel slog.LevelVar
+
+// SetupLogging applies cfg's redaction policy and makes a logger for cfg on
+// stderr the default. Output of the log package goes through it too.
+func SetupLogging(cfg LogConfig) {
+	SetRedaction(cfg.Redact, cfg.RedactKey)
+	logLevel.Set(cfg.Level)
+	slog.SetDefault(newLogger(os.Stderr, cfg.Format, &logLevel))
+}
+
+// SetLogLevel changes the level of the logger SetupLogging installed,
+// including loggers already derived from it.
+func SetLogLevel(l slog.Level) { logLevel.Set(l) }
+
+// plaintextLogs allows RedactOff; see log_debug.go.
+var plaintextLogs = false
+
+var redaction struct {
+	mu     sync.RWMutex
+	policy string
+	key    []byte
+}
+
+func init() { SetRedaction(RedactHash, "") }
+
+// SetRedaction sets how Redact values are logged. An unknown policy, or
+// RedactOff outside a debug build, is taken as RedactHash.
+func SetRedaction(policy, key string) {
+	if policy != RedactOmit && !(policy == RedactOff && plaintextLogs) {
+		policy = RedactHash
+	}
+	k := []byte(key)
+	if len(k) == 0 {
+		k = make([]byte, 32)
+		_, _ = rand.Read(k)
+	}
+	redaction.mu.Lock()
+	redaction.policy, redaction.key = policy, k
+	redaction.mu.Unlock()
+}
+
+// Redact wraps customer content for logging. It resolves when the line is
+// written: a group with the text's length and, under RedactHash, a short
+// keyed hash; the text itself only under RedactOff.
+func Redact(text string) slog.LogValuer { return redacted(text) }
+
+// RedactAll is Redact for a list such as OCR tokens, joined by spaces. The
+// count is logged too.
+func RedactAll(texts []string) slog.LogValuer { return redactedList(texts) }
+
+type redacted string
+
+func (r redacted) LogValue() slog.Value {
+	redaction.mu.RLock()
+	policy, key := redaction.policy, redaction.key
+	redaction.mu.RUnlock()
+	switch policy {
+	case RedactOff:
+		return slog.StringValue(string(r))
+	case RedactOmit:
+		return slog.GroupValue(slog.Int("len", len(r)))
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(r))
+	return slog.GroupValue(slog.Int("len", len(r)), slog.String("hash", hex.EncodeToString(mac.Sum(nil)[:8])))
+}
+
+type redactedList []string
+
+func (r redactedList) LogValue() slog.Value {
+	v := redacted(strings.Join(r, " ")).LogValue()
+	if v.Kind() != slog.KindGroup {
+		return v
+	}
+	return slog.GroupValue(append([]slog.Attr{slog.Int("n", len(r))}, v.Group()...)...)
+}
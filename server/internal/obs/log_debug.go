@@ -0,0 +1,7 @@
+//go:build debug
+
+package obs
+
+// Building with -tags debug allows LOG_REDACT=off, which writes transcripts,
+// OCR and hints to the log in full. Never ship such a build.
+func init() { plaintextLogs = true }
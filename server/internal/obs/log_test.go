@@ -0,0 +1,66 @@
+package obs
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogConfigValidate(t *testing.T) {
+	if err := DefaultLogConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	err := LogConfig{Format: "xml", Redact: "none"}.Validate()
+	if err == nil || !strings.Contains(err.Error(), "xml") || !strings.Contains(err.Error(), "none") {
+		t.Fatalf("got %v, want both fields reported", err)
+	}
+	if err := (LogConfig{Redact: RedactOff}).Validate(); plaintextLogs != (err == nil) {
+		t.Fatalf("debug build %v: redaction off gave %v", plaintextLogs, err)
+	}
+}
+
+func TestRedactKeepsTextOutOfLogs(t *testing.T) {
+	t.Cleanup(func() { SetRedaction(RedactHash, "") })
+	const secret = "our budget is 40k"
+	line := func() map[string]any {
+		var buf bytes.Buffer
+		NewLogger(&buf, LogConfig{Format: "json"}).Info("msg", "text", Redact(secret), "ocr", RedactAll([]string{"acme", "q3"}))
+		if strings.Contains(buf.String(), "budget") || strings.Contains(buf.String(), "acme") {
+			t.Fatalf("text leaked: %s", buf.String())
+		}
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	SetRedaction(RedactHash, "k")
+	m := line()
+	text := m["text"].(map[string]any)
+	if text["len"] != float64(len(secret)) || len(text["hash"].(string)) != 16 {
+		t.Errorf("hashed text %v", text)
+	}
+	if ocr := m["ocr"].(map[string]any); ocr["n"] != float64(2) || ocr["hash"] == nil {
+		t.Errorf("hashed ocr %v", ocr)
+	}
+	if again := line()["text"].(map[string]any); again["hash"] != text["hash"] {
+		t.Errorf("same text hashed to %v and %v", text["hash"], again["hash"])
+	}
+	SetRedaction(RedactHash, "other")
+	if other := line()["text"].(map[string]any); other["hash"] == text["hash"] {
+		t.Error("hash does not depend on the key")
+	}
+
+	SetRedaction(RedactOmit, "")
+	if text := line()["text"].(map[string]any); len(text) != 1 || text["len"] != float64(len(secret)) {
+		t.Errorf("omitted text %v", text)
+	}
+
+	// Outside a debug build, off is not honoured.
+	if !plaintextLogs {
+		SetRedaction(RedactOff, "")
+		line()
+	}
+}
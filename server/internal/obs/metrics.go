@@ -1,48 +1,178 @@
 package obs
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"sync/atomic"
+	"net"
+	"regexp"
+	"strings"
 	"time"
+
+	"cluely/server/internal/rt"
+)
+
+// Pipeline stages for error counts.
+const (
+	StageASR   = "asr"
+	StageLLM   = "llm"
+	StageAudio = "audio"
 )
 
-// Simple in-memory metrics (replace with OTel later)
+// Metrics live in Default and are served on /metrics; LogMetrics prints a
+// summary of them.
 var (
-	SessionsActive     int64
-	SessionsTotal      int64
-	PCMFramesReceived  int64
-	PCMFramesDropped   int64
-	ASRPartialsRecv    int64
-	ASRFinalsRecv      int64
-	HintsSent          int64
-	FollowupsSent      int64
-	ErrorsASR          int64
-	ErrorsAnswer       int64
+	sessionsActive = NewGauge("cluely_sessions_active", "Sessions currently open.")
+	sessionsTotal  = NewCounter("cluely_sessions_total", "Sessions opened.")
+	pcmFrames      = NewCounterVec("cluely_pcm_frames_total", "Audio frames received, and dropped under backpressure.", "result")
+	audioBytes     = NewCounterVec("cluely_audio_bytes_total", "Compressed audio received and the PCM decoded from it.", "kind")
+	asrTranscripts = NewCounterVec("cluely_asr_transcripts_total", "Transcripts relayed to clients.", "kind")
+	asrFailovers   = NewCounter("cluely_asr_failovers_total", "Switches to the next ASR provider in the chain.")
+	hintsSent      = NewCounter("cluely_hints_sent_total", "Hints sent, muted ones included.")
+	hintsLow       = NewCounterVec("cluely_hints_low_confidence_total", "Hints scored below the confidence threshold.", "action")
+	followupsSent  = NewCounter("cluely_followups_sent_total", "Follow-up questions sent.")
+	errorsTotal    = NewCounterVec("cluely_errors_total", "Errors by pipeline stage, provider and class.", "stage", "provider", "class")
+	retries        = NewCounterVec("cluely_retries_total", "HTTP retries after a transient failure.", "stage")
+	breakerOpens   = NewCounterVec("cluely_breaker_opens_total", "Upstream circuit breaker trips.", "upstream")
+	breakerState   = NewGaugeVec("cluely_breaker_state", "Upstream circuit breaker state: 0 closed, 1 open, 2 half-open.", "upstream")
+
+	// ASRLatency is how long a provider takes to return a transcript: a
+	// chunk request, or from end of speech to the final for streaming ones.
+	ASRLatency = NewHistogramVec("cluely_asr_latency_seconds", "ASR request, or end of speech to final, latency.", LatencyBuckets, "provider")
+	// LLMLatency is one generation call, retries included.
+	LLMLatency = NewHistogramVec("cluely_llm_latency_seconds", "LLM generation latency.", LatencyBuckets, "provider", "task", "outcome")
+	// HintLatency runs from the final transcript to the hint on glass.
+	HintLatency = NewHistogramVec("cluely_hint_latency_seconds", "Final transcript to hint sent.", LatencyBuckets, "provider", "mode")
+	// UtteranceLatency measures each stage of an utterance from the end of
+	// speech: final, first_token, first_partial and hint.
+	UtteranceLatency = NewHistogramVec("cluely_utterance_latency_seconds", "End of speech to each stage of the hint pipeline.", LatencyBuckets, "stage")
 )
 
-func IncSessionActive() { atomic.AddInt64(&SessionsActive, 1); atomic.AddInt64(&SessionsTotal, 1) }
-func DecSessionActive() { atomic.AddInt64(&SessionsActive, -1) }
-func IncPCMFrame()      { atomic.AddInt64(&PCMFramesReceived, 1) }
-func IncASRPartial()    { atomic.AddInt64(&ASRPartialsRecv, 1) }
-func IncASRFinal()      { atomic.AddInt64(&ASRFinalsRecv, 1) }
-func IncHint()          { atomic.AddInt64(&HintsSent, 1) }
-func IncFollowup()      { atomic.AddInt64(&FollowupsSent, 1) }
-func IncErrorASR()      { atomic.AddInt64(&ErrorsASR, 1) }
-func IncErrorAnswer()   { atomic.AddInt64(&ErrorsAnswer, 1) }
-func IncPCMFrameDrop()  { atomic.AddInt64(&PCMFramesDropped, 1) }
+func IncSessionActive()  { sessionsActive.Add(1); sessionsTotal.Inc() }
+func DecSessionActive()  { sessionsActive.Add(-1) }
+func IncPCMFrame()       { pcmFrames.With("received").Inc() }
+func IncASRPartial()     { asrTranscripts.With("partial").Inc() }
+func IncASRFinal()       { asrTranscripts.With("final").Inc() }
+func IncHint()           { hintsSent.Inc() }
+func IncHintMuted()      { hintsLow.With("muted").Inc() }
+func IncHintSuppressed() { hintsLow.With("suppressed").Inc() }
+func IncFollowup()       { followupsSent.Inc() }
+func IncASRFailover()    { asrFailovers.Inc() }
+func IncRetryASR()       { retries.With(StageASR).Inc() }
+func IncRetryAnswer()    { retries.With(StageLLM).Inc() }
+func IncPCMFrameDrop()   { pcmFrames.With("dropped").Inc() }
+
+// AddAudioDecoded records compressed input and the PCM it decoded to.
+func AddAudioDecoded(compressed, decoded int) {
+	audioBytes.With("compressed").Add(float64(compressed))
+	audioBytes.With("decoded").Add(float64(decoded))
+}
+
+// Error counts a failure at stage, labelled with the provider and the
+// ErrorClass of err.
+func Error(stage, provider string, err error) {
+	errorsTotal.With(stage, provider, ErrorClass(err)).Inc()
+}
+
+var httpStatusRE = regexp.MustCompile(`\bhttp (\d)\d\d\b`)
+
+// ErrorClass buckets an error for metrics: timeout, canceled, breaker_open,
+// rate_limited, upstream_4xx, upstream_5xx, network, bad_response or other.
+func ErrorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "none"
+	case errors.Is(err, rt.ErrBreakerOpen):
+		return "breaker_open"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "http 429") || strings.Contains(msg, "RESOURCE_EXHAUSTED") {
+		return "rate_limited"
+	}
+	if m := httpStatusRE.FindStringSubmatch(msg); m != nil {
+		if m[1] == "5" {
+			return "upstream_5xx"
+		}
+		return "upstream_4xx"
+	}
+	switch {
+	case errors.As(err, &netErr), strings.Contains(msg, "execute request"):
+		return "network"
+	case strings.Contains(msg, "decode"), strings.Contains(msg, "JSON"), strings.Contains(msg, "empty"):
+		return "bad_response"
+	}
+	return "other"
+}
+
+// SetBreakerState records an upstream breaker's new state and counts trips.
+func SetBreakerState(name, state string) {
+	var v float64
+	switch state {
+	case "open":
+		v = 1
+		breakerOpens.With(name).Inc()
+	case "half_open":
+		v = 2
+	}
+	breakerState.With(name).Set(v)
+}
+
+// BreakerStates returns "name=state" for every breaker seen, sorted.
+func BreakerStates() []string {
+	var out []string
+	breakerState.each(func(values []string, g *Gauge) {
+		state := "closed"
+		switch g.Value() {
+		case 1:
+			state = "open"
+		case 2:
+			state = "half_open"
+		}
+		out = append(out, fmt.Sprintf("%s=%s", values[0], state))
+	})
+	return out
+}
+
+// sum adds up the counters in v whose first label value is first, or all
+// of them when first is empty.
+func sum(v *CounterVec, first string) int64 {
+	var total float64
+	v.each(func(values []string, c *Counter) {
+		if first == "" || values[0] == first {
+			total += c.Value()
+		}
+	})
+	return int64(total)
+}
 
 // LogMetrics prints current metrics (call periodically)
 func LogMetrics() {
-log.Printf("[metrics] sessions=%d pcm(in=%d drop=%d) asr(p=%d f=%d) hints=%d followups=%d errors(asr=%d ans=%d)",
-		atomic.LoadInt64(&SessionsActive),
-		atomic.LoadInt64(&PCMFramesReceived),
-		atomic.LoadInt64(&PCMFramesDropped),
-		atomic.LoadInt64(&ASRPartialsRecv),
-		atomic.LoadInt64(&ASRFinalsRecv),
-		atomic.LoadInt64(&HintsSent),
-		atomic.LoadInt64(&FollowupsSent),
-		atomic.LoadInt64(&ErrorsASR),
-		atomic.LoadInt64(&ErrorsAnswer),
+log.Printf("[metrics] sessions=%d pcm(in=%d drop=%d) opus(in=%dB pcm=%dB) asr(p=%d f=%d failover=%d) hints=%d(muted=%d suppressed=%d) followups=%d errors(asr=%d ans=%d audio=%d) retries(asr=%d ans=%d) breakers(opens=%d %s)",
+		int64(sessionsActive.Value()),
+		sum(pcmFrames, "received"),
+		sum(pcmFrames, "dropped"),
+		sum(audioBytes, "compressed"),
+		sum(audioBytes, "decoded"),
+		sum(asrTranscripts, "partial"),
+		sum(asrTranscripts, "final"),
+		int64(asrFailovers.Value()),
+		int64(hintsSent.Value()),
+		sum(hintsLow, "muted"),
+		sum(hintsLow, "suppressed"),
+		int64(followupsSent.Value()),
+		sum(errorsTotal, StageASR),
+		sum(errorsTotal, StageLLM),
+		sum(errorsTotal, StageAudio),
+		sum(retries, StageASR),
+		sum(retries, StageLLM),
+		sum(breakerOpens, ""),
+		strings.Join(BreakerStates(), " "),
 	)
 }
 
@@ -0,0 +1,331 @@
+package obs
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registry holds metric families and renders them in the Prometheus text
+// exposition format (version 0.0.4).
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// family is one named metric with all its label combinations.
+type family interface {
+	describe() (name, help, typ string)
+	write(w io.Writer)
+}
+
+// Default is the registry cluelyd serves on /metrics.
+var Default = NewRegistry()
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]family{}}
+}
+
+func (r *Registry) register(f family) {
+	name, _, _ := f.describe()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.families[name]; dup {
+		panic("obs: metric " + name + " registered twice")
+	}
+	r.families[name] = f
+}
+
+// WriteText writes every family, sorted by name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	fams := r.families
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		f := fams[name]
+		_, help, typ := f.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+		f.write(w)
+	}
+}
+
+// Handler serves the registry in Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// vec keeps one child per label value combination.
+type vec[T any] struct {
+	name, help string
+	labels     []string
+	newChild   func() *T
+
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func newVec[T any](name, help string, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{name: name, help: help, labels: labels, newChild: newChild,
+		children: map[string]*T{}, values: map[string][]string{}}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("obs: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[key]
+	if !ok {
+		c = v.newChild()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// each calls fn for every child, sorted by label values.
+func (v *vec[T]) each(fn func(values []string, c *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		values []string
+		c      *T
+	}
+	entries := make([]entry, len(keys))
+	for i, k := range keys {
+		entries[i] = entry{v.values[k], v.children[k]}
+	}
+	v.mu.Unlock()
+	for _, e := range entries {
+		fn(e.values, e.c)
+	}
+}
+
+// value is a float64 updated atomically.
+type value struct{ bits atomic.Uint64 }
+
+func (v *value) add(d float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(x float64) { v.bits.Store(math.Float64bits(x)) }
+func (v *value) get() float64  { return math.Float64frombits(v.bits.Load()) }
+
+// Counter only goes up.
+type Counter struct{ v value }
+
+func (c *Counter) Inc()           { c.v.add(1) }
+func (c *Counter) Add(d float64)  { c.v.add(d) }
+func (c *Counter) Value() float64 { return c.v.get() }
+
+// CounterVec is a counter family partitioned by labels.
+type CounterVec struct{ *vec[Counter] }
+
+// NewCounterVec registers a counter family in Default.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, labels, func() *Counter { return &Counter{} })}
+	Default.register(v)
+	return v
+}
+
+// NewCounter registers an unlabelled counter in Default.
+func NewCounter(name, help string) *Counter { return NewCounterVec(name, help).With() }
+
+// With returns the counter for the given label values, in label order.
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) describe() (string, string, string) { return v.name, v.help, "counter" }
+
+func (v *CounterVec) write(w io.Writer) {
+	v.each(func(values []string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, values), formatFloat(c.Value()))
+	})
+}
+
+// Gauge goes up and down.
+type Gauge struct{ v value }
+
+func (g *Gauge) Set(x float64)  { g.v.set(x) }
+func (g *Gauge) Add(d float64)  { g.v.add(d) }
+func (g *Gauge) Value() float64 { return g.v.get() }
+
+// GaugeVec is a gauge family partitioned by labels.
+type GaugeVec struct{ *vec[Gauge] }
+
+// NewGaugeVec registers a gauge family in Default.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, labels, func() *Gauge { return &Gauge{} })}
+	Default.register(v)
+	return v
+}
+
+// NewGauge registers an unlabelled gauge in Default.
+func NewGauge(name, help string) *Gauge { return NewGaugeVec(name, help).With() }
+
+// With returns the gauge for the given label values, in label order.
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) describe() (string, string, string) { return v.name, v.help, "gauge" }
+
+func (v *GaugeVec) write(w io.Writer) {
+	v.each(func(values []string, g *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, values), formatFloat(g.Value()))
+	})
+}
+
+// LatencyBuckets suit request latencies from tens of milliseconds to the
+// longest upstream timeouts, in seconds.
+var LatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5, 8, 12}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64 // per bucket, not cumulative; last is +Inf
+	sum    value
+	count  atomic.Uint64
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(x float64) {
+	i := sort.SearchFloat64s(h.upper, x)
+	h.counts[i].Add(1)
+	h.sum.add(x)
+	h.count.Add(1)
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+// ObserveSince records the time elapsed since start, in seconds.
+func (h *Histogram) ObserveSince(start time.Time) { h.ObserveDuration(time.Since(start)) }
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+// HistogramVec is a histogram family partitioned by labels.
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram family in Default. buckets are
+// upper bounds in increasing order; +Inf is implied.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	v := &HistogramVec{}
+	v.vec = newVec(name, help, labels, func() *Histogram {
+		return &Histogram{upper: b, counts: make([]atomic.Uint64, len(b)+1)}
+	})
+	Default.register(v)
+	return v
+}
+
+// With returns the histogram for the given label values, in label order.
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) describe() (string, string, string) { return v.name, v.help, "histogram" }
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.each(func(values []string, h *Histogram) {
+		labels := formatLabels(v.labels, values)
+		var cum uint64
+		for i := range h.counts {
+			cum += h.counts[i].Load()
+			le := "+Inf"
+			if i < len(h.upper) {
+				le = formatFloat(h.upper[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, withLabel(labels, "le", le), cum)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels, formatFloat(h.sum.get()))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels, cum)
+	})
+}
+
+// funcFamily reports a value read at scrape time, for state kept elsewhere.
+type funcFamily struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value fn returns at scrape time.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(&funcFamily{name, help, "gauge", fn})
+}
+
+func (f *funcFamily) describe() (string, string, string) { return f.name, f.help, f.typ }
+func (f *funcFamily) write(w io.Writer)                  { fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn())) }
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(n)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// withLabel adds name="value" to an already formatted label set.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,70 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cluely/server/internal/rt"
+)
+
+func TestRegistryWritesPrometheusText(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests by \"code\".\nSecond line.", "code", "path")
+	c.With("200", `/a"b`).Add(2)
+	c.With("500", "/").Inc()
+	h := NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "provider")
+	h.With("gemini").Observe(0.05)
+	h.With("gemini").Observe(0.5)
+	h.With("gemini").Observe(3)
+	g := NewGauge("test_inflight", "In flight.")
+	g.Set(4)
+
+	rec := httptest.NewRecorder()
+	Default.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# HELP test_requests_total Requests by \"code\".\\nSecond line.\n# TYPE test_requests_total counter\n" +
+			"test_requests_total{code=\"200\",path=\"/a\\\"b\"} 2\n" +
+			"test_requests_total{code=\"500\",path=\"/\"} 1\n",
+		"# TYPE test_latency_seconds histogram\n" +
+			"test_latency_seconds_bucket{provider=\"gemini\",le=\"0.1\"} 1\n" +
+			"test_latency_seconds_bucket{provider=\"gemini\",le=\"1\"} 2\n" +
+			"test_latency_seconds_bucket{provider=\"gemini\",le=\"+Inf\"} 3\n" +
+			"test_latency_seconds_sum{provider=\"gemini\"} 3.55\n" +
+			"test_latency_seconds_count{provider=\"gemini\"} 3\n",
+		"# TYPE test_inflight gauge\ntest_inflight 4\n",
+		"# TYPE cluely_hint_latency_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition missing:\n%s\nfull output:\n%s", want, body)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&rt.OpenError{Name: "llm:gemini"}, "breaker_open"},
+		{fmt.Errorf("execute request: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{errors.New("gemini http 429: quota"), "rate_limited"},
+		{errors.New("openai http 503: overloaded"), "upstream_5xx"},
+		{errors.New("gemini http 400: bad key"), "upstream_4xx"},
+		{errors.New("execute request: dial tcp: connection refused"), "network"},
+		{errors.New("decode response: unexpected EOF"), "bad_response"},
+		{errors.New("something else"), "other"},
+	}
+	for _, tc := range cases {
+		if got := ErrorClass(tc.err); got != tc.want {
+			t.Errorf("ErrorClass(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits on upstream payloads. Anything larger is a client bug.
+const (
+	maxOCRTokens     = 512
+	maxTranscriptLen = 8 << 10
+)
+
+// ProtocolError describes why an upstream message was rejected. Reply turns
+// it into the Error message sent back to the client.
+type ProtocolError struct {
+	Code string
+	Msg  string
+}
+
+func (e *ProtocolError) Error() string { return e.Code + ": " + e.Msg }
+
+// Reply returns the downstream Error for e.
+func (e *ProtocolError) Reply() Error { return NewError(e.Code, e.Msg) }
+
+func badMessage(format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: CodeBadMessage, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Decode parses and validates one upstream text frame. Unknown types, unknown
+// fields and missing required fields are rejected with a *ProtocolError.
+func Decode(data []byte) (Message, error) {
+	var env struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, badMessage("invalid JSON: %v", err)
+	}
+	var (
+		msg Message
+		err error
+	)
+	switch env.Type {
+	case TypeHello:
+		var m Hello
+		err = strictUnmarshal(data, &m)
+		if err == nil && m.Protocol < 0 {
+			err = badMessage("hello.protocol must be positive")
+		}
+		msg = m
+	case TypeFrameMeta:
+		var m FrameMeta
+		err = strictUnmarshal(data, &m)
+		if err == nil && len(m.OCR) > maxOCRTokens {
+			err = badMessage("frame_meta.ocr has %d tokens (max %d)", len(m.OCR), maxOCRTokens)
+		}
+		msg = m
+	case TypeStop:
+		var m Stop
+		err = strictUnmarshal(data, &m)
+		msg = m
+	case TypeTranscript:
+		var m Transcript
+		err = strictUnmarshal(data, &m)
+		if err == nil {
+			switch {
+			case strings.TrimSpace(m.Text) == "":
+				err = badMessage("transcript.text is required")
+			case len(m.Text) > maxTranscriptLen:
+				err = badMessage("transcript.text exceeds %d bytes", maxTranscriptLen)
+			}
+		}
+		msg = m
+	case "":
+		return nil, badMessage("missing type")
+	default:
+		return nil, &ProtocolError{Code: CodeUnknownType, Msg: fmt.Sprintf("unknown message type %q", env.Type)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func strictUnmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badMessage("%v", err)
+	}
+	return nil
+}
+
+// Encode marshals a downstream message. A non-zero seq is stamped onto the
+// object as "seq" so a resuming client can say what it has already seen.
+func Encode(msg Message, seq uint64) ([]byte, error) {
+	b, err := json.Marshal(msg)
+	if err != nil || seq == 0 {
+		return b, err
+	}
+	if len(b) < 2 || b[0] != '{' {
+		return nil, fmt.Errorf("protocol: %s does not encode to an object", msg.MessageType())
+	}
+	out := make([]byte, 0, len(b)+24)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendUint(out, seq, 10)
+	if len(b) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, b[1:]...), nil
+}
@@ -0,0 +1,279 @@
+// Package protocol defines the WebSocket messages exchanged between the
+// headset client and cluelyd. Every text frame is a JSON object whose "type"
+// field selects one of the structs below; binary frames carry audio.
+package protocol
+
+//go:generate go run ../../cmd/protoschema -o schema.json
+
+// Protocol versions this server speaks. Clients announce theirs in hello;
+// clients that predate versioning omit it and are treated as version 1.
+const (
+	Version    = 1
+	MinVersion = 1
+)
+
+// Upstream (client -> server) message types.
+const (
+	TypeHello      = "hello"
+	TypeFrameMeta  = "frame_meta"
+	TypeStop       = "stop"
+	TypeTranscript = "transcript"
+)
+
+// Downstream (server -> client) message types.
+const (
+	TypeState           = "state"
+	TypePartial         = "partial"
+	TypeFinal           = "final"
+	TypeHintPartial     = "hint_partial"
+	TypeHint            = "hint"
+	TypeFollowupPartial = "followup_partial"
+	TypeFollowup        = "followup"
+	TypeHintCancel      = "hint_cancel"
+	TypeVAD             = "vad"
+	TypeTiming          = "timing"
+	TypeWarning         = "warning"
+	TypeError           = "error"
+)
+
+// Error codes sent in Error messages.
+const (
+	CodeBadMessage         = "BAD_MESSAGE"
+	CodeUnknownType        = "UNKNOWN_TYPE"
+	CodeUnsupportedVersion = "UNSUPPORTED_VERSION"
+	CodeResumeFailed       = "RESUME_FAILED"
+	CodeUnsupportedAudio   = "UNSUPPORTED_AUDIO"
+	CodeASRUnavailable     = "ASR_UNAVAILABLE"
+	CodeASRDegraded        = "ASR_DEGRADED" // a Warning: the next ASR provider took over
+	CodeLLMUnavailable     = "LLM_UNAVAILABLE"
+)
+
+// Message is implemented by every up- and downstream message.
+type Message interface {
+	MessageType() string
+}
+
+// Hello opens (or re-opens) a session and negotiates the protocol version.
+// A reconnecting client sets Resume to the token from its last State and
+// LastSeq to the highest seq it received; missed messages are replayed.
+// Audio declares the binary PCM format; without it 16 kHz mono s16le is
+// assumed. Mode picks the coaching persona ("sales", "interview", "support",
+// "lecture"); without it the server default applies. Timing asks for a
+// Timing message after each hint.
+type Hello struct {
+	Type     string       `json:"type"`
+	App      string       `json:"app,omitempty"`
+	Ver      string       `json:"ver,omitempty"`
+	Protocol int          `json:"protocol,omitempty"`
+	Resume   string       `json:"resume,omitempty"`
+	LastSeq  uint64       `json:"lastSeq,omitempty"`
+	Audio    *AudioFormat `json:"audio,omitempty"`
+	Mode     string       `json:"mode,omitempty"`
+	Timing   bool         `json:"timing,omitempty"`
+}
+
+// AudioFormat describes interleaved PCM frames sent as binary messages.
+// Omitted fields keep the 16 kHz mono s16le defaults. Encoding is one of
+// s16le, f32le or mulaw.
+type AudioFormat struct {
+	SampleRate int    `json:"sampleRate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	Encoding   string `json:"encoding,omitempty"`
+}
+
+// FrameMeta carries OCR tokens from the current camera frame. First marks the
+// frame that opened the session context, Last the frame currently in view.
+type FrameMeta struct {
+	Type  string   `json:"type"`
+	OCR   []string `json:"ocr"`
+	First bool     `json:"first,omitempty"`
+	Last  bool     `json:"last,omitempty"`
+}
+
+// Stop ends listening and flushes buffered audio.
+type Stop struct {
+	Type string `json:"type"`
+}
+
+// Transcript lets a client supply text directly instead of audio. Speaker is
+// an optional label ("me", "customer") kept in the conversation memory.
+type Transcript struct {
+	Type    string `json:"type"`
+	Text    string `json:"text"`
+	Final   bool   `json:"final,omitempty"`
+	Speaker string `json:"speaker,omitempty"`
+	// Confidence is the client recognizer's confidence in a final, 0..1.
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// State reports whether the server is listening. The first State on a
+// connection carries the session ID and the token needed to resume it.
+type State struct {
+	Type      string `json:"type"`
+	Listening bool   `json:"listening"`
+	Protocol  int    `json:"protocol,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	Resume    string `json:"resume,omitempty"`
+	Resumed   bool   `json:"resumed,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+}
+
+// Partial is an interim transcript.
+type Partial struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Final is a settled transcript for one utterance.
+type Final struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// HintPartial is the coaching hint as generated so far.
+type HintPartial struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Hint is the complete coaching hint, shown for TTLMs.
+type Hint struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	TTLMs int    `json:"ttlMs"`
+	// Confidence is the server's 0..1 estimate that the hint fits. Muted
+	// hints scored below the session threshold; clients show them
+	// subdued, or not at all.
+	Confidence float64 `json:"confidence,omitempty"`
+	Muted      bool    `json:"muted,omitempty"`
+	// TraceID names the server-side trace that produced the hint, for
+	// reporting a slow one.
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// FollowupPartial is the follow-up question as generated so far.
+type FollowupPartial struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Followup is the complete follow-up question, shown for TTLMs.
+type Followup struct {
+	Type       string  `json:"type"`
+	Text       string  `json:"text"`
+	TTLMs      int     `json:"ttlMs"`
+	Confidence float64 `json:"confidence,omitempty"`
+	Muted      bool    `json:"muted,omitempty"`
+}
+
+// HintCancel retracts any hint or follow-up partials still on glass: their
+// generation was superseded by a newer final or stopped.
+type HintCancel struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// VAD reports the server's voice activity detector flipping between speech
+// and silence, so the UI can show who is talking before any text arrives.
+type VAD struct {
+	Type     string `json:"type"`
+	Speaking bool   `json:"speaking"`
+}
+
+// Timing breaks down how long one utterance took to become a hint, in
+// milliseconds from the end of speech: the last audio frame sent to ASR, or
+// for a client transcript its arrival. Source is "asr" or "transcript". A
+// stage that was not reached, such as the hint of a suppressed guess, is
+// omitted; one that was reports at least 1.
+type Timing struct {
+	Type           string `json:"type"`
+	Source         string `json:"source"`
+	TraceID        string `json:"traceId,omitempty"`
+	FinalMs        int64  `json:"finalMs"`
+	FirstTokenMs   int64  `json:"firstTokenMs,omitempty"`
+	FirstPartialMs int64  `json:"firstPartialMs,omitempty"`
+	HintMs         int64  `json:"hintMs,omitempty"`
+}
+
+// Warning reports a degraded but working session.
+type Warning struct {
+	Type string `json:"type"`
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// Error reports a rejected message or a failed subsystem.
+type Error struct {
+	Type string `json:"type"`
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (Hello) MessageType() string           { return TypeHello }
+func (FrameMeta) MessageType() string       { return TypeFrameMeta }
+func (Stop) MessageType() string            { return TypeStop }
+func (Transcript) MessageType() string      { return TypeTranscript }
+func (State) MessageType() string           { return TypeState }
+func (Partial) MessageType() string         { return TypePartial }
+func (Final) MessageType() string           { return TypeFinal }
+func (HintPartial) MessageType() string     { return TypeHintPartial }
+func (Hint) MessageType() string            { return TypeHint }
+func (FollowupPartial) MessageType() string { return TypeFollowupPartial }
+func (Followup) MessageType() string        { return TypeFollowup }
+func (HintCancel) MessageType() string      { return TypeHintCancel }
+func (VAD) MessageType() string             { return TypeVAD }
+func (Timing) MessageType() string          { return TypeTiming }
+func (Warning) MessageType() string         { return TypeWarning }
+func (Error) MessageType() string           { return TypeError }
+
+// Upstream lists every client -> server message, in schema order.
+func Upstream() []Message {
+	return []Message{Hello{}, FrameMeta{}, Stop{}, Transcript{}}
+}
+
+// Downstream lists every server -> client message, in schema order.
+func Downstream() []Message {
+	return []Message{
+		State{}, Partial{}, Final{}, HintPartial{}, Hint{},
+		FollowupPartial{}, Followup{}, HintCancel{}, VAD{}, Timing{}, Warning{}, Error{},
+	}
+}
+
+// Constructors fill in Type so callers cannot send a mislabelled message.
+
+func NewState(listening bool) State  { return State{Type: TypeState, Listening: listening} }
+func NewPartial(text string) Partial { return Partial{Type: TypePartial, Text: text} }
+func NewFinal(text string) Final     { return Final{Type: TypeFinal, Text: text} }
+func NewHintPartial(text string) HintPartial {
+	return HintPartial{Type: TypeHintPartial, Text: text}
+}
+func NewHint(text string, ttlMs int) Hint { return Hint{Type: TypeHint, Text: text, TTLMs: ttlMs} }
+func NewFollowupPartial(text string) FollowupPartial {
+	return FollowupPartial{Type: TypeFollowupPartial, Text: text}
+}
+func NewFollowup(text string, ttlMs int) Followup {
+	return Followup{Type: TypeFollowup, Text: text, TTLMs: ttlMs}
+}
+func NewHintCancel(reason string) HintCancel {
+	return HintCancel{Type: TypeHintCancel, Reason: reason}
+}
+func NewVAD(speaking bool) VAD            { return VAD{Type: TypeVAD, Speaking: speaking} }
+func NewTiming(source string) Timing      { return Timing{Type: TypeTiming, Source: source} }
+func NewWarning(code, msg string) Warning { return Warning{Type: TypeWarning, Code: code, Msg: msg} }
+func NewError(code, msg string) Error     { return Error{Type: TypeError, Code: code, Msg: msg} }
+
+// Negotiate picks the version to speak with a client that announced
+// clientVersion (0 if it did not say).
+func Negotiate(clientVersion int) (int, error) {
+	if clientVersion == 0 {
+		return MinVersion, nil
+	}
+	if clientVersion < MinVersion {
+		return 0, &ProtocolError{Code: CodeUnsupportedVersion, Msg: "protocol version too old"}
+	}
+	if clientVersion > Version {
+		return Version, nil
+	}
+	return clientVersion, nil
+}
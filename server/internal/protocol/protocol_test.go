@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestDecodeUpstream(t *testing.T) {
+	msg, err := Decode([]byte(`{"type":"frame_meta","ocr":["budget","Q4"],"first":true}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	fm, ok := msg.(FrameMeta)
+	if !ok || len(fm.OCR) != 2 || !fm.First || fm.Last {
+		t.Fatalf("unexpected frame_meta: %#v", msg)
+	}
+
+	msg, err = Decode([]byte(`{"type":"hello","app":"cluely-visionos","ver":"0.1.0","protocol":1}`))
+	if err != nil {
+		t.Fatalf("decode hello: %v", err)
+	}
+	if h := msg.(Hello); h.App != "cluely-visionos" || h.Protocol != 1 {
+		t.Fatalf("unexpected hello: %#v", h)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		code string
+	}{
+		{"not json", `{"type":`, CodeBadMessage},
+		{"missing type", `{"text":"hi"}`, CodeBadMessage},
+		{"unknown type", `{"type":"shout"}`, CodeUnknownType},
+		{"unknown field", `{"type":"stop","now":true}`, CodeBadMessage},
+		{"wrong field type", `{"type":"frame_meta","ocr":"budget"}`, CodeBadMessage},
+		{"empty transcript", `{"type":"transcript","text":"  ","final":true}`, CodeBadMessage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode([]byte(tc.in))
+			var perr *ProtocolError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected ProtocolError, got %v", err)
+			}
+			if perr.Code != tc.code {
+				t.Fatalf("expected code %s, got %s (%s)", tc.code, perr.Code, perr.Msg)
+			}
+			if r := perr.Reply(); r.Type != TypeError || r.Code != tc.code {
+				t.Fatalf("unexpected reply: %#v", r)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if v, err := Negotiate(0); err != nil || v != MinVersion {
+		t.Fatalf("legacy client: got %d, %v", v, err)
+	}
+	if v, err := Negotiate(Version + 5); err != nil || v != Version {
+		t.Fatalf("newer client: got %d, %v", v, err)
+	}
+	if _, err := Negotiate(-1); err == nil {
+		t.Fatal("expected error for version below minimum")
+	}
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	want, err := Schema()
+	if err != nil {
+		t.Fatalf("render schema: %v", err)
+	}
+	got, err := os.ReadFile("schema.json")
+	if err != nil {
+		t.Fatalf("read schema.json: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("schema.json is stale; run go generate ./internal/protocol")
+	}
+}
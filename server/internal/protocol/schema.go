@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaID identifies the generated schema document.
+const SchemaID = "https://cluely.dev/schema/ws-protocol.json"
+
+// Schema renders a JSON Schema (draft 2020-12) describing every message in
+// Upstream and Downstream. It is generated from the Go structs so the
+// checked-in schema.json cannot drift from what the server accepts.
+func Schema() ([]byte, error) {
+	defs := map[string]any{}
+	up := make([]any, 0, len(Upstream()))
+	for _, m := range Upstream() {
+		defs[m.MessageType()] = messageSchema(m)
+		up = append(up, map[string]any{"$ref": "#/$defs/" + m.MessageType()})
+	}
+	down := make([]any, 0, len(Downstream()))
+	for _, m := range Downstream() {
+		def := messageSchema(m)
+		def["properties"].(map[string]any)["seq"] = map[string]any{"type": "integer", "minimum": 1}
+		defs[m.MessageType()] = def
+		down = append(down, map[string]any{"$ref": "#/$defs/" + m.MessageType()})
+	}
+	defs["upstream"] = map[string]any{"oneOf": up}
+	defs["downstream"] = map[string]any{"oneOf": down}
+
+	doc := map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"$id":        SchemaID,
+		"title":      "Cluely WebSocket protocol",
+		"x-protocol": map[string]any{"version": Version, "minVersion": MinVersion},
+		"$defs":      defs,
+		"anyOf":      []any{map[string]any{"$ref": "#/$defs/upstream"}, map[string]any{"$ref": "#/$defs/downstream"}},
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func messageSchema(m Message) map[string]any {
+	t := reflect.TypeOf(m)
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, omitempty := jsonName(f)
+		if name == "" {
+			continue
+		}
+		if name == "type" {
+			props[name] = map[string]any{"const": m.MessageType()}
+		} else {
+			props[name] = typeSchema(f.Type)
+		}
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func jsonName(f reflect.StructField) (name string, omitempty bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" || !f.IsExported() {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			if name, _ := jsonName(t.Field(i)); name != "" {
+				props[name] = typeSchema(t.Field(i).Type)
+			}
+		}
+		return map[string]any{"type": "object", "properties": props}
+	default:
+		return map[string]any{}
+	}
+}
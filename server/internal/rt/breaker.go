@@ -0,0 +1,260 @@
+package rt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState is where a circuit breaker is in its cycle.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails calls at once until OpenFor has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe calls through; one success closes
+	// the breaker, one failure opens it again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// ErrBreakerOpen is returned by Breaker.Allow while calls are refused.
+var ErrBreakerOpen = errors.New("rt: circuit breaker open")
+
+// OpenError says which upstream is refusing calls and for how long.
+type OpenError struct {
+	Name    string
+	RetryIn time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s unavailable (circuit open, retry in %s)", e.Name, e.RetryIn.Round(time.Second))
+}
+
+func (e *OpenError) Unwrap() error { return ErrBreakerOpen }
+
+// BreakerConfig sets a breaker's thresholds. Zero fields take the defaults.
+type BreakerConfig struct {
+	// Failures is how many consecutive failures open the breaker.
+	Failures int `yaml:"failures"`
+	// OpenFor is how long an open breaker refuses calls before probing.
+	OpenFor time.Duration `yaml:"openFor"`
+	// Probes is how many calls may be in flight while half-open.
+	Probes int `yaml:"probes"`
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.Failures <= 0 {
+		c.Failures = 5
+	}
+	if c.OpenFor <= 0 {
+		c.OpenFor = 30 * time.Second
+	}
+	if c.Probes <= 0 {
+		c.Probes = 1
+	}
+	return c
+}
+
+// DefaultBreakerConfig returns the production breaker settings.
+func DefaultBreakerConfig() BreakerConfig { return BreakerConfig{}.withDefaults() }
+
+// Breaker is a circuit breaker for one upstream. Callers ask Allow before a
+// call and Record its outcome afterwards.
+type Breaker struct {
+	Name  string
+	Clock Clock
+	// OnChange, if set, is called after every state transition, outside
+	// the breaker's lock.
+	OnChange func(name string, from, to BreakerState)
+
+	cfg      BreakerConfig
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+	opens    int64
+}
+
+// NewBreaker returns a closed breaker.
+func NewBreaker(name string, cfg BreakerConfig) *Breaker {
+	return &Breaker{Name: name, cfg: cfg.withDefaults()}
+}
+
+func (b *Breaker) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock()
+	}
+	return time.Now()
+}
+
+// Allow reports whether a call may go ahead. It returns an *OpenError,
+// which matches ErrBreakerOpen, while the upstream is considered down. A
+// call that was allowed must be followed by exactly one Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	from := b.state
+	now := b.now()
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.cfg.OpenFor {
+		b.state, b.probes = BreakerHalfOpen, 0
+	}
+	var err error
+	switch b.state {
+	case BreakerOpen:
+		err = &OpenError{Name: b.Name, RetryIn: b.cfg.OpenFor - now.Sub(b.openedAt)}
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.Probes {
+			err = &OpenError{Name: b.Name}
+		} else {
+			b.probes++
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+	return err
+}
+
+// Record reports the outcome of an allowed call. Callers should not count
+// their own cancellations as failures.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	from := b.state
+	switch {
+	case err == nil:
+		b.state, b.failures = BreakerClosed, 0
+	case b.state == BreakerHalfOpen:
+		b.openLocked()
+	default:
+		b.failures++
+		if b.state == BreakerClosed && b.failures >= b.cfg.Failures {
+			b.openLocked()
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+}
+
+// Cancel ends an allowed call without an outcome, e.g. when the caller
+// gave up on it. It frees a half-open probe without counting anything.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *Breaker) openLocked() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.failures = 0
+	b.opens++
+}
+
+func (b *Breaker) changed(from, to BreakerState) {
+	if from != to && b.OnChange != nil {
+		b.OnChange(b.Name, from, to)
+	}
+}
+
+// State returns the current state. An open breaker whose OpenFor has passed
+// reports half-open even before the next call arrives.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenFor {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Err returns the *OpenError Allow would, without taking a probe: nil
+// unless the breaker is open and still waiting out OpenFor.
+func (b *Breaker) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if since := b.now().Sub(b.openedAt); b.state == BreakerOpen && since < b.cfg.OpenFor {
+		return &OpenError{Name: b.Name, RetryIn: b.cfg.OpenFor - since}
+	}
+	return nil
+}
+
+// BreakerStatus is a snapshot for health checks and metrics.
+type BreakerStatus struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	Opens    int64  `json:"opens"`
+}
+
+// Status returns a snapshot of the breaker.
+func (b *Breaker) Status() BreakerStatus {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{Name: b.Name, State: state.String(), Failures: b.failures, Opens: b.opens}
+}
+
+// Breakers holds one breaker per upstream, shared by every session so that
+// one outage is learned once rather than per connection.
+type Breakers struct {
+	// Config is used for breakers created after it is set.
+	Config   BreakerConfig
+	OnChange func(name string, from, to BreakerState)
+
+	mu sync.Mutex
+	m  map[string]*Breaker
+}
+
+// DefaultBreakers is the process-wide registry. cluelyd sets its Config
+// at startup.
+var DefaultBreakers = &Breakers{Config: DefaultBreakerConfig()}
+
+// Get returns the breaker for name, creating it on first use.
+func (r *Breakers) Get(name string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.m[name]; ok {
+		return b
+	}
+	if r.m == nil {
+		r.m = make(map[string]*Breaker)
+	}
+	b := NewBreaker(name, r.Config)
+	b.OnChange = r.OnChange
+	r.m[name] = b
+	return b
+}
+
+// Status returns every breaker's snapshot, sorted by name.
+func (r *Breakers) Status() []BreakerStatus {
+	r.mu.Lock()
+	list := make([]*Breaker, 0, len(r.m))
+	for _, b := range r.m {
+		list = append(list, b)
+	}
+	r.mu.Unlock()
+	out := make([]BreakerStatus, len(list))
+	for i, b := range list {
+		out[i] = b.Status()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
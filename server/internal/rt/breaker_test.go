@@ -0,0 +1,91 @@
+package rt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensHalfOpensAndCloses(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	b := NewBreaker("llm:test", BreakerConfig{Failures: 2, OpenFor: 10 * time.Second})
+	b.Clock = clk.now
+	var changes []string
+	b.OnChange = func(_ string, from, to BreakerState) { changes = append(changes, from.String()+">"+to.String()) }
+	boom := errors.New("boom")
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("call %d refused while closed: %v", i, err)
+		}
+		b.Record(boom)
+	}
+	err := b.Allow()
+	var open *OpenError
+	if !errors.As(err, &open) || !errors.Is(err, ErrBreakerOpen) || open.RetryIn != 10*time.Second {
+		t.Fatalf("expected open error with 10s retry, got %v", err)
+	}
+
+	clk.advance(10 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe refused after OpenFor: %v", err)
+	}
+	if err := b.Allow(); err == nil {
+		t.Fatal("second concurrent probe allowed")
+	}
+	b.Record(nil)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state after successful probe = %v", b.State())
+	}
+	want := []string{"closed>open", "open>half_open", "half_open>closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	b := NewBreaker("asr:test", BreakerConfig{Failures: 1, OpenFor: time.Second})
+	b.Clock = clk.now
+	_ = b.Allow()
+	b.Record(errors.New("down"))
+	clk.advance(time.Second)
+	_ = b.Allow()
+	b.Record(errors.New("still down"))
+	if b.State() != BreakerOpen || b.Err() == nil {
+		t.Fatalf("expected open after failed probe, got %v", b.State())
+	}
+	if s := b.Status(); s.Opens != 2 {
+		t.Fatalf("opens = %d, want 2", s.Opens)
+	}
+}
+
+func TestBreakerSuccessResetsFailureCount(t *testing.T) {
+	b := NewBreaker("x", BreakerConfig{Failures: 2})
+	for _, err := range []error{errors.New("a"), nil, errors.New("b")} {
+		_ = b.Allow()
+		b.Record(err)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatal("non-consecutive failures opened the breaker")
+	}
+}
+
+func TestBreakerCancelFreesProbe(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	b := NewBreaker("x", BreakerConfig{Failures: 1, OpenFor: time.Second})
+	b.Clock = clk.now
+	_ = b.Allow()
+	b.Record(errors.New("down"))
+	clk.advance(time.Second)
+	_ = b.Allow()
+	b.Cancel()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe still taken after Cancel: %v", err)
+	}
+}
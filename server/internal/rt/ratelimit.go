@@ -1,27 +1,278 @@
 package rt
 
 import (
+	"context"
+	"errors"
+	"math"
 	"sync"
 	"time"
 )
 
+// Clock returns the current time. Tests inject a fake clock; nil means time.Now.
+type Clock func() time.Time
+
+// RateLimiter is a token bucket holding at most N tokens, refilled at one
+// token per Every. A full bucket lets N events through back to back, which is
+// what we want after a long silence; steady state is one event per Every.
 type RateLimiter struct {
 	N     int
 	Every time.Duration
-	mu    sync.Mutex
-	last  time.Time
+	Clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	primed bool
 }
 
+// NewRateLimiter returns a limiter that starts with a full bucket.
 func NewRateLimiter(n int, every time.Duration) *RateLimiter {
 	return &RateLimiter{N: n, Every: every}
 }
 
-func (r *RateLimiter) Allow() bool {
+func (r *RateLimiter) now() time.Time {
+	if r.Clock != nil {
+		return r.Clock()
+	}
+	return time.Now()
+}
+
+// advance refills the bucket up to now. Caller holds r.mu.
+func (r *RateLimiter) advance(now time.Time) {
+	burst := float64(r.burst())
+	if !r.primed {
+		r.tokens = burst
+		r.last = now
+		r.primed = true
+		return
+	}
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		if r.Every <= 0 {
+			r.tokens = burst
+		} else {
+			r.tokens = math.Min(burst, r.tokens+float64(elapsed)/float64(r.Every))
+		}
+		r.last = now
+	}
+}
+
+func (r *RateLimiter) burst() int {
+	if r.N < 1 {
+		return 1
+	}
+	return r.N
+}
+
+// Allow reports whether one event may happen now and consumes a token if so.
+func (r *RateLimiter) Allow() bool { return r.AllowN(1) }
+
+// AllowN reports whether n events may happen now and consumes n tokens if so.
+func (r *RateLimiter) AllowN(n int) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if time.Since(r.last) >= r.Every {
-		r.last = time.Now()
+	r.advance(r.now())
+	if r.tokens >= float64(n) {
+		r.tokens -= float64(n)
 		return true
 	}
 	return false
 }
+
+// Tokens returns the number of tokens currently available.
+func (r *RateLimiter) Tokens() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.advance(r.now())
+	return r.tokens
+}
+
+// full reports whether the bucket is at capacity.
+func (r *RateLimiter) full() bool {
+	return r.Tokens() >= float64(r.burst())
+}
+
+// Reservation is a token taken ahead of time. The holder must wait Delay
+// before acting, or Cancel to hand the token back.
+type Reservation struct {
+	ok     bool
+	lim    *RateLimiter
+	n      int
+	at     time.Time
+	cancel sync.Once
+}
+
+// OK reports whether the reservation can ever be satisfied (n <= N).
+func (rv *Reservation) OK() bool { return rv.ok }
+
+// Delay is how long the holder must wait, measured from now.
+func (rv *Reservation) Delay() time.Duration {
+	if !rv.ok {
+		return time.Duration(math.MaxInt64)
+	}
+	d := rv.at.Sub(rv.lim.now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Cancel returns the reserved tokens to the bucket if the reservation has
+// not matured yet. Once its time has come the tokens count as spent.
+func (rv *Reservation) Cancel() {
+	if !rv.ok {
+		return
+	}
+	rv.cancel.Do(func() {
+		rv.lim.mu.Lock()
+		defer rv.lim.mu.Unlock()
+		now := rv.lim.now()
+		if now.After(rv.at) {
+			return
+		}
+		rv.lim.advance(now)
+		rv.lim.tokens = math.Min(float64(rv.lim.burst()), rv.lim.tokens+float64(rv.n))
+	})
+}
+
+// Reserve takes one token, possibly driving the bucket negative, and reports
+// when it becomes usable.
+func (r *RateLimiter) Reserve() *Reservation { return r.ReserveN(1) }
+
+// ReserveN takes n tokens. The reservation is not OK if n exceeds the burst.
+func (r *RateLimiter) ReserveN(n int) *Reservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n > r.burst() {
+		return &Reservation{lim: r}
+	}
+	now := r.now()
+	r.advance(now)
+	r.tokens -= float64(n)
+	at := now
+	if r.tokens < 0 {
+		at = now.Add(time.Duration(-r.tokens * float64(r.Every)))
+	}
+	return &Reservation{ok: true, lim: r, n: n, at: at}
+}
+
+// Wait blocks until one token is available or ctx is done. On cancellation the
+// token is returned to the bucket.
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	rv := r.Reserve()
+	if !rv.OK() {
+		return ErrBurstExceeded
+	}
+	d := rv.Delay()
+	if d == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(r.now().Add(d)) {
+		rv.Cancel()
+		return context.DeadlineExceeded
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		rv.Cancel()
+		return ctx.Err()
+	}
+}
+
+// ErrBurstExceeded is returned by Wait when the request can never fit the bucket.
+var ErrBurstExceeded = errors.New("rt: request exceeds limiter burst")
+
+// KeyedLimiter keeps one RateLimiter per key (session, API key, remote IP)
+// with the same burst and rate. Keys whose bucket has refilled and that have
+// not been touched for IdleTTL are evicted so the map does not grow forever.
+type KeyedLimiter struct {
+	N       int
+	Every   time.Duration
+	IdleTTL time.Duration
+	Clock   Clock
+
+	mu        sync.Mutex
+	limiters  map[string]*keyedEntry
+	lastSweep time.Time
+}
+
+type keyedEntry struct {
+	lim  *RateLimiter
+	seen time.Time
+}
+
+// NewKeyedLimiter returns a keyed limiter. idleTTL <= 0 disables eviction.
+func NewKeyedLimiter(n int, every, idleTTL time.Duration) *KeyedLimiter {
+	return &KeyedLimiter{N: n, Every: every, IdleTTL: idleTTL, limiters: make(map[string]*keyedEntry)}
+}
+
+func (k *KeyedLimiter) now() time.Time {
+	if k.Clock != nil {
+		return k.Clock()
+	}
+	return time.Now()
+}
+
+// Get returns the limiter for key, creating it on first use.
+func (k *KeyedLimiter) Get(key string) *RateLimiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.now()
+	k.sweepLocked(now)
+	if k.limiters == nil {
+		k.limiters = make(map[string]*keyedEntry)
+	}
+	e, ok := k.limiters[key]
+	if !ok {
+		e = &keyedEntry{lim: &RateLimiter{N: k.N, Every: k.Every, Clock: k.Clock}}
+		k.limiters[key] = e
+	}
+	e.seen = now
+	return e.lim
+}
+
+// Allow consumes a token for key if one is available.
+func (k *KeyedLimiter) Allow(key string) bool { return k.Get(key).Allow() }
+
+// Reserve takes a token for key; see RateLimiter.Reserve.
+func (k *KeyedLimiter) Reserve(key string) *Reservation { return k.Get(key).Reserve() }
+
+// Wait blocks until key has a token or ctx is done.
+func (k *KeyedLimiter) Wait(ctx context.Context, key string) error { return k.Get(key).Wait(ctx) }
+
+// Forget drops the limiter for key, e.g. when a session ends.
+func (k *KeyedLimiter) Forget(key string) {
+	k.mu.Lock()
+	delete(k.limiters, key)
+	k.mu.Unlock()
+}
+
+// Len returns the number of tracked keys.
+func (k *KeyedLimiter) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
+
+// Sweep evicts idle keys now instead of waiting for the next access.
+func (k *KeyedLimiter) Sweep() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastSweep = time.Time{}
+	k.sweepLocked(k.now())
+}
+
+// sweepLocked runs at most once per IdleTTL. Caller holds k.mu.
+func (k *KeyedLimiter) sweepLocked(now time.Time) {
+	if k.IdleTTL <= 0 || now.Sub(k.lastSweep) < k.IdleTTL {
+		return
+	}
+	k.lastSweep = now
+	for key, e := range k.limiters {
+		if now.Sub(e.seen) >= k.IdleTTL && e.lim.full() {
+			delete(k.limiters, key)
+		}
+	}
+}
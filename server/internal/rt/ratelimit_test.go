@@ -0,0 +1,125 @@
+package rt
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (f *fakeClock) now() time.Time          { return f.t }
+func (f *fakeClock) advance(d time.Duration) { f.t = f.t.Add(d) }
+
+func TestRateLimiterBurstAfterSilence(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	r := NewRateLimiter(3, time.Second)
+	r.Clock = clk.now
+
+	for i := 0; i < 3; i++ {
+		if !r.Allow() {
+			t.Fatalf("burst token %d denied", i)
+		}
+	}
+	if r.Allow() {
+		t.Fatal("expected bucket to be empty")
+	}
+
+	clk.advance(time.Second)
+	if !r.Allow() {
+		t.Fatal("expected one token after refill interval")
+	}
+	if r.Allow() {
+		t.Fatal("expected only one token to refill")
+	}
+
+	clk.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !r.Allow() {
+			t.Fatalf("burst token %d denied after silence", i)
+		}
+	}
+	if r.Allow() {
+		t.Fatal("bucket refilled past its burst size")
+	}
+}
+
+func TestRateLimiterReserveAndCancel(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	r := NewRateLimiter(1, 2*time.Second)
+	r.Clock = clk.now
+
+	if d := r.Reserve().Delay(); d != 0 {
+		t.Fatalf("first reservation should be immediate, got %v", d)
+	}
+	rv := r.Reserve()
+	if d := rv.Delay(); d != 2*time.Second {
+		t.Fatalf("expected 2s delay, got %v", d)
+	}
+	rv.Cancel()
+	clk.advance(2 * time.Second)
+	if !r.Allow() {
+		t.Fatal("cancelled reservation should have returned its token")
+	}
+
+	late := r.Reserve()
+	clk.advance(3 * time.Second)
+	late.Cancel()
+	if r.Allow() {
+		t.Fatal("reservation cancelled after maturing should not refund its token")
+	}
+
+	if r.ReserveN(2).OK() {
+		t.Fatal("reservation larger than burst should not be OK")
+	}
+}
+
+func TestRateLimiterWaitHonorsContext(t *testing.T) {
+	r := NewRateLimiter(1, time.Hour)
+	if err := r.Wait(context.Background()); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Wait(ctx); err == nil {
+		t.Fatal("expected wait to fail on deadline")
+	}
+
+	fast := NewRateLimiter(1, 20*time.Millisecond)
+	_ = fast.Allow()
+	start := time.Now()
+	if err := fast.Wait(context.Background()); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("wait returned before a token was available")
+	}
+}
+
+func TestKeyedLimiterIsolatesAndEvictsKeys(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	k := NewKeyedLimiter(1, time.Second, time.Minute)
+	k.Clock = clk.now
+
+	if !k.Allow("a") || !k.Allow("b") {
+		t.Fatal("fresh keys should each get a token")
+	}
+	if k.Allow("a") {
+		t.Fatal("key a should be limited")
+	}
+	if k.Len() != 2 {
+		t.Fatalf("expected 2 keys, got %d", k.Len())
+	}
+
+	clk.advance(2 * time.Minute)
+	k.Sweep()
+	if k.Len() != 0 {
+		t.Fatalf("expected idle keys to be evicted, got %d", k.Len())
+	}
+
+	_ = k.Allow("c")
+	k.Forget("c")
+	if k.Len() != 0 {
+		t.Fatalf("expected Forget to drop key, got %d", k.Len())
+	}
+}
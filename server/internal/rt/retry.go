@@ -0,0 +1,206 @@
+package rt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy retries an HTTP call with exponential backoff and jitter.
+// Only the response status is retried: once a 2xx response is handed back,
+// a broken stream is the caller's problem. The caller's context deadline is
+// the budget for all attempts together; a retry that could not finish
+// within it is not started.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first.
+	Attempts int
+	// Base is the first backoff; each retry doubles it up to Max.
+	Base time.Duration
+	Max  time.Duration
+	// MinAttempt is the least time left in the budget worth starting
+	// another attempt with.
+	MinAttempt time.Duration
+	// Retryable decides whether a failed response is worth retrying. body is
+	// the response body, already read. Nil means RetryableStatus.
+	Retryable func(status int, body []byte) bool
+	// OnRetry is called before each retry, e.g. to count it.
+	OnRetry func(attempt int, err error)
+	// Sleep waits d or until ctx is done. Tests replace it; nil sleeps.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = 3
+	}
+	if p.Base <= 0 {
+		p.Base = 250 * time.Millisecond
+	}
+	if p.Max < p.Base {
+		p.Max = 4 * time.Second
+	}
+	if p.MinAttempt <= 0 {
+		p.MinAttempt = time.Second
+	}
+	if p.Retryable == nil {
+		p.Retryable = func(status int, _ []byte) bool { return RetryableStatus(status) }
+	}
+	if p.Sleep == nil {
+		p.Sleep = sleepCtx
+	}
+	return p
+}
+
+// statusError stands for a retryable failed response between attempts; the
+// caller gets the response itself if it was the last.
+type statusError struct {
+	Status int
+	Body   []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("http %d: %s", e.Status, strings.TrimSpace(string(e.Body)))
+}
+
+// Do sends the request built by newReq until it gets a response that is not
+// worth retrying, attempts run out, or the budget is spent. newReq is called
+// for every attempt so the body can be replayed. A failed response that is
+// returned has its body intact, for the caller's usual error parsing.
+func (p RetryPolicy) Do(ctx context.Context, client *http.Client, newReq func(context.Context) (*http.Request, error)) (*http.Response, error) {
+	p = p.withDefaults()
+	for attempt := 1; ; attempt++ {
+		req, err := newReq(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("build request: %w", err)
+		}
+		resp, err := client.Do(req)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !retryableNetErr(err) {
+				return nil, fmt.Errorf("execute request: %w", err)
+			}
+			err = fmt.Errorf("execute request: %w", err)
+		case resp.StatusCode < http.StatusBadRequest:
+			return resp, nil
+		default:
+			body, readErr := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			if readErr != nil || !p.Retryable(resp.StatusCode, body) {
+				return resp, nil
+			}
+			wait = RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			err = &statusError{Status: resp.StatusCode, Body: body}
+		}
+
+		if attempt >= p.Attempts {
+			return lastAttempt(resp, err)
+		}
+		if wait == 0 {
+			wait = p.backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait+p.MinAttempt {
+			// Not enough budget left for the retry to be of any use.
+			return lastAttempt(resp, err)
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err)
+		}
+		if err := p.Sleep(ctx, wait); err != nil {
+			return lastAttempt(resp, err)
+		}
+	}
+}
+
+// lastAttempt hands back the final failed response if there was one.
+func lastAttempt(resp *http.Response, err error) (*http.Response, error) {
+	var se *statusError
+	if resp != nil && errors.As(err, &se) {
+		return resp, nil
+	}
+	return nil, err
+}
+
+// backoff is Base doubled per attempt, capped at Max, with the upper half
+// jittered so clients that failed together do not retry together.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Base << (attempt - 1)
+	if d > p.Max || d <= 0 {
+		d = p.Max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RetryableStatus reports whether an HTTP status is usually transient.
+func RetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryableRPCStatus classifies a google.rpc.Code name, as Google APIs put
+// in error.status. known is false for names it does not recognize.
+func RetryableRPCStatus(status string) (retry, known bool) {
+	switch strings.ToUpper(strings.TrimSpace(status)) {
+	case "RESOURCE_EXHAUSTED", "UNAVAILABLE", "INTERNAL", "DEADLINE_EXCEEDED", "ABORTED":
+		return true, true
+	case "INVALID_ARGUMENT", "FAILED_PRECONDITION", "OUT_OF_RANGE", "UNAUTHENTICATED",
+		"PERMISSION_DENIED", "NOT_FOUND", "ALREADY_EXISTS", "UNIMPLEMENTED", "CANCELLED":
+		return false, true
+	}
+	return false, false
+}
+
+// RetryAfter parses a Retry-After header, in seconds or as an HTTP date.
+// It returns 0 when the header is absent or unparseable.
+func RetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retryableNetErr reports whether a transport error may succeed on retry.
+// Errors from a bad URL or a refused TLS handshake will not.
+func retryableNetErr(err error) bool {
+	var header tls.RecordHeaderError
+	var cert *tls.CertificateVerificationError
+	if errors.As(err, &header) || errors.As(err, &cert) {
+		return false
+	}
+	return !strings.Contains(err.Error(), "unsupported protocol scheme")
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
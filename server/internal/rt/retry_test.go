@@ -0,0 +1,111 @@
+package rt
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky answers with the given statuses in turn, then 200.
+func flaky(t *testing.T, calls *atomic.Int32, statuses ...int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(statuses) {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(statuses[n-1])
+			_, _ = w.Write([]byte(`{"error":{"status":"UNAVAILABLE"}}`))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(url string) func(context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	srv := flaky(t, &calls, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	var slept []time.Duration
+	p := RetryPolicy{Sleep: func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}}
+	resp, err := p.Do(context.Background(), srv.Client(), get(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" || calls.Load() != 3 {
+		t.Fatalf("got %d %q after %d calls", resp.StatusCode, body, calls.Load())
+	}
+	if len(slept) != 2 || slept[0] != 2*time.Second || slept[1] != 2*time.Second {
+		t.Fatalf("slept %v, want Retry-After twice", slept)
+	}
+}
+
+func TestRetryReturnsPermanentFailureAsIs(t *testing.T) {
+	var calls atomic.Int32
+	srv := flaky(t, &calls, http.StatusBadRequest)
+	resp, err := RetryPolicy{}.Do(context.Background(), srv.Client(), get(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || calls.Load() != 1 || len(body) == 0 {
+		t.Fatalf("got %d %q after %d calls, want the 400 with its body", resp.StatusCode, body, calls.Load())
+	}
+}
+
+func TestRetryStopsWhenBudgetIsSpent(t *testing.T) {
+	var calls atomic.Int32
+	srv := flaky(t, &calls, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	// Retry-After 2s does not fit a 1.5s budget: the 503 comes back at once.
+	start := time.Now()
+	resp, err := RetryPolicy{}.Do(ctx, srv.Client(), get(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("got %d after %d calls", resp.StatusCode, calls.Load())
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("waited for a retry that could not fit the budget")
+	}
+}
+
+func TestRetryAfterParsesDate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if got := RetryAfter(now.Add(3*time.Second).Format(http.TimeFormat), now); got != 3*time.Second {
+		t.Fatalf("RetryAfter(date) = %v", got)
+	}
+	if got := RetryAfter("soon", now); got != 0 {
+		t.Fatalf("RetryAfter(garbage) = %v", got)
+	}
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	p := RetryPolicy{Base: 100 * time.Millisecond, Max: 400 * time.Millisecond}.withDefaults()
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 400 * time.Millisecond} {
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(attempt); d < max/2 || d > max {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", attempt, d, max/2, max)
+			}
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exporter receives finished spans. Export must not block the caller for
+// long; batching exporters queue and drop rather than wait.
+type Exporter interface {
+	Export(SpanData)
+	// Shutdown flushes queued spans and stops the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+var (
+	exportMu sync.RWMutex
+	current  Exporter
+)
+
+// SetExporter sends every span ended from now on to e. Nil stops exporting;
+// spans are still created so their IDs can be logged and propagated.
+func SetExporter(e Exporter) {
+	exportMu.Lock()
+	current = e
+	exportMu.Unlock()
+}
+
+func exporter() Exporter {
+	exportMu.RLock()
+	defer exportMu.RUnlock()
+	return current
+}
+
+// ExporterConfig picks where spans go. Empty fields take the defaults
+// NewExporter documents.
+type ExporterConfig struct {
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector's base URL.
+	Endpoint string `yaml:"endpoint"`
+	Service  string `yaml:"service"`
+}
+
+// NewExporter builds the exporter cfg.Exporter names:
+//
+//	none (default)   spans are not exported
+//	stdout, console  one JSON object per span on stdout
+//	otlp             OTLP/HTTP JSON to cfg.Endpoint (default
+//	                 http://localhost:4318), as cfg.Service (default cluelyd)
+//
+// It returns nil for none.
+func NewExporter(cfg ExporterConfig) (Exporter, error) {
+	switch name := strings.ToLower(strings.TrimSpace(cfg.Exporter)); name {
+	case "", "none", "off":
+		return nil, nil
+	case "stdout", "console":
+		return NewStdoutExporter(os.Stdout), nil
+	case "otlp":
+		endpoint := strings.TrimSpace(cfg.Endpoint)
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		service := strings.TrimSpace(cfg.Service)
+		if service == "" {
+			service = "cluelyd"
+		}
+		return NewOTLPExporter(strings.TrimRight(endpoint, "/")+"/v1/traces", service), nil
+	default:
+		return nil, fmt.Errorf("trace exporter %q not supported", name)
+	}
+}
+
+// StdoutExporter writes each span as a line of JSON, for eyeballing a
+// trace without a collector.
+type StdoutExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewStdoutExporter writes spans to w.
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{enc: json.NewEncoder(w)}
+}
+
+type stdoutSpan struct {
+	Trace  TraceID        `json:"trace"`
+	Span   SpanID         `json:"span"`
+	Parent string         `json:"parent,omitempty"`
+	Name   string         `json:"name"`
+	Start  time.Time      `json:"start"`
+	DurMs  float64        `json:"durMs"`
+	Attrs  map[string]any `json:"attrs,omitempty"`
+	Events []stdoutEvent  `json:"events,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+type stdoutEvent struct {
+	Name  string         `json:"name"`
+	AtMs  float64        `json:"atMs"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+func (e *StdoutExporter) Export(d SpanData) {
+	out := stdoutSpan{
+		Trace: d.Context.TraceID,
+		Span:  d.Context.SpanID,
+		Name:  d.Name,
+		Start: d.Start,
+		DurMs: millis(d.End.Sub(d.Start)),
+		Attrs: attrMap(d.Attrs),
+		Error: d.Err,
+	}
+	if d.Parent.IsValid() {
+		out.Parent = d.Parent.String()
+	}
+	for _, ev := range d.Events {
+		out.Events = append(out.Events, stdoutEvent{Name: ev.Name, AtMs: millis(ev.Time.Sub(d.Start)), Attrs: attrMap(ev.Attrs)})
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_ = e.enc.Encode(out)
+}
+
+func (e *StdoutExporter) Shutdown(context.Context) error { return nil }
+
+func millis(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+func attrMap(attrs []Attr) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]any, len(attrs))
+	for _, a := range attrs {
+		m[a.Key] = a.Value
+	}
+	return m
+}
+
+// OTLPExporter batches spans and posts them to an OpenTelemetry collector
+// with the OTLP/HTTP JSON encoding. Spans that arrive while the queue is
+// full are dropped; a failed post is logged and not retried.
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+	// batchSize and interval bound how long a span waits in the queue.
+	batchSize int
+	interval  time.Duration
+
+	queue    chan SpanData
+	flushReq chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Int64
+}
+
+// NewOTLPExporter posts spans to url, e.g. http://localhost:4318/v1/traces,
+// on behalf of service.
+func NewOTLPExporter(url, service string) *OTLPExporter {
+	e := &OTLPExporter{
+		url:     url,
+		service: service,
+		// Not traced: exporting a span must not create another.
+		client:    &http.Client{Timeout: 5 * time.Second},
+		batchSize: 256,
+		interval:  2 * time.Second,
+		queue:     make(chan SpanData, 2048),
+		flushReq:  make(chan chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(d SpanData) {
+	select {
+	case e.queue <- d:
+	case <-e.done:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Flush posts whatever is queued and waits for it.
+func (e *OTLPExporter) Flush() {
+	ack := make(chan struct{})
+	select {
+	case e.flushReq <- ack:
+		<-ack
+	case <-e.done:
+	}
+}
+
+// Shutdown flushes the queue and stops the exporter, giving up when ctx is
+// done.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	flushed := make(chan struct{})
+	go func() {
+		e.Flush()
+		e.stopOnce.Do(func() { close(e.done) })
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	var batch []SpanData
+	send := func() {
+		if len(batch) > 0 {
+			e.post(batch)
+			batch = nil
+		}
+		if n := e.dropped.Swap(0); n > 0 {
+			log.Printf("[trace] dropped %d spans (export queue full)", n)
+		}
+	}
+	for {
+		select {
+		case d := <-e.queue:
+			batch = append(batch, d)
+			if len(batch) >= e.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flushReq:
+			for drained := false; !drained; {
+				select {
+				case d := <-e.queue:
+					batch = append(batch, d)
+				default:
+					drained = true
+				}
+			}
+			send()
+			close(ack)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) post(batch []SpanData) {
+	body, err := json.Marshal(otlpRequest(e.service, batch))
+	if err != nil {
+		log.Printf("[trace] encode spans: %v", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[trace] export %d spans: %v", len(batch), err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		log.Printf("[trace] export %d spans: http %d: %s", len(batch), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+}
+
+// The OTLP/HTTP JSON encoding: IDs are hex, 64-bit numbers are strings.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           TraceID        `json:"traceId"`
+		SpanID            SpanID         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpStatusError  = 2
+)
+
+func otlpRequest(service string, batch []SpanData) otlpTraces {
+	spans := make([]otlpSpan, len(batch))
+	for i, d := range batch {
+		sp := otlpSpan{
+			TraceID:           d.Context.TraceID,
+			SpanID:            d.Context.SpanID,
+			Name:              d.Name,
+			Kind:              otlpKindInternal + int(d.Kind),
+			StartTimeUnixNano: unixNano(d.Start),
+			EndTimeUnixNano:   unixNano(d.End),
+			Attributes:        otlpAttrs(d.Attrs),
+		}
+		if d.Parent.IsValid() {
+			sp.ParentSpanID = d.Parent.String()
+		}
+		for _, ev := range d.Events {
+			sp.Events = append(sp.Events, otlpEvent{TimeUnixNano: unixNano(ev.Time), Name: ev.Name, Attributes: otlpAttrs(ev.Attrs)})
+		}
+		if d.Err != "" {
+			sp.Status = otlpStatus{Code: otlpStatusError, Message: d.Err}
+		}
+		spans[i] = sp
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttrs([]Attr{String("service.name", service)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "cluely/server"}, Spans: spans}},
+	}}}
+}
+
+func otlpAttrs(attrs []Attr) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpAnyValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		out = append(out, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return out
+}
+
+func unixNano(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
@@ -0,0 +1,55 @@
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Inject sets the traceparent header to sc, if sc names a span.
+func Inject(h http.Header, sc SpanContext) {
+	if sc.IsValid() {
+		h.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// Transport is an http.RoundTripper that wraps each request in a client
+// span and sends its traceparent upstream. The span covers the time to
+// response headers; streamed bodies are timed by the caller's span. The
+// query string is left out of the span because it can carry an API key.
+type Transport struct {
+	// Base sends the request; nil means http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, span := Start(req.Context(), "HTTP "+req.Method,
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		String("url.path", req.URL.Path),
+	)
+	span.SetKind(KindClient)
+	defer span.End()
+	req = req.Clone(ctx)
+	Inject(req.Header, span.Context())
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttrs(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.RecordError(fmt.Errorf("http %d", resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// NewClient returns an http.Client with the given timeout whose requests
+// are traced.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &Transport{}}
+}
@@ -0,0 +1,243 @@
+// Package trace records OpenTelemetry-style spans for the hint pipeline and
+// hands finished ones to an Exporter. It speaks W3C trace context on the
+// wire so spans line up with those of any upstream that reports its own.
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// TraceID identifies one trace: an utterance from its first audio to the
+// hint on glass.
+type TraceID [16]byte
+
+// SpanID identifies one span within a trace.
+type SpanID [8]byte
+
+func (t TraceID) IsValid() bool                { return t != TraceID{} }
+func (t TraceID) String() string               { return hex.EncodeToString(t[:]) }
+func (s SpanID) IsValid() bool                 { return s != SpanID{} }
+func (s SpanID) String() string                { return hex.EncodeToString(s[:]) }
+func (t TraceID) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+func (s SpanID) MarshalText() ([]byte, error)  { return []byte(s.String()), nil }
+
+func (t *TraceID) UnmarshalText(b []byte) error { return decodeID(t[:], b) }
+func (s *SpanID) UnmarshalText(b []byte) error  { return decodeID(s[:], b) }
+
+func decodeID(dst, src []byte) error {
+	if hex.DecodedLen(len(src)) != len(dst) {
+		return fmt.Errorf("trace: id %q is not %d hex bytes", src, len(dst))
+	}
+	_, err := hex.Decode(dst, src)
+	return err
+}
+
+// SpanContext is what crosses goroutine and process boundaries: enough to
+// parent a new span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether sc names a span.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent formats sc as a W3C traceparent header value, sampled.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceparent reads a W3C traceparent header value. Unknown versions
+// are accepted as long as the version 00 fields parse.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' || v[:2] == "ff" {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(v[3:35])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(v[36:52])); err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Attr is a span attribute. Value is a string, bool, int64 or float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, v string) Attr                 { return Attr{key, v} }
+func Int(key string, v int) Attr                { return Attr{key, int64(v)} }
+func Float(key string, v float64) Attr          { return Attr{key, v} }
+func Bool(key string, v bool) Attr              { return Attr{key, v} }
+func Duration(key string, d time.Duration) Attr { return Attr{key, d.Milliseconds()} }
+
+// Event is a timestamped note on a span.
+type Event struct {
+	Name  string
+	Time  time.Time
+	Attrs []Attr
+}
+
+// Kind says which side of a call a span is on.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindServer
+	KindClient
+)
+
+// SpanData is a finished span as exporters see it.
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID
+	Start, End time.Time
+	Attrs      []Attr
+	Events     []Event
+	// Err is the error the span ended with, empty if it succeeded.
+	Err string
+}
+
+// Span is an operation in progress. A nil *Span is valid and records
+// nothing, so optional spans need no guards at the call site.
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// Start begins a span named name as a child of the span in ctx, or of a
+// new trace if there is none, and returns ctx carrying it.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	sp := StartFrom(SpanContextFrom(ctx), name, attrs...)
+	return context.WithValue(ctx, spanKey{}, sp.Context()), sp
+}
+
+// StartFrom begins a span with parent as its parent, for work that is not
+// carried by a context; a zero parent starts a new trace.
+func StartFrom(parent SpanContext, name string, attrs ...Attr) *Span {
+	sp := &Span{data: SpanData{Name: name, Start: time.Now(), Attrs: attrs}}
+	sp.data.Context.TraceID = parent.TraceID
+	if parent.IsValid() {
+		sp.data.Parent = parent.SpanID
+	} else {
+		sp.data.Context.TraceID = newTraceID()
+	}
+	sp.data.Context.SpanID = newSpanID()
+	return sp
+}
+
+// ContextWith returns ctx carrying sc, so spans started from it become its
+// children. It is how a trace continues across a channel or a process.
+func ContextWith(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanContextFrom returns the span context carried by ctx, if any.
+func SpanContextFrom(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+// Context returns the span's identity for parenting other spans.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetKind marks the span as a client or server call.
+func (s *Span) SetKind(k Kind) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Kind = k
+	s.mu.Unlock()
+}
+
+// SetAttrs adds attributes to the span.
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// AddEvent notes that something happened during the span.
+func (s *Span) AddEvent(name string, attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attrs: attrs})
+	s.mu.Unlock()
+}
+
+// RecordError marks the span failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and exports it. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	s.mu.Unlock()
+	if e := exporter(); e != nil {
+		e.Export(d)
+	}
+}
+
+// IDs come from math/rand: they need to be unique, not unguessable.
+func newTraceID() (t TraceID) {
+	for !t.IsValid() {
+		binary.BigEndian.PutUint64(t[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(t[8:], rand.Uint64())
+	}
+	return t
+}
+
+func newSpanID() (s SpanID) {
+	for !s.IsValid() {
+		binary.BigEndian.PutUint64(s[:], rand.Uint64())
+	}
+	return s
+}
@@ -0,0 +1,217 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder collects exported spans.
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(d SpanData) {
+	r.mu.Lock()
+	r.spans = append(r.spans, d)
+	r.mu.Unlock()
+}
+
+func (r *recorder) Shutdown(context.Context) error { return nil }
+
+func (r *recorder) byName(name string) SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.spans {
+		if d.Name == name {
+			return d
+		}
+	}
+	return SpanData{}
+}
+
+func record(t *testing.T) *recorder {
+	r := &recorder{}
+	SetExporter(r)
+	t.Cleanup(func() { SetExporter(nil) })
+	return r
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sp := StartFrom(SpanContext{}, "root")
+	v := sp.Context().Traceparent()
+	if len(v) != 55 || !strings.HasPrefix(v, "00-") || !strings.HasSuffix(v, "-01") {
+		t.Fatalf("traceparent %q", v)
+	}
+	sc, ok := ParseTraceparent(v)
+	if !ok || sc != sp.Context() {
+		t.Fatalf("ParseTraceparent(%q) = %v, %v; want %v", v, sc, ok, sp.Context())
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c_b7ad6b7169203331-01",
+		"00-zzf7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("ParseTraceparent(%q) accepted", bad)
+		}
+	}
+}
+
+func TestChildSpansShareTheTrace(t *testing.T) {
+	rec := record(t)
+	utt := StartFrom(SpanContext{}, "asr.utterance")
+	utt.End()
+
+	// The hint continues the utterance's trace after it has ended, as the
+	// session does with the final's span context.
+	ctx, hint := Start(ContextWith(context.Background(), utt.Context()), "hint")
+	_, gen := Start(ctx, "llm.generate", String("llm.provider", "gemini"))
+	gen.RecordError(errors.New("http 503"))
+	gen.End()
+	hint.End()
+	hint.End() // exported once
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("exported %d spans, want 3", len(rec.spans))
+	}
+	u, h, g := rec.byName("asr.utterance"), rec.byName("hint"), rec.byName("llm.generate")
+	if u.Parent.IsValid() {
+		t.Errorf("utterance has parent %s", u.Parent)
+	}
+	if h.Context.TraceID != u.Context.TraceID || h.Parent != u.Context.SpanID {
+		t.Errorf("hint %+v is not a child of utterance %+v", h.Context, u.Context)
+	}
+	if g.Context.TraceID != u.Context.TraceID || g.Parent != h.Context.SpanID {
+		t.Errorf("generate %+v is not a child of hint %+v", g.Context, h.Context)
+	}
+	if g.Err != "http 503" || g.Attrs[0] != String("llm.provider", "gemini") {
+		t.Errorf("generate span %+v", g)
+	}
+}
+
+func TestNilSpanIsANoop(t *testing.T) {
+	var sp *Span
+	sp.SetAttrs(Int("n", 1))
+	sp.AddEvent("flush")
+	sp.RecordError(errors.New("x"))
+	sp.End()
+	if sp.Context().IsValid() {
+		t.Fatal("nil span has a context")
+	}
+}
+
+func TestTransportPropagatesTraceparent(t *testing.T) {
+	rec := record(t)
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, parent := Start(context.Background(), "llm.generate")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/v1/models/m:generate?key=secret", nil)
+	resp, err := NewClient(0).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	client := rec.byName("HTTP POST")
+	sc, ok := ParseTraceparent(got)
+	if !ok || sc != client.Context {
+		t.Fatalf("upstream got traceparent %q, client span is %+v", got, client.Context)
+	}
+	if client.Parent != parent.Context().SpanID || client.Kind != KindClient || client.Err != "http 429" {
+		t.Errorf("client span %+v", client)
+	}
+	for _, a := range client.Attrs {
+		if s, _ := a.Value.(string); strings.Contains(s, "secret") {
+			t.Errorf("attribute %s leaks the query string: %q", a.Key, s)
+		}
+	}
+}
+
+func TestOTLPExporterPostsJSON(t *testing.T) {
+	bodies := make(chan []byte, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	exp, err := NewExporter(ExporterConfig{Exporter: "otlp", Endpoint: srv.URL + "/", Service: "cluelyd-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetExporter(exp)
+	t.Cleanup(func() { SetExporter(nil) })
+
+	ctx, root := Start(context.Background(), "hint", Bool("hint.muted", true))
+	_, child := Start(ctx, "ws.write", Int("bytes", 42), Float("confidence", 0.5))
+	child.AddEvent("flush")
+	child.RecordError(errors.New("closed"))
+	child.End()
+	root.End()
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got otlpTraces
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	rs := got.ResourceSpans[0]
+	if v := rs.Resource.Attributes[0]; v.Key != "service.name" || *v.Value.StringValue != "cluelyd-test" {
+		t.Errorf("resource %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	w, h := spans[0], spans[1]
+	if w.Name != "ws.write" || h.Name != "hint" || w.ParentSpanID != h.SpanID.String() || h.ParentSpanID != "" {
+		t.Errorf("spans %+v", spans)
+	}
+	if w.Kind != 1 || w.Status.Code != 2 || w.Status.Message != "closed" || len(w.Events) != 1 {
+		t.Errorf("ws.write %+v", w)
+	}
+	if *w.Attributes[0].Value.IntValue != "42" || *w.Attributes[1].Value.DoubleValue != 0.5 || !*h.Attributes[0].Value.BoolValue {
+		t.Errorf("attributes %+v %+v", w.Attributes, h.Attributes)
+	}
+}
+
+func TestStdoutExporterWritesALinePerSpan(t *testing.T) {
+	var sb strings.Builder
+	SetExporter(NewStdoutExporter(&sb))
+	t.Cleanup(func() { SetExporter(nil) })
+
+	_, sp := Start(context.Background(), "asr.transcribe", String("asr.provider", "whisper"))
+	sp.End()
+
+	var line struct {
+		Trace, Span, Name string
+		Attrs             map[string]any
+	}
+	if err := json.Unmarshal([]byte(sb.String()), &line); err != nil {
+		t.Fatalf("%v: %q", err, sb.String())
+	}
+	if line.Trace != sp.Context().TraceID.String() || line.Name != "asr.transcribe" || line.Attrs["asr.provider"] != "whisper" {
+		t.Errorf("line %+v", line)
+	}
+}
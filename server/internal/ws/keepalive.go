@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"cluely/server/internal/protocol"
+
+	"nhooyr.io/websocket"
+)
+
+func (s *Session) touch() { s.lastRead.Store(time.Now().UnixNano()) }
+
+func (s *Session) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastRead.Load()))
+}
+
+// startKeepalive runs keepalive for c until the returned func is called or
+// ctx is done.
+func (s *Session) startKeepalive(ctx context.Context, c *websocket.Conn) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go s.keepalive(ctx, c)
+	return cancel
+}
+
+// keepalive pings the client on c every PingInterval. A peer that stops answering
+// pings is dead: we warn at half of PongTimeout and close at PongTimeout. A
+// peer that answers pings but sends nothing is merely silent and is only
+// closed when IdleTimeout is set. A dead peer leaves the session resumable;
+// an idle one ends it.
+//
+// nhooyr.io/websocket tears the connection down as soon as a Ping context
+// is done, so pings run without one and the grace window is enforced here.
+// An outstanding ping returns once a pong arrives or the conn closes. A
+// Close racing a ping that has not yet been written stalls the close frame
+// behind the ping's 5s write timeout, so the idle close only happens between
+// pings.
+func (s *Session) keepalive(ctx context.Context, c *websocket.Conn) {
+	tick := s.opts.PingInterval
+	if half := s.opts.PongTimeout / 2; half < tick {
+		tick = half
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var (
+		pingSent   time.Time
+		pong       = make(chan error, 1)
+		inFlight   bool
+		warnedPong bool
+		warnedIdle bool
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-pong:
+			inFlight = false
+			if err != nil {
+				return
+			}
+			if warnedPong {
+				warnedPong = false
+				_ = s.sendJSON(protocol.NewState(s.isListening()))
+			}
+		case now := <-ticker.C:
+			if inFlight {
+				late := now.Sub(pingSent)
+				if late >= s.opts.PongTimeout {
+					s.logger().Warn("no pong; closing", "late", late.Round(time.Millisecond))
+					_ = s.sendJSON(protocol.NewWarning("PEER_UNRESPONSIVE", "Connection lost; closing session."))
+					_ = c.Close(websocket.StatusGoingAway, "ping timeout")
+					return
+				}
+				if !warnedPong && late >= s.opts.PongTimeout/2 {
+					warnedPong = true
+					_ = s.sendJSON(protocol.NewWarning("CONNECTION_UNSTABLE", "Connection is slow to respond."))
+				}
+				continue
+			}
+			if s.opts.IdleTimeout > 0 {
+				idle := s.idleFor()
+				if idle >= s.opts.IdleTimeout {
+					state := protocol.NewState(false)
+					state.Reason = "idle"
+					_ = s.sendJSON(state)
+					s.end()
+					return
+				}
+				if !warnedIdle && idle >= s.opts.IdleTimeout/2 {
+					warnedIdle = true
+					_ = s.sendJSON(protocol.NewWarning("IDLE_TIMEOUT", "Session will close soon due to inactivity."))
+				} else if idle < s.opts.IdleTimeout/2 {
+					warnedIdle = false
+				}
+			}
+
+			if now.Sub(pingSent) >= s.opts.PingInterval {
+				pingSent = now
+				inFlight = true
+				go func() { pong <- c.Ping(context.Background()) }()
+			}
+		}
+	}
+}
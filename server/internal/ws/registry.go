@@ -0,0 +1,208 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/protocol"
+
+	"nhooyr.io/websocket"
+)
+
+// registry holds every live session, attached or not, keyed by resume token.
+type registry struct {
+	mu      sync.Mutex
+	byToken map[string]*Session
+}
+
+var sessions = &registry{byToken: make(map[string]*Session)}
+
+func (r *registry) add(s *Session) {
+	r.mu.Lock()
+	r.byToken[s.token] = s
+	r.mu.Unlock()
+}
+
+func (r *registry) remove(s *Session) {
+	r.mu.Lock()
+	if r.byToken[s.token] == s {
+		delete(r.byToken, s.token)
+	}
+	r.mu.Unlock()
+}
+
+func (r *registry) lookup(token string) *Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byToken[token]
+}
+
+func (r *registry) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.byToken)
+}
+
+func newID() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// attach binds c to the session, cancelling any pending expiry. A previous
+// connection (the client hopped networks before we noticed) is closed.
+func (s *Session) attach(c *websocket.Conn) bool {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return false
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	prev := s.c
+	s.c = c
+	s.mu.Unlock()
+	if prev != nil && prev != c {
+		go func() { _ = prev.Close(websocket.StatusGoingAway, "resumed on another connection") }()
+	}
+	return true
+}
+
+// detach parks the session after c dropped. It is ended if nobody resumes it
+// within ResumeGrace.
+func (s *Session) detach(c *websocket.Conn) {
+	s.mu.Lock()
+	if s.c != c || s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.c = nil
+	s.mu.Unlock()
+	// A hint finishing after the drop would be stale by the time the client
+	// is back; the hint_cancel is buffered and replayed on resume.
+	s.cancelHint("disconnected")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended || s.c != nil {
+		return
+	}
+	s.expiry = time.AfterFunc(s.opts.ResumeGrace, s.end)
+	s.log.Info("detached", "resumable_for", s.opts.ResumeGrace)
+}
+
+// end tears the session down for good.
+func (s *Session) end() {
+	s.endOnce.Do(func() {
+		s.mu.Lock()
+		s.ended = true
+		if s.expiry != nil {
+			s.expiry.Stop()
+			s.expiry = nil
+		}
+		c := s.c
+		s.c = nil
+		s.mu.Unlock()
+
+		s.cancelHint("stopped")
+		s.cancel()
+		s.hintWG.Wait()
+		sessions.remove(s)
+		if s.asr != nil {
+			s.asr.Flush()
+			s.asr.Close()
+		}
+		obs.DecSessionActive()
+		if c != nil {
+			_ = c.Close(websocket.StatusNormalClosure, "bye")
+		}
+	})
+}
+
+// resumeTarget handles a hello carrying a resume token. On success it moves
+// c onto the earlier session, replays what the client missed and returns
+// that session; the provisional session s is discarded. It returns nil when
+// data is not a resume request or the resume failed (the client is told and
+// keeps s).
+func (s *Session) resumeTarget(c *websocket.Conn, data []byte) *Session {
+	msg, err := protocol.Decode(data)
+	if err != nil {
+		return nil
+	}
+	hello, ok := msg.(protocol.Hello)
+	if !ok || hello.Resume == "" {
+		return nil
+	}
+	ver, err := protocol.Negotiate(hello.Protocol)
+	if err != nil {
+		return nil
+	}
+	old := sessions.lookup(hello.Resume)
+	if old == s {
+		return nil
+	}
+	if old == nil {
+		_ = s.sendJSON(protocol.NewError(protocol.CodeResumeFailed, "Session expired; starting a new one."))
+		return nil
+	}
+	// Holding sendMu from attach until the replay is written keeps newer
+	// messages from reaching c ahead of the ones the client missed.
+	old.sendMu.Lock()
+	if !old.attach(c) {
+		old.sendMu.Unlock()
+		_ = s.sendJSON(protocol.NewError(protocol.CodeResumeFailed, "Session expired; starting a new one."))
+		return nil
+	}
+	old.replayLocked(c, hello.LastSeq)
+	old.sendMu.Unlock()
+	// The provisional session never saw real traffic; drop it quietly.
+	s.mu.Lock()
+	s.c = nil
+	s.mu.Unlock()
+	s.end()
+
+	old.mu.Lock()
+	old.protoVer = ver
+	old.timing = hello.Timing
+	old.remote, old.log = s.remote, sessionLogger(old.id, s.remote)
+	old.mu.Unlock()
+	old.touch()
+	old.setAudioFormat(hello.Audio)
+	if hello.Mode != "" {
+		if err := old.ans.SetMode(hello.Mode); err != nil {
+			_ = old.sendJSON(protocol.NewWarning("UNKNOWN_MODE", err.Error()))
+		}
+	}
+	old.logger().Info("resumed", "last_seq", hello.LastSeq)
+	state := protocol.NewState(old.isListening())
+	state.Protocol, state.Mode = ver, old.ans.Mode()
+	state.SessionID, state.Resume, state.Resumed = old.id, old.token, true
+	_ = old.sendJSON(state)
+	return old
+}
+
+// replayLocked resends buffered messages newer than lastSeq on c. Caller
+// holds sendMu.
+func (s *Session) replayLocked(c *websocket.Conn, lastSeq uint64) {
+	gap := len(s.outbox) > 0 && s.outbox[0].seq > lastSeq+1
+	for _, m := range s.outbox {
+		if m.seq <= lastSeq {
+			continue
+		}
+		if err := s.write(c, m.data); err != nil {
+			return
+		}
+	}
+	if gap {
+		s.seq++
+		b, _ := protocol.Encode(protocol.NewWarning("RESUME_GAP", "Some messages were lost while reconnecting."), s.seq)
+		_ = s.write(c, b)
+	}
+}
@@ -2,182 +2,513 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cluely/server/internal/answer"
 	"cluely/server/internal/asr"
 	"cluely/server/internal/obs"
+	"cluely/server/internal/protocol"
 	"cluely/server/internal/rt"
+	"cluely/server/internal/trace"
 
 	"nhooyr.io/websocket"
 )
 
-// Upstream message (client -> server) minimal schema
-// Matches general_guide.md plus a "transcript" helper for MVP testing
-// {"type":"hello"}
-// {"type":"frame_meta","ocr":["token1","token2"]}
-// {"type":"stop"}
-// {"type":"transcript","text":"...","final":true}
-
-type upMsg struct {
-	Type  string   `json:"type"`
-	Text  string   `json:"text,omitempty"`
-	Final bool     `json:"final,omitempty"`
-	OCR   []string `json:"ocr,omitempty"`
-	First bool     `json:"first,omitempty"`
-	Last  bool     `json:"last,omitempty"`
-}
-
+// Session is one coaching session. It outlives any single WebSocket: when
+// the connection drops the session is parked in the registry for
+// ResumeGrace and a reconnecting client can pick it up again.
 type Session struct {
-	c            *websocket.Conn
+	id           string
+	token        string
+	c            *websocket.Conn // nil while detached
+	protoVer     int
+	opts         Options
+	ctx          context.Context
+	cancel       context.CancelFunc
+	lastRead     atomic.Int64 // unix nanos of the last upstream message
+	lastAudio    atomic.Int64 // unix nanos of the last frame sent to ASR
+	sendMu       sync.Mutex   // orders seq assignment with writes
+	seq          uint64
+	outbox       []outMsg
+	expiry       *time.Timer
+	ended        bool
+	endOnce      sync.Once
 	ans          *answer.Service
+	hintMu       sync.Mutex // guards hintGen/hintCancel and orders hint sends
+	hintGen      uint64
+	hintCancel   context.CancelFunc
+	hintWG       sync.WaitGroup
+	convo        *answer.Conversation
 	asr          asr.Client
+	vad          *asr.VAD  // nil when disabled; used only by the reading goroutine
+	pcm          asr.Input // nil when the client already sends PCM16k
+	pcmFormat    asr.Format
 	ocrTokens    []string
 	firstOCR     []string
 	lastOCR      []string
 	hints        *rt.RateLimiter
 	mu           sync.Mutex
+	remote       string       // client address of the current connection
+	log          *slog.Logger // carries id and remote
 	listening    bool
+	timing       bool // client asked for Timing messages
 	lastDropWarn time.Time
-	closedOnce   sync.Once
+	lastBadAudio time.Time
+	lastDown     map[string]time.Time // per error code; guarded by mu
+}
+
+type outMsg struct {
+	seq  uint64
+	data []byte
+}
+
+// Options tunes session behaviour. Zero fields fall back to DefaultOptions.
+type Options struct {
+	// PingInterval is how often the server pings the client.
+	PingInterval time.Duration `yaml:"pingInterval"`
+	// PongTimeout is how long a ping may stay unanswered before the peer is
+	// considered dead. A warning goes out at half this window.
+	PongTimeout time.Duration `yaml:"pongTimeout"`
+	// IdleTimeout closes sessions that answer pings but send nothing.
+	// Zero keeps silent peers forever, which is what a quiet meeting needs.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// ResumeGrace is how long a dropped session waits for its client to
+	// reconnect before it is torn down.
+	ResumeGrace time.Duration `yaml:"resumeGrace"`
+	// ReplayBuffer is how many downstream messages are kept for replay.
+	ReplayBuffer int `yaml:"replayBuffer"`
+	// ReadLimit caps the size of one upstream message in bytes.
+	ReadLimit int64 `yaml:"readLimit"`
+	// HistoryTokens and HistoryWindow bound the conversation memory fed to
+	// hint generation. Older turns are folded into a running summary unless
+	// DisableSummaries is set.
+	HistoryTokens    int           `yaml:"historyTokens"`
+	HistoryWindow    time.Duration `yaml:"historyWindow"`
+	DisableSummaries bool          `yaml:"disableSummaries"`
+	// HintInterval is the minimum spacing between generated hints, and
+	// HintTTL how long the glass shows a hint or follow-up.
+	HintInterval time.Duration `yaml:"hintInterval"`
+	HintTTL      time.Duration `yaml:"hintTTL"`
+	// DisableVAD forwards every audio frame to ASR and leaves utterance
+	// boundaries to the client's stop. VAD tunes the detector otherwise.
+	DisableVAD bool          `yaml:"disableVAD"`
+	VAD        asr.VADConfig `yaml:"vad"`
+	// MinConfidence is the score below which a hint is a guess, and
+	// LowConfidence what happens to it: LowConfidenceMute sends it marked
+	// muted, LowConfidenceSuppress drops it. Nil means 0.4; zero or a
+	// negative threshold lets every hint through.
+	MinConfidence *float64 `yaml:"minConfidence"`
+	LowConfidence string   `yaml:"lowConfidence"`
+	// ConnBurst and ConnEvery cap how fast one remote IP may open
+	// sessions: ConnBurst back to back, then one per ConnEvery.
+	ConnBurst int           `yaml:"connBurst"`
+	ConnEvery time.Duration `yaml:"connEvery"`
+
+	// LLM and ASR configure each session's hint engine and recognizer.
+	// They have sections of their own in the config file.
+	LLM answer.Config `yaml:"-"`
+	ASR asr.Config    `yaml:"-"`
+}
+
+// What a session does with hints scored below MinConfidence.
+const (
+	LowConfidenceMute     = "mute"
+	LowConfidenceSuppress = "suppress"
+)
+
+// Threshold returns a pointer to v, for Options.MinConfidence.
+func Threshold(v float64) *float64 { return &v }
+
+// DefaultOptions returns the production session settings.
+func DefaultOptions() Options {
+	return Options{
+		PingInterval:  15 * time.Second,
+		PongTimeout:   20 * time.Second,
+		ResumeGrace:   2 * time.Minute,
+		ReplayBuffer:  256,
+		ReadLimit:     1 << 20,
+		HistoryTokens: 600,
+		HistoryWindow: 5 * time.Minute,
+		HintInterval:  1500 * time.Millisecond,
+		HintTTL:       4500 * time.Millisecond,
+		MinConfidence: Threshold(0.4),
+		LowConfidence: LowConfidenceMute,
+		ConnBurst:     10,
+		ConnEvery:     2 * time.Second,
+		LLM:           answer.DefaultConfig(),
+	}
+}
+
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.PingInterval <= 0 {
+		o.PingInterval = d.PingInterval
+	}
+	if o.PongTimeout <= 0 {
+		o.PongTimeout = d.PongTimeout
+	}
+	if o.ResumeGrace <= 0 {
+		o.ResumeGrace = d.ResumeGrace
+	}
+	if o.ReplayBuffer <= 0 {
+		o.ReplayBuffer = d.ReplayBuffer
+	}
+	if o.ReadLimit <= 0 {
+		o.ReadLimit = d.ReadLimit
+	}
+	if o.HistoryTokens <= 0 {
+		o.HistoryTokens = d.HistoryTokens
+	}
+	if o.HistoryWindow <= 0 {
+		o.HistoryWindow = d.HistoryWindow
+	}
+	if o.HintInterval <= 0 {
+		o.HintInterval = d.HintInterval
+	}
+	if o.HintTTL <= 0 {
+		o.HintTTL = d.HintTTL
+	}
+	if o.MinConfidence == nil {
+		o.MinConfidence = d.MinConfidence
+	}
+	if o.LowConfidence == "" {
+		o.LowConfidence = d.LowConfidence
+	}
+	if o.ConnBurst <= 0 {
+		o.ConnBurst = d.ConnBurst
+	}
+	if o.ConnEvery <= 0 {
+		o.ConnEvery = d.ConnEvery
+	}
+	return o
 }
 
+// Validate reports session settings that withDefaults would not repair.
+// LLM and ASR are checked by their own packages.
+func (o Options) Validate() error {
+	var errs []error
+	switch o.LowConfidence {
+	case "", LowConfidenceMute, LowConfidenceSuppress:
+	default:
+		errs = append(errs, fmt.Errorf("lowConfidence %q is not mute or suppress", o.LowConfidence))
+	}
+	if o.MinConfidence != nil && *o.MinConfidence > 1 {
+		errs = append(errs, fmt.Errorf("minConfidence %g is above 1", *o.MinConfidence))
+	}
+	return errors.Join(errs...)
+}
+
+var defaultHandler = NewHandler(DefaultOptions())
+
+// Handle serves a session with DefaultOptions.
 func Handle(w http.ResponseWriter, r *http.Request) {
+	defaultHandler.ServeHTTP(w, r)
+}
+
+// Handler serves WebSocket sessions. Its options can be swapped while it
+// runs; sessions keep the options they started with.
+type Handler struct {
+	opts  atomic.Pointer[Options]
+	mu    sync.Mutex
+	conns *rt.KeyedLimiter // per remote IP; guarded by mu
+}
+
+// NewHandler returns a WebSocket handler whose sessions use opts.
+func NewHandler(opts Options) *Handler {
+	h := &Handler{}
+	h.SetOptions(opts)
+	return h
+}
+
+// SetOptions applies opts to sessions started from now on. The connection
+// limiter keeps its state unless its rate changed.
+func (h *Handler) SetOptions(opts Options) {
+	d := opts.withDefaults()
+	h.mu.Lock()
+	if h.conns == nil || h.conns.N != d.ConnBurst || h.conns.Every != d.ConnEvery {
+		h.conns = rt.NewKeyedLimiter(d.ConnBurst, d.ConnEvery, 10*time.Minute)
+	}
+	h.mu.Unlock()
+	h.opts.Store(&opts)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	conns := h.conns
+	h.mu.Unlock()
+	if !conns.Allow(remoteIP(r)) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	serve(w, r, *h.opts.Load())
+}
+
+func serve(w http.ResponseWriter, r *http.Request, opts Options) {
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		CompressionMode: websocket.CompressionDisabled,
 	})
 	if err != nil {
 		return
 	}
-	log.Printf("ws client connected: %s", r.RemoteAddr)
+	id := newID()
+	logger := sessionLogger(id, r.RemoteAddr)
+	logger.Info("client connected")
+	opts.ASR.Logger, opts.LLM.Logger = logger, logger
 	// Build ASR client (only if explicitly requested)
-	var asrClient asr.Client
-	switch provider := strings.ToLower(strings.TrimSpace(os.Getenv("ASR_PROVIDER"))); provider {
-	case "", "disabled", "none":
-		log.Printf("asr disabled via ASR_PROVIDER=%s", provider)
-	case "stub":
-		asrClient, err = asr.New("stub")
-		if err != nil {
-			log.Printf("asr init failed (fallback to transcript helper): %v", err)
-		}
-	case "gemini":
-		asrClient, err = asr.New("gemini")
-		if err != nil {
-			log.Printf("asr init failed (fallback to transcript helper): %v", err)
-		}
-	default:
-		log.Printf("asr provider %q not supported; disabling ASR", provider)
+	asrClient, err := asr.New(opts.ASR)
+	switch {
+	case err != nil:
+		logger.Warn("asr init failed; falling back to client transcripts", "err", err)
+		asrClient = nil
+	case asrClient == nil:
+		logger.Info("asr disabled", "provider", opts.ASR.Provider)
 	}
 	// Build session
+	opts = opts.withDefaults()
 	s := &Session{
-		c:         c,
-		ans:       answer.NewServiceFromEnv(),
+		id:        id,
+		token:     newID() + newID(),
+		remote:    r.RemoteAddr,
+		log:       logger,
+		opts:      opts,
+		ans:       answer.NewServiceFromConfig(opts.LLM),
 		asr:       asrClient,
-		hints:     rt.NewRateLimiter(1, 1500*time.Millisecond),
+		hints:     rt.NewRateLimiter(1, opts.HintInterval),
 		listening: false,
 	}
-	// Send initial state
-	_ = s.sendJSON(map[string]any{"type": "state", "listening": s.listening})
-	obs.IncSessionActive()
-	// Start main loop
-	s.run()
-}
-
-func (s *Session) run() {
-	ctx := context.Background()
-	s.c.SetReadLimit(1 << 20) // 1MB
-	defer func() {
-		if s.asr != nil {
-			s.asr.Flush()
-			s.asr.Close()
+	if !s.opts.DisableVAD {
+		s.vad = asr.NewVAD(s.opts.VAD)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.convo = answer.NewConversation(s.opts.HistoryTokens, s.opts.HistoryWindow)
+	if !s.opts.DisableSummaries {
+		s.convo.Summarizer = func(prev string, turns []answer.Turn) (string, error) {
+			return s.ans.Summarize(s.ctx, prev, turns)
 		}
-		obs.DecSessionActive()
-		s.close(websocket.StatusNormalClosure, "bye")
-	}()
-
+	}
+	sessions.add(s)
+	obs.IncSessionActive()
 	// Spawn goroutine to relay ASR events -> client
 	if s.asr != nil {
 		go s.relayASR()
 	}
+	s.attach(c)
+	// Send initial state
+	state := protocol.NewState(s.listening)
+	state.SessionID, state.Resume = s.id, s.token
+	_ = s.sendJSON(state)
+	// Start main loop
+	runConn(c, s)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
+// runConn reads from c until it closes. The session it feeds can change
+// mid-stream when the client resumes an earlier session.
+func runConn(c *websocket.Conn, s *Session) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.SetReadLimit(s.opts.ReadLimit)
+	s.touch()
+	stopKeepalive := s.startKeepalive(ctx, c)
+	defer func() { stopKeepalive() }()
+
+	// No per-read deadline: liveness is the keepalive loop's job, so a long
+	// silence in the meeting does not end the session.
 	for {
-		readCtx, cancel := context.WithTimeout(ctx, 35*time.Second)
-		typ, data, err := s.c.Read(readCtx)
-		cancel()
+		typ, data, err := c.Read(ctx)
 		if err != nil {
-			if websocket.CloseStatus(err) == websocket.StatusNormalClosure || websocket.CloseStatus(err) == websocket.StatusGoingAway {
-				return
+			switch websocket.CloseStatus(err) {
+			case websocket.StatusNormalClosure:
+				// The client said goodbye; nothing to resume.
+				s.end()
+			default:
+				if websocket.CloseStatus(err) != websocket.StatusGoingAway {
+					s.logger().Warn("read failed", "err", err)
+				}
+				s.detach(c)
 			}
-			log.Printf("ws read error: %v", err)
 			return
 		}
+		s.touch()
 		switch typ {
 		case websocket.MessageBinary:
-			// Forward PCM to ASR if available
 			obs.IncPCMFrame()
-			if s.asr != nil {
-				ok := s.asr.WritePCM(data)
-				if !ok {
-					// Rate-limit warnings to once every 2s
-					if time.Since(s.lastDropWarn) > 2*time.Second {
-						s.lastDropWarn = time.Now()
-						_ = s.sendJSON(map[string]any{
-							"type": "warning",
-							"code": "AUDIO_BACKPRESSURE",
-							"msg":  "Audio quality degraded (dropping frames).",
-						})
-					}
-				}
-			}
+			s.handlePCM(data)
 		case websocket.MessageText:
+			if next := s.resumeTarget(c, data); next != nil {
+				stopKeepalive()
+				s = next
+				stopKeepalive = s.startKeepalive(ctx, c)
+				continue
+			}
 			if err := s.handleText(data); err != nil {
-				log.Printf("handleText: %v", err)
+				s.logger().Warn("message not handled", "bytes", len(data), "err", err)
 			}
 		}
 	}
 }
 
+// handlePCM runs one binary frame through the VAD and forwards the speech
+// to ASR. Silence never reaches the vendor; the end of an utterance flushes
+// it so the final does not wait for the client's stop.
+func (s *Session) handlePCM(data []byte) {
+	if s.pcm != nil {
+		raw := len(data)
+		var err error
+		data, err = s.pcm.Decode(data)
+		if s.pcmFormat.Encoding.Compressed() {
+			obs.AddAudioDecoded(raw, len(data))
+		}
+		if err != nil {
+			obs.Error(obs.StageAudio, string(s.pcmFormat.Encoding), err)
+			if time.Since(s.lastBadAudio) > 2*time.Second {
+				s.lastBadAudio = time.Now()
+				s.logger().Warn("audio decode failed", "encoding", s.pcmFormat.Encoding, "err", err)
+				_ = s.sendJSON(protocol.NewWarning("AUDIO_DECODE_FAILED", "Some audio could not be decoded."))
+			}
+		}
+	}
+	audio := data
+	var events []asr.VADEvent
+	if s.vad != nil {
+		audio, events = s.vad.Process(data)
+	}
+	if s.asr != nil && len(audio) > 0 {
+		if s.asr.WritePCM(audio) {
+			s.lastAudio.Store(time.Now().UnixNano())
+		} else if time.Since(s.lastDropWarn) > 2*time.Second {
+			// Rate-limit warnings to once every 2s
+			s.lastDropWarn = time.Now()
+			_ = s.sendJSON(protocol.NewWarning("AUDIO_BACKPRESSURE", "Audio quality degraded (dropping frames)."))
+		}
+	}
+	for _, ev := range events {
+		speaking := ev == asr.SpeechStart
+		_ = s.sendJSON(protocol.NewVAD(speaking))
+		if !speaking && s.asr != nil {
+			s.asr.Flush()
+		}
+	}
+}
+
+// setAudioFormat switches the declared input format. An unsupported format
+// is refused with an error and the previous format stays in effect.
+func (s *Session) setAudioFormat(af *protocol.AudioFormat) {
+	if af == nil {
+		return
+	}
+	f, err := asr.ParseFormat(af.SampleRate, af.Channels, af.Encoding)
+	if err != nil {
+		_ = s.sendJSON(protocol.NewError(protocol.CodeUnsupportedAudio, err.Error()))
+		return
+	}
+	in, err := asr.NewInput(f)
+	if err != nil {
+		_ = s.sendJSON(protocol.NewError(protocol.CodeUnsupportedAudio, err.Error()))
+		return
+	}
+	if in != nil {
+		s.logger().Info("audio input", "format", f.String())
+	}
+	s.pcm, s.pcmFormat = in, f
+}
+
 func (s *Session) relayASR() {
 	for ev := range s.asr.Events() {
+		switch ev.Type {
+		case "error":
+			// Logged by the provider; a failover chain retries the audio.
+			if errors.Is(ev.Err, rt.ErrBreakerOpen) {
+				s.upstreamDown(protocol.CodeASRUnavailable, ev.Err)
+			}
+			continue
+		case "degraded":
+			_ = s.sendJSON(protocol.NewWarning(protocol.CodeASRDegraded, "Speech recognition switched to "+ev.Text+"."))
+			continue
+		case "unavailable":
+			s.cancelHint("asr unavailable")
+			s.setListening(false)
+			_ = s.sendJSON(protocol.NewError(protocol.CodeASRUnavailable, "Speech recognition is unavailable."))
+			_ = s.sendJSON(protocol.NewState(s.isListening()))
+			continue
+		}
 		// Track metrics
+		var tm *utteranceTiming
 		if ev.IsFinal {
 			obs.IncASRFinal()
+			tm = newUtteranceTiming("asr", s.lastAudioAt())
 		} else {
 			obs.IncASRPartial()
 		}
 		// Send partial/final to client
-		if err := s.sendJSON(map[string]any{"type": ev.Type, "text": ev.Text}); err != nil {
-			log.Printf("[session] send %s error: %v", ev.Type, err)
+		var msg protocol.Message = protocol.NewPartial(ev.Text)
+		if ev.IsFinal {
+			msg = protocol.NewFinal(ev.Text)
+		}
+		if err := s.sendJSON(msg); err != nil && !errors.Is(err, errDetached) {
+			s.logger().Warn("send failed", "type", msg.MessageType(), "err", err)
 		}
+		if !ev.IsFinal {
+			continue
+		}
+		mem := s.remember("", ev.Text)
 		// On final, generate and stream hint if rate-limit allows
-		if ev.IsFinal && s.hints.Allow() {
-			ocr, first, last := s.snapshotOCRContext()
-			if ans := s.ans.Micro(ev.Text, ocr, first, last); ans != nil {
-				s.streamAnswer(ans)
-			} else {
-				obs.IncErrorAnswer()
-			}
+		if s.hints.Allow() {
+			s.streamAnswer(ev.Text, ev.Confidence, mem, ev.Trace, tm)
 		}
 	}
 }
 
 func (s *Session) handleText(data []byte) error {
-	log.Printf("[session] received: %s", string(data))
-	var m upMsg
-	if err := json.Unmarshal(data, &m); err != nil {
+	msg, err := protocol.Decode(data)
+	if err != nil {
+		var perr *protocol.ProtocolError
+		if errors.As(err, &perr) {
+			_ = s.sendJSON(perr.Reply())
+		}
 		return err
 	}
-	switch strings.ToLower(m.Type) {
-	case "hello":
-		return s.sendJSON(map[string]any{"type": "state", "listening": s.listening})
-	case "frame_meta":
+	s.logMessage("received", msg, "bytes", len(data))
+	switch m := msg.(type) {
+	case protocol.Hello:
+		ver, err := protocol.Negotiate(m.Protocol)
+		if err != nil {
+			var perr *protocol.ProtocolError
+			if errors.As(err, &perr) {
+				_ = s.sendJSON(perr.Reply())
+			}
+			s.close(websocket.StatusPolicyViolation, "unsupported protocol version")
+			return err
+		}
+		s.mu.Lock()
+		s.protoVer = ver
+		s.timing = m.Timing
+		s.mu.Unlock()
+		s.setAudioFormat(m.Audio)
+		if m.Mode != "" {
+			if err := s.ans.SetMode(m.Mode); err != nil {
+				_ = s.sendJSON(protocol.NewWarning("UNKNOWN_MODE", err.Error()))
+			}
+		}
+		state := protocol.NewState(s.isListening())
+		state.Protocol, state.Mode = ver, s.ans.Mode()
+		return s.sendJSON(state)
+	case protocol.FrameMeta:
 		s.mu.Lock()
 		s.ocrTokens = m.OCR
 		if m.First {
@@ -188,29 +519,29 @@ func (s *Session) handleText(data []byte) error {
 		}
 		s.mu.Unlock()
 		return nil
-	case "stop":
+	case protocol.Stop:
+		s.cancelHint("stopped")
 		s.setListening(false)
 		if s.asr != nil {
 			s.asr.Flush()
 		}
-		return s.sendJSON(map[string]any{"type": "state", "listening": s.listening})
-	case "transcript":
-		if m.Text == "" {
-			return errors.New("empty transcript.text")
-		}
+		return s.sendJSON(protocol.NewState(s.isListening()))
+	case protocol.Transcript:
+		tm := newUtteranceTiming("transcript", time.Time{})
 		// Echo a final/partial to match contract
-		kind := "partial"
+		var echo protocol.Message = protocol.NewPartial(m.Text)
 		if m.Final {
-			kind = "final"
+			echo = protocol.NewFinal(m.Text)
 		}
-		if err := s.sendJSON(map[string]any{"type": kind, "text": m.Text}); err != nil {
+		if err := s.sendJSON(echo); err != nil {
 			return err
 		}
-		if m.Final && s.hints.Allow() {
-			ocr, first, last := s.snapshotOCRContext()
-			if ans := s.ans.Micro(m.Text, ocr, first, last); ans != nil {
-				s.streamAnswer(ans)
-			}
+		if !m.Final {
+			return nil
+		}
+		mem := s.remember(m.Speaker, m.Text)
+		if s.hints.Allow() {
+			s.streamAnswer(m.Text, m.Confidence, mem, trace.SpanContext{}, tm)
 		}
 		return nil
 	default:
@@ -218,6 +549,14 @@ func (s *Session) handleText(data []byte) error {
 	}
 }
 
+// remember records a final in the conversation memory and returns the memory
+// as it stood before it, so the prompt does not repeat the current utterance.
+func (s *Session) remember(speaker, text string) answer.Memory {
+	mem := s.convo.Snapshot()
+	s.convo.Add(speaker, text)
+	return mem
+}
+
 func (s *Session) snapshotOCRContext() (ocr, first, last []string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -232,51 +571,317 @@ func (s *Session) snapshotOCRContext() (ocr, first, last []string) {
 
 func (s *Session) setListening(v bool) { s.mu.Lock(); s.listening = v; s.mu.Unlock() }
 
-func (s *Session) sendJSON(v any) error {
+func (s *Session) isListening() bool { s.mu.Lock(); defer s.mu.Unlock(); return s.listening }
+
+func (s *Session) wantsTiming() bool { s.mu.Lock(); defer s.mu.Unlock(); return s.timing }
+
+// lastAudioAt is when the last frame went to ASR, zero if none has.
+func (s *Session) lastAudioAt() time.Time {
+	if n := s.lastAudio.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// sessionLogger returns the logger for session id on a connection from
+// remote.
+func sessionLogger(id, remote string) *slog.Logger {
+	return slog.Default().With("session", id, "remote", remote)
+}
+
+func (s *Session) logger() *slog.Logger { s.mu.Lock(); defer s.mu.Unlock(); return s.log }
+
+// logMessage logs msg at debug level. What the customer said, showed or was
+// told is passed through obs.Redact, so the log shows it only as the
+// redaction policy allows.
+func (s *Session) logMessage(event string, msg protocol.Message, args ...any) {
+	l := s.logger()
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	args = append([]any{"type", msg.MessageType()}, args...)
+	switch m := msg.(type) {
+	case protocol.Transcript:
+		args = append(args, "text", obs.Redact(m.Text))
+	case protocol.FrameMeta:
+		args = append(args, "ocr", obs.RedactAll(m.OCR))
+	case protocol.Partial:
+		args = append(args, "text", obs.Redact(m.Text))
+	case protocol.Final:
+		args = append(args, "text", obs.Redact(m.Text))
+	case protocol.HintPartial:
+		args = append(args, "text", obs.Redact(m.Text))
+	case protocol.Hint:
+		args = append(args, "text", obs.Redact(m.Text), "trace", m.TraceID)
+	case protocol.FollowupPartial:
+		args = append(args, "text", obs.Redact(m.Text))
+	case protocol.Followup:
+		args = append(args, "text", obs.Redact(m.Text))
+	}
+	l.Debug(event, args...)
+}
+
+// sendJSON stamps v with the next sequence number, keeps it for replay and
+// writes it to the current connection. While detached it is only buffered.
+func (s *Session) sendJSON(v protocol.Message) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.seq++
+	b, err := protocol.Encode(v, s.seq)
+	if err != nil {
+		return err
+	}
+	s.outbox = append(s.outbox, outMsg{seq: s.seq, data: b})
+	if n := len(s.outbox) - s.opts.ReplayBuffer; n > 0 {
+		s.outbox = append(s.outbox[:0:0], s.outbox[n:]...)
+	}
+	s.mu.Lock()
+	c := s.c
+	s.mu.Unlock()
+	if c == nil {
+		return errDetached
+	}
+	s.logMessage("sending", v, "seq", s.seq, "bytes", len(b))
+	return s.write(c, b)
+}
+
+var errDetached = errors.New("session detached")
+
+func (s *Session) write(c *websocket.Conn, b []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	b, _ := json.Marshal(v)
-	log.Printf("[session] sending: %s", string(b))
-	return s.c.Write(ctx, websocket.MessageText, b)
+	return c.Write(ctx, websocket.MessageText, b)
 }
 
+// close closes the current connection, if any. The session itself stays
+// resumable; see end.
 func (s *Session) close(code websocket.StatusCode, reason string) {
-	s.closedOnce.Do(func() { _ = s.c.Close(code, reason) })
+	s.mu.Lock()
+	c := s.c
+	s.mu.Unlock()
+	if c != nil {
+		_ = c.Close(code, reason)
+	}
 }
 
-// streamAnswer sends token-by-token partial updates to the client for a smoother UI,
-// then emits the final hint/followup events for stability.
-func (s *Session) streamAnswer(ans *answer.Answer) {
+// streamAnswer generates a hint for text and forwards hint_partial and
+// followup_partial as the model produces them. Each field is finalized as
+// soon as it is complete, so the hint lands before the follow-up finishes.
+// A newer call supersedes this one: its request is cancelled and nothing
+// more from it reaches the client. asrConf is the recognizer's confidence
+// in text, 0 if unknown; it feeds the hint's confidence score. The hint is
+// traced as a child of utterance, or in a trace of its own if that is zero,
+// and its stages are timestamped in tm and reported once it is done.
+//
+// A hint scored below MinConfidence is sent muted, or with
+// LowConfidenceSuppress retracted with hint_cancel "low_confidence" along
+// with its follow-up.
+func (s *Session) streamAnswer(text string, asrConf float64, mem answer.Memory, utterance trace.SpanContext, tm *utteranceTiming) {
+	if err := s.ans.Available(); err != nil {
+		s.upstreamDown(protocol.CodeLLMUnavailable, err)
+		return
+	}
+	ocr, first, last := s.snapshotOCRContext()
+	ctx, gen := s.beginHint()
+	ctx, span := trace.Start(trace.ContextWith(ctx, utterance), "hint", trace.String("session.id", s.id))
+	s.hintWG.Add(1)
 	go func() {
-		// stream answer tokens
-		if strings.TrimSpace(ans.Answer) != "" {
-			var partial string
-			toks := strings.Fields(ans.Answer)
-			for i, t := range toks {
-				if i > 0 {
-					partial += " "
+		defer s.hintWG.Done()
+		outcome := "failed"
+		defer func() {
+			if outcome != "superseded" && outcome != "canceled" {
+				s.reportTiming(tm, span.Context().TraceID.String())
+			}
+			span.SetAttrs(trace.String("hint.outcome", outcome))
+			span.End()
+		}()
+		var (
+			sentAnswer, sentFollowUp string
+			answerDone, followUpDone bool
+			suppressed, muted        bool
+			sig                      answer.Signals
+		)
+		suppress := s.opts.LowConfidence == LowConfidenceSuppress
+		minConf := *s.opts.MinConfidence
+		low := func() bool { return sig.Score() < minConf }
+		dropLow := func() {
+			suppressed = true
+			outcome = "suppressed"
+			obs.IncHintSuppressed()
+			if sentAnswer != "" || sentFollowUp != "" {
+				s.sendHint(gen, protocol.NewHintCancel("low_confidence"))
+			}
+		}
+		finishAnswer := func(t string) {
+			if answerDone || suppressed || strings.TrimSpace(t) == "" {
+				return
+			}
+			answerDone = true
+			if low() {
+				if suppress {
+					dropLow()
+					return
+				}
+				muted = true
+				obs.IncHintMuted()
+			}
+			msg := protocol.NewHint(t, ttlMs(s.opts.HintTTL))
+			msg.Confidence, msg.Muted = sig.Score(), muted
+			msg.TraceID = span.Context().TraceID.String()
+			_, write := trace.Start(ctx, "ws.write", trace.String("ws.message", msg.Type))
+			sent := s.sendHint(gen, msg)
+			write.End()
+			outcome = "superseded"
+			if sent {
+				tm.hint = time.Now()
+				outcome = "sent"
+				if muted {
+					outcome = "muted"
 				}
-				partial += t
-				_ = s.sendJSON(map[string]any{"type": "hint_partial", "text": partial})
-				time.Sleep(50 * time.Millisecond)
+				span.SetAttrs(trace.Float("hint.confidence", msg.Confidence))
+				obs.IncHint()
+				obs.HintLatency.With(s.ans.Provider().Name(), s.ans.Mode()).Observe(tm.hint.Sub(tm.final).Seconds())
 			}
-			obs.IncHint()
-			_ = s.sendJSON(map[string]any{"type": "hint", "text": ans.Answer, "ttlMs": 4500})
 		}
-		// stream follow-up tokens
-		if strings.TrimSpace(ans.FollowUp) != "" {
-			var partial string
-			toks := strings.Fields(ans.FollowUp)
-			for i, t := range toks {
-				if i > 0 {
-					partial += " "
+		finishFollowUp := func(t string) {
+			if followUpDone || suppressed || strings.TrimSpace(t) == "" {
+				return
+			}
+			followUpDone = true
+			msg := protocol.NewFollowup(t, ttlMs(s.opts.HintTTL))
+			msg.Confidence, msg.Muted = sig.Score(), muted
+			if s.sendHint(gen, msg) {
+				obs.IncFollowup()
+			}
+		}
+
+		ans := s.ans.MicroStream(ctx, text, ocr, first, last, mem, func(p answer.Partial) {
+			if suppressed {
+				return
+			}
+			if tm.firstToken.IsZero() {
+				tm.firstToken = p.FirstToken
+			}
+			sig = p.Signals
+			sig.ASR = asrConf
+			// The model's confidence comes first; a guess is dropped
+			// before any of it reaches the glass.
+			if suppress && !answerDone && sig.HasModel && low() {
+				dropLow()
+				return
+			}
+			if !answerDone && p.Answer != sentAnswer && strings.TrimSpace(p.Answer) != "" {
+				sentAnswer = p.Answer
+				if s.sendHint(gen, protocol.NewHintPartial(p.Answer)) && tm.firstPartial.IsZero() {
+					tm.firstPartial = time.Now()
 				}
-				partial += t
-				_ = s.sendJSON(map[string]any{"type": "followup_partial", "text": partial})
-				time.Sleep(50 * time.Millisecond)
 			}
-			obs.IncFollowup()
-			_ = s.sendJSON(map[string]any{"type": "followup", "text": ans.FollowUp, "ttlMs": 4500})
+			if p.AnswerDone {
+				finishAnswer(p.Answer)
+			}
+			if !followUpDone && p.FollowUp != sentFollowUp && strings.TrimSpace(p.FollowUp) != "" {
+				sentFollowUp = p.FollowUp
+				s.sendHint(gen, protocol.NewFollowupPartial(p.FollowUp))
+			}
+			if p.FollowUpDone {
+				finishFollowUp(p.FollowUp)
+			}
+		})
+		if ans == nil {
+			// Counted by the answer service.
+			if ctx.Err() != nil && !suppressed {
+				outcome = "canceled"
+			}
+			s.finishHint(gen)
+			return
 		}
+		if tm.firstToken.IsZero() {
+			tm.firstToken = ans.FirstToken
+		}
+		if !suppressed {
+			sig = ans.Signals
+			sig.ASR = asrConf
+		}
+		finishAnswer(ans.Answer)
+		finishFollowUp(ans.FollowUp)
+		s.finishHint(gen)
 	}()
 }
+
+func ttlMs(d time.Duration) int { return int(d / time.Millisecond) }
+
+// reportTiming records tm's stages in the latency histograms and, if the
+// client asked for it, sends the breakdown.
+func (s *Session) reportTiming(tm *utteranceTiming, traceID string) {
+	msg := tm.report(traceID)
+	if s.wantsTiming() {
+		_ = s.sendJSON(msg)
+	}
+}
+
+// upstreamDown tells the client an upstream's breaker is open, at most once
+// per 5s per code.
+func (s *Session) upstreamDown(code string, err error) {
+	s.mu.Lock()
+	if time.Since(s.lastDown[code]) < 5*time.Second {
+		s.mu.Unlock()
+		return
+	}
+	if s.lastDown == nil {
+		s.lastDown = make(map[string]time.Time)
+	}
+	s.lastDown[code] = time.Now()
+	s.mu.Unlock()
+	_ = s.sendJSON(protocol.NewError(code, err.Error()))
+}
+
+// beginHint starts a new hint generation, superseding any in flight.
+func (s *Session) beginHint() (context.Context, uint64) {
+	s.hintMu.Lock()
+	defer s.hintMu.Unlock()
+	s.cancelHintLocked("superseded")
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.hintGen++
+	s.hintCancel = cancel
+	return ctx, s.hintGen
+}
+
+// sendHint sends msg only if generation gen is still current. Holding hintMu
+// across the write means a cancel cannot slip in between check and send.
+func (s *Session) sendHint(gen uint64, msg protocol.Message) bool {
+	s.hintMu.Lock()
+	defer s.hintMu.Unlock()
+	if gen != s.hintGen || s.hintCancel == nil {
+		return false
+	}
+	_ = s.sendJSON(msg)
+	return true
+}
+
+// finishHint marks generation gen as complete.
+func (s *Session) finishHint(gen uint64) {
+	s.hintMu.Lock()
+	defer s.hintMu.Unlock()
+	if gen == s.hintGen && s.hintCancel != nil {
+		s.hintCancel()
+		s.hintCancel = nil
+	}
+}
+
+// cancelHint abandons the in-flight generation, if any.
+func (s *Session) cancelHint(reason string) {
+	s.hintMu.Lock()
+	defer s.hintMu.Unlock()
+	s.cancelHintLocked(reason)
+}
+
+// cancelHintLocked cancels the current generation and tells the client to
+// drop its partials. Caller holds hintMu.
+func (s *Session) cancelHintLocked(reason string) {
+	if s.hintCancel == nil {
+		return
+	}
+	s.hintCancel()
+	s.hintCancel = nil
+	_ = s.sendJSON(protocol.NewHintCancel(reason))
+}
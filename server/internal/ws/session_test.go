@@ -0,0 +1,746 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cluely/server/internal/answer"
+	"cluely/server/internal/asr"
+	"cluely/server/internal/obs"
+	"cluely/server/internal/protocol"
+	"cluely/server/internal/rt"
+
+	"nhooyr.io/websocket"
+)
+
+// rules is the offline hint engine, so tests need no network.
+var rules = answer.Config{Provider: "rules"}
+
+func dialTestServer(t *testing.T, opts Options) (*websocket.Conn, func()) {
+	t.Helper()
+	srv := httptest.NewServer(NewHandler(opts))
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("dial: %v", err)
+	}
+	return c, func() {
+		_ = c.Close(websocket.StatusNormalClosure, "")
+		srv.Close()
+	}
+}
+
+// readAll reads until the connection closes or ctx expires and returns the
+// decoded text messages plus the final read error.
+func readAll(ctx context.Context, c *websocket.Conn) ([]map[string]any, error) {
+	var msgs []map[string]any
+	for {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			return msgs, err
+		}
+		var m map[string]any
+		if json.Unmarshal(data, &m) == nil {
+			msgs = append(msgs, m)
+		}
+	}
+}
+
+func TestKeepaliveKeepsSilentPeer(t *testing.T) {
+	c, done := dialTestServer(t, Options{PingInterval: 20 * time.Millisecond, PongTimeout: 100 * time.Millisecond})
+	defer done()
+
+	// Reading answers the server's pings; we never send anything ourselves.
+	// nhooyr closes a conn whose Read context expires, so read unbounded.
+	msgs := make(chan map[string]any, 16)
+	go func() {
+		defer close(msgs)
+		for {
+			_, data, err := c.Read(context.Background())
+			if err != nil {
+				return
+			}
+			var m map[string]any
+			if json.Unmarshal(data, &m) == nil {
+				msgs <- m
+			}
+		}
+	}()
+
+	next := func() map[string]any {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+		return nil
+	}
+	if m := next(); m["type"] != "state" {
+		t.Fatalf("expected initial state, got %v", m)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case m, ok := <-msgs:
+		if !ok {
+			t.Fatal("server closed a silent but live peer")
+		}
+		t.Fatalf("unexpected message for silent peer: %v", m)
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Write(ctx, websocket.MessageText, []byte(`{"type":"hello"}`)); err != nil {
+		t.Fatalf("write after silence: %v", err)
+	}
+	if m := next(); m["type"] != "state" {
+		t.Fatalf("expected state reply to hello, got %v", m)
+	}
+}
+
+func TestKeepaliveClosesDeadPeer(t *testing.T) {
+	c, done := dialTestServer(t, Options{PingInterval: 20 * time.Millisecond, PongTimeout: 100 * time.Millisecond})
+	defer done()
+
+	// Not reading means pings go unanswered, which is how a dead peer looks.
+	time.Sleep(300 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msgs, err := readAll(ctx, c)
+	if got := websocket.CloseStatus(err); got != websocket.StatusGoingAway {
+		t.Fatalf("expected going-away close, got %v (%v)", got, err)
+	}
+	var codes []string
+	for _, m := range msgs {
+		if m["type"] == "warning" {
+			codes = append(codes, m["code"].(string))
+		}
+	}
+	if strings.Join(codes, ",") != "CONNECTION_UNSTABLE,PEER_UNRESPONSIVE" {
+		t.Fatalf("unexpected warnings before close: %v", codes)
+	}
+}
+
+func TestIdleTimeoutWarnsThenCloses(t *testing.T) {
+	c, done := dialTestServer(t, Options{
+		PingInterval: 10 * time.Millisecond,
+		PongTimeout:  time.Second,
+		IdleTimeout:  150 * time.Millisecond,
+	})
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msgs, err := readAll(ctx, c)
+	if got := websocket.CloseStatus(err); got != websocket.StatusNormalClosure {
+		t.Fatalf("expected normal close, got %v (%v) after %v", got, err, msgs)
+	}
+	var sawWarning, sawIdleState bool
+	for _, m := range msgs {
+		if m["type"] == "warning" && m["code"] == "IDLE_TIMEOUT" {
+			sawWarning = true
+		}
+		if m["type"] == "state" && m["reason"] == "idle" {
+			sawIdleState = true
+		}
+	}
+	if !sawWarning || !sawIdleState {
+		t.Fatalf("expected idle warning and state before close, got %v", msgs)
+	}
+}
+
+func TestRejectsUnknownMessage(t *testing.T) {
+	c, done := dialTestServer(t, Options{})
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := c.Read(ctx); err != nil {
+		t.Fatalf("read initial state: %v", err)
+	}
+	if err := c.Write(ctx, websocket.MessageText, []byte(`{"type":"shout"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, data, err := c.Read(ctx)
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	var m map[string]any
+	_ = json.Unmarshal(data, &m)
+	if m["type"] != "error" || m["code"] != "UNKNOWN_TYPE" {
+		t.Fatalf("expected UNKNOWN_TYPE error, got %s", data)
+	}
+}
+
+func readMsg(t *testing.T, ctx context.Context, c *websocket.Conn) map[string]any {
+	t.Helper()
+	_, data, err := c.Read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return m
+}
+
+func TestConnLimiterRefusesBurstPerIP(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Options{ConnBurst: 1, ConnEvery: time.Hour}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close(websocket.StatusNormalClosure, "")
+	_, resp, err := websocket.Dial(ctx, url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second dial within the burst window: want 429, got %v (%v)", resp, err)
+	}
+}
+
+func TestResumeReplaysMissedMessages(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Options{}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	c1, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	first := readMsg(t, ctx, c1)
+	token, _ := first["resume"].(string)
+	id, _ := first["sessionId"].(string)
+	if first["type"] != "state" || token == "" || id == "" {
+		t.Fatalf("expected state with session id and resume token, got %v", first)
+	}
+	lastSeq := first["seq"].(float64)
+
+	// Simulate a network drop, then produce output while detached.
+	_ = c1.Close(websocket.StatusGoingAway, "wifi hop")
+	s := sessions.lookup(token)
+	if s == nil {
+		t.Fatal("session not kept after drop")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		detached := s.c == nil
+		s.mu.Unlock()
+		if detached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session never detached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = s.sendJSON(protocol.NewHint("Confirm budget owner", 4500))
+
+	c2, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	defer c2.Close(websocket.StatusNormalClosure, "")
+	if m := readMsg(t, ctx, c2); m["sessionId"] == id {
+		t.Fatalf("fresh connection should start a provisional session, got %v", m)
+	}
+	hello, _ := json.Marshal(protocol.Hello{Type: protocol.TypeHello, Resume: token, LastSeq: uint64(lastSeq), Mode: "interview", Timing: true})
+	if err := c2.Write(ctx, websocket.MessageText, hello); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+	replayed := readMsg(t, ctx, c2)
+	if replayed["type"] != "hint" || replayed["text"] != "Confirm budget owner" {
+		t.Fatalf("expected replayed hint, got %v", replayed)
+	}
+	state := readMsg(t, ctx, c2)
+	if state["type"] != "state" || state["resumed"] != true || state["sessionId"] != id {
+		t.Fatalf("expected resumed state for %s, got %v", id, state)
+	}
+	if state["mode"] != "interview" || !s.wantsTiming() {
+		t.Fatalf("resume hello's mode and timing not applied: %v", state)
+	}
+	if state["seq"].(float64) <= replayed["seq"].(float64) {
+		t.Fatalf("sequence numbers must keep increasing: %v then %v", replayed, state)
+	}
+}
+
+func TestResumeAfterGraceFails(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Options{ResumeGrace: 30 * time.Millisecond}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	c1, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	token := readMsg(t, ctx, c1)["resume"].(string)
+	_ = c1.Close(websocket.StatusGoingAway, "wifi hop")
+	time.Sleep(200 * time.Millisecond)
+	if sessions.lookup(token) != nil {
+		t.Fatal("expired session still registered")
+	}
+
+	c2, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	defer c2.Close(websocket.StatusNormalClosure, "")
+	_ = readMsg(t, ctx, c2)
+	hello, _ := json.Marshal(protocol.Hello{Type: protocol.TypeHello, Resume: token})
+	if err := c2.Write(ctx, websocket.MessageText, hello); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+	if m := readMsg(t, ctx, c2); m["type"] != "error" || m["code"] != protocol.CodeResumeFailed {
+		t.Fatalf("expected RESUME_FAILED, got %v", m)
+	}
+	if m := readMsg(t, ctx, c2); m["type"] != "state" || m["resumed"] == true {
+		t.Fatalf("expected fresh state after failed resume, got %v", m)
+	}
+}
+
+func TestNewerFinalSupersedesInFlightHint(t *testing.T) {
+	firstCancelled := make(chan struct{})
+	var calls atomic.Int32
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if calls.Add(1) == 1 {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"answer\\\":\\\"Old\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			close(firstCancelled)
+			return
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"answer\\\":\\\"New hint\\\",\\\"followUp\\\":\\\"Next?\\\"}\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer llm.Close()
+	c, done := dialTestServer(t, Options{
+		HintInterval: time.Nanosecond,
+		LLM:          answer.Config{Provider: "openai", OpenAI: answer.Endpoint{BaseURL: llm.URL}},
+	})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_ = readMsg(t, ctx, c) // initial state
+
+	send := func(text string) {
+		b, _ := json.Marshal(protocol.Transcript{Type: protocol.TypeTranscript, Text: text, Final: true})
+		if err := c.Write(ctx, websocket.MessageText, b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	expect := func(typ, text string) map[string]any {
+		t.Helper()
+		m := readMsg(t, ctx, c)
+		if m["type"] != typ || (text != "" && m["text"] != text) {
+			t.Fatalf("expected %s %q, got %v", typ, text, m)
+		}
+		return m
+	}
+
+	send("first question")
+	expect("final", "first question")
+	expect("hint_partial", "Old")
+
+	send("second question")
+	expect("final", "second question")
+	if m := expect("hint_cancel", ""); m["reason"] != "superseded" {
+		t.Fatalf("expected superseded reason, got %v", m)
+	}
+	expect("hint_partial", "New hint")
+	expect("hint", "New hint")
+	expect("followup_partial", "Next?")
+	expect("followup", "Next?")
+
+	select {
+	case <-firstCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("superseded LLM request was not cancelled")
+	}
+}
+
+func TestVADReportsSpeechBoundaries(t *testing.T) {
+	c, done := dialTestServer(t, Options{VAD: asr.VADConfig{Hangover: 100 * time.Millisecond}})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_ = readMsg(t, ctx, c) // initial state
+
+	// 20ms frames of a 200 Hz square wave, then of silence.
+	pcm := func(amp int16) []byte {
+		b := make([]byte, 640)
+		for i := 0; i < len(b)/2; i++ {
+			v := amp
+			if (i/40)%2 == 1 {
+				v = -amp
+			}
+			binary.LittleEndian.PutUint16(b[2*i:], uint16(v))
+		}
+		return b
+	}
+	for _, amp := range []int16{4000, 0} {
+		for i := 0; i < 15; i++ {
+			if err := c.Write(ctx, websocket.MessageBinary, pcm(amp)); err != nil {
+				t.Fatalf("write pcm: %v", err)
+			}
+		}
+	}
+	for _, want := range []bool{true, false} {
+		m := readMsg(t, ctx, c)
+		if m["type"] != protocol.TypeVAD || m["speaking"] != want {
+			t.Fatalf("expected vad speaking=%v, got %v", want, m)
+		}
+	}
+}
+
+func TestHelloAudioFormatIsNegotiated(t *testing.T) {
+	c, done := dialTestServer(t, Options{VAD: asr.VADConfig{Hangover: 100 * time.Millisecond}})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_ = readMsg(t, ctx, c) // initial state
+
+	hello := func(af protocol.AudioFormat) {
+		b, _ := json.Marshal(protocol.Hello{Type: protocol.TypeHello, Audio: &af})
+		if err := c.Write(ctx, websocket.MessageText, b); err != nil {
+			t.Fatalf("write hello: %v", err)
+		}
+	}
+
+	hello(protocol.AudioFormat{Encoding: "opus"})
+	if m := readMsg(t, ctx, c); m["type"] != "error" || m["code"] != protocol.CodeUnsupportedAudio {
+		t.Fatalf("expected UNSUPPORTED_AUDIO, got %v", m)
+	}
+	_ = readMsg(t, ctx, c) // state
+
+	hello(protocol.AudioFormat{SampleRate: 48000, Channels: 2, Encoding: "f32le"})
+	if m := readMsg(t, ctx, c); m["type"] != "state" {
+		t.Fatalf("expected state, got %v", m)
+	}
+	// 20ms of a 200 Hz square wave as 48 kHz stereo float.
+	frame := make([]byte, 960*8)
+	for i := 0; i < 960; i++ {
+		v := float32(0.2)
+		if (i/120)%2 == 1 {
+			v = -v
+		}
+		binary.LittleEndian.PutUint32(frame[8*i:], math.Float32bits(v))
+		binary.LittleEndian.PutUint32(frame[8*i+4:], math.Float32bits(v))
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.Write(ctx, websocket.MessageBinary, frame); err != nil {
+			t.Fatalf("write pcm: %v", err)
+		}
+	}
+	if m := readMsg(t, ctx, c); m["type"] != protocol.TypeVAD || m["speaking"] != true {
+		t.Fatalf("expected converted audio to trigger vad, got %v", m)
+	}
+}
+
+func TestHelloSelectsMode(t *testing.T) {
+	c, done := dialTestServer(t, Options{})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if m := readMsg(t, ctx, c); m["mode"] != nil {
+		t.Fatalf("initial state should not announce a mode, got %v", m)
+	}
+
+	hello := func(mode string) {
+		b, _ := json.Marshal(protocol.Hello{Type: protocol.TypeHello, Mode: mode})
+		if err := c.Write(ctx, websocket.MessageText, b); err != nil {
+			t.Fatalf("write hello: %v", err)
+		}
+	}
+	hello("")
+	if m := readMsg(t, ctx, c); m["type"] != "state" || m["mode"] != "sales" {
+		t.Fatalf("expected default sales mode, got %v", m)
+	}
+	hello("interview")
+	if m := readMsg(t, ctx, c); m["type"] != "state" || m["mode"] != "interview" {
+		t.Fatalf("expected interview mode, got %v", m)
+	}
+	hello("karaoke")
+	if m := readMsg(t, ctx, c); m["type"] != "warning" || m["code"] != "UNKNOWN_MODE" {
+		t.Fatalf("expected UNKNOWN_MODE, got %v", m)
+	}
+	if m := readMsg(t, ctx, c); m["mode"] != "interview" {
+		t.Fatalf("unknown mode should keep interview, got %v", m)
+	}
+}
+
+func TestStubASRDrivesHintPipeline(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Options{
+		LLM: rules,
+		ASR: asr.Config{Provider: "stub", Stub: asr.StubConfig{Lines: []string{"what is the budget"}, Pace: "time", Word: 5 * time.Millisecond}},
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	seen := map[string]bool{}
+	for !seen["hint"] {
+		m := readMsg(t, ctx, c)
+		typ, _ := m["type"].(string)
+		seen[typ] = true
+		if typ == "final" && m["text"] != "what is the budget" {
+			t.Fatalf("unexpected final %v", m)
+		}
+		if typ == "hint" && !seen["final"] {
+			t.Fatal("hint arrived before the final")
+		}
+	}
+	if !seen["partial"] {
+		t.Fatal("expected partials before the final")
+	}
+}
+
+func TestASRFailoverReportsDegradedThenUnavailable(t *testing.T) {
+	// Nothing listens on the recognizer URL, so every provider fails to dial.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	srv := httptest.NewServer(NewHandler(Options{
+		DisableVAD: true,
+		ASR:        asr.Config{Provider: "vosk,vosk", Vosk: asr.VoskConfig{URL: "ws" + strings.TrimPrefix(dead.URL, "http")}},
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close(websocket.StatusNormalClosure, "")
+	readMsg(t, ctx, c) // initial state
+
+	if err := c.Write(ctx, websocket.MessageBinary, make([]byte, 640)); err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for len(codes) < 2 {
+		m := readMsg(t, ctx, c)
+		if code, ok := m["code"].(string); ok {
+			codes = append(codes, code)
+		}
+	}
+	if codes[0] != protocol.CodeASRDegraded || codes[1] != protocol.CodeASRUnavailable {
+		t.Fatalf("got codes %v", codes)
+	}
+	if m := readMsg(t, ctx, c); m["type"] != "state" || m["listening"] != false {
+		t.Fatalf("expected listening:false state, got %v", m)
+	}
+}
+
+func TestOpenBreakerFailsHintAtOnce(t *testing.T) {
+	b := rt.DefaultBreakers.Get("llm:rules")
+	for b.State() != rt.BreakerOpen {
+		_ = b.Allow()
+		b.Record(errors.New("down"))
+	}
+	t.Cleanup(func() { b.Record(nil) })
+
+	c, done := dialTestServer(t, Options{HintInterval: time.Nanosecond, LLM: rules})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	readMsg(t, ctx, c) // initial state
+	if err := c.Write(ctx, websocket.MessageText, []byte(`{"type":"transcript","text":"what is the budget","final":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	readMsg(t, ctx, c) // final echo
+	m := readMsg(t, ctx, c)
+	if m["type"] != "error" || m["code"] != protocol.CodeLLMUnavailable {
+		t.Fatalf("expected LLM_UNAVAILABLE, got %v", m)
+	}
+}
+
+func TestLowConfidenceHintIsMutedOrSuppressed(t *testing.T) {
+	for _, mode := range []string{LowConfidenceMute, LowConfidenceSuppress} {
+		t.Run(mode, func(t *testing.T) {
+			c, done := dialTestServer(t, Options{HintInterval: time.Nanosecond, LowConfidence: mode, LLM: rules})
+			defer done()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			readMsg(t, ctx, c) // initial state
+
+			// No rule matches a bare "hello": the fallback is a guess.
+			send := func(text string, conf float64) {
+				b, _ := json.Marshal(protocol.Transcript{Type: protocol.TypeTranscript, Text: text, Final: true, Confidence: conf})
+				if err := c.Write(ctx, websocket.MessageText, b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			send("hello", 0)
+			readMsg(t, ctx, c) // final echo
+			if mode == LowConfidenceMute {
+				readMsg(t, ctx, c) // hint_partial
+				if m := readMsg(t, ctx, c); m["type"] != "hint" || m["muted"] != true {
+					t.Fatalf("expected a muted hint, got %v", m)
+				}
+				readMsg(t, ctx, c) // followup_partial
+				if m := readMsg(t, ctx, c); m["type"] != "followup" || m["muted"] != true {
+					t.Fatalf("expected a muted followup, got %v", m)
+				}
+			}
+
+			send("what is the budget", 0.9)
+			if m := readMsg(t, ctx, c); m["type"] != "final" || m["text"] != "what is the budget" {
+				t.Fatalf("expected the next final with nothing from the guess, got %v", m)
+			}
+			readMsg(t, ctx, c) // hint_partial
+			m := readMsg(t, ctx, c)
+			if m["type"] != "hint" || m["muted"] != nil || m["confidence"].(float64) < 0.4 {
+				t.Fatalf("expected a confident hint, got %v", m)
+			}
+		})
+	}
+}
+
+func TestTimingBreaksDownHintLatency(t *testing.T) {
+	c, done := dialTestServer(t, Options{LLM: rules})
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	readMsg(t, ctx, c) // initial state
+
+	for _, v := range []any{
+		protocol.Hello{Type: protocol.TypeHello, Protocol: protocol.Version, Timing: true},
+		protocol.Transcript{Type: protocol.TypeTranscript, Text: "what is the budget", Final: true},
+	} {
+		b, _ := json.Marshal(v)
+		if err := c.Write(ctx, websocket.MessageText, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var hint map[string]any
+	for {
+		m := readMsg(t, ctx, c)
+		if m["type"] == "hint" {
+			hint = m
+		}
+		if m["type"] != "timing" {
+			continue
+		}
+		if hint == nil {
+			t.Fatalf("timing %v arrived before the hint", m)
+		}
+		if m["source"] != "transcript" || m["traceId"] == nil || m["traceId"] != hint["traceId"] {
+			t.Fatalf("timing %v does not match hint %v", m, hint)
+		}
+		// Stages are cumulative from the end of speech.
+		var prev float64
+		for _, stage := range []string{"finalMs", "firstTokenMs", "firstPartialMs", "hintMs"} {
+			ms, _ := m[stage].(float64)
+			if ms < 1 || ms < prev {
+				t.Fatalf("%s = %v after %v in %v", stage, m[stage], prev, m)
+			}
+			prev = ms
+		}
+		return
+	}
+}
+
+// lockedBuffer collects log output written from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDebugLogsRedactCustomerText(t *testing.T) {
+	var out lockedBuffer
+	prev := slog.Default()
+	slog.SetDefault(obs.NewLogger(&out, obs.LogConfig{Level: slog.LevelDebug, Format: "json"}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	c, done := dialTestServer(t, Options{LLM: rules})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	readMsg(t, ctx, c) // initial state
+	for _, v := range []any{
+		protocol.FrameMeta{Type: protocol.TypeFrameMeta, OCR: []string{"acme", "renewal"}},
+		protocol.Transcript{Type: protocol.TypeTranscript, Text: "what is the budget", Final: true},
+	} {
+		b, _ := json.Marshal(v)
+		if err := c.Write(ctx, websocket.MessageText, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hint := readMsg(t, ctx, c)
+	for hint["type"] != "hint" {
+		hint = readMsg(t, ctx, c)
+	}
+	done()
+
+	logs := out.String()
+	for _, secret := range []string{"budget", "acme", "renewal", hint["text"].(string)} {
+		if strings.Contains(logs, secret) {
+			t.Fatalf("log contains %q:\n%s", secret, logs)
+		}
+	}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		if rec["session"] == nil || rec["remote"] == nil {
+			t.Errorf("line without session fields: %s", line)
+		}
+		if typ, _ := rec["type"].(string); typ != "" {
+			seen[rec["msg"].(string)+" "+typ] = true
+		}
+	}
+	for _, want := range []string{"received transcript", "received frame_meta", "sending final", "sending hint"} {
+		if !seen[want] {
+			t.Errorf("no %q line in:\n%s", want, logs)
+		}
+	}
+}
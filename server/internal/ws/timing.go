@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"time"
+
+	"cluely/server/internal/obs"
+	"cluely/server/internal/protocol"
+)
+
+// utteranceTiming timestamps one utterance on its way from the end of
+// speech to the hint on glass. Only the hint goroutine touches it once
+// generation starts.
+type utteranceTiming struct {
+	source string // "asr" or "transcript"
+	// speechEnd is the last audio frame sent to ASR before the final, or the
+	// final itself for a client transcript.
+	speechEnd    time.Time
+	final        time.Time
+	firstToken   time.Time
+	firstPartial time.Time
+	hint         time.Time
+}
+
+func newUtteranceTiming(source string, speechEnd time.Time) *utteranceTiming {
+	now := time.Now()
+	if speechEnd.IsZero() || speechEnd.After(now) {
+		speechEnd = now
+	}
+	return &utteranceTiming{source: source, speechEnd: speechEnd, final: now}
+}
+
+// report records every stage reached in obs.UtteranceLatency and returns
+// the breakdown as a Timing message.
+func (t *utteranceTiming) report(traceID string) protocol.Timing {
+	msg := protocol.NewTiming(t.source)
+	msg.TraceID = traceID
+	msg.FinalMs = t.observe("final", t.final)
+	msg.FirstTokenMs = t.observe("first_token", t.firstToken)
+	msg.FirstPartialMs = t.observe("first_partial", t.firstPartial)
+	msg.HintMs = t.observe("hint", t.hint)
+	return msg
+}
+
+// observe returns the milliseconds from the end of speech to at, at least 1
+// so a stage that was reached is never mistaken for one that was not; 0 if
+// at is zero.
+func (t *utteranceTiming) observe(stage string, at time.Time) int64 {
+	if at.IsZero() {
+		return 0
+	}
+	d := at.Sub(t.speechEnd)
+	obs.UtteranceLatency.With(stage).ObserveDuration(d)
+	if ms := d.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}